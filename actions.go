@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+)
+
+// listActionExecutions returns all action executions of a pipeline execution.
+func listActionExecutions(ctx context.Context, cp codepipeline.ListActionExecutionsAPIClient,
+	pipeline, executionID string) (
+	[]types.ActionExecutionDetail, error) {
+	var details []types.ActionExecutionDetail
+	pages := codepipeline.NewListActionExecutionsPaginator(cp,
+		&codepipeline.ListActionExecutionsInput{
+			PipelineName: aws.String(pipeline),
+			Filter: &types.ActionExecutionFilter{
+				PipelineExecutionId: aws.String(executionID),
+			},
+		})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		details = append(details, page.ActionExecutionDetails...)
+	}
+	return details, nil
+}
+
+func actionCategory(a types.ActionExecutionDetail) types.ActionCategory {
+	if a.Input == nil || a.Input.ActionTypeId == nil {
+		return ""
+	}
+	return a.Input.ActionTypeId.Category
+}
+
+// approvalState combines the execution's status with the state of its manual
+// approval actions: an execution awaiting approval or deploying after one is
+// pending, a rejected one has failed even if the execution didn't end yet.
+// The description tells a pending status waiting for a human apart from one
+// that's deploying. The state is empty if approvals don't decide it.
+func approvalState(status string, actions []types.ActionExecutionDetail) (string, string) {
+	var awaiting, approved, rejected bool
+	for _, a := range actions {
+		if actionCategory(a) != types.ActionCategoryApproval {
+			continue
+		}
+		switch a.Status {
+		case types.ActionExecutionStatusInProgress:
+			awaiting = true
+		case types.ActionExecutionStatusSucceeded:
+			approved = true
+		case types.ActionExecutionStatusFailed:
+			rejected = true
+		}
+	}
+	switch {
+	case rejected:
+		return "failure", "Approval rejected"
+	case status != string(types.PipelineExecutionStatusInProgress):
+		return "", ""
+	case awaiting:
+		return "pending", "Awaiting approval"
+	case approved:
+		return "pending", "Approved, deploying"
+	}
+	return "", ""
+}
+
+// attemptDescription numbers retried executions, e.g. "Attempt 2", based on
+// the manual and automatic retries of its most retried stage. The pipeline's
+// state only knows the retries of each stage's latest execution, so stages a
+// newer execution has entered since don't count. It returns an empty string
+// for the first attempt.
+func attemptDescription(ctx context.Context, cp codepipelineAPI, pipeline,
+	executionID string) (string, error) {
+	res, err := cp.GetPipelineState(ctx, &codepipeline.GetPipelineStateInput{
+		Name: aws.String(pipeline),
+	})
+	if err != nil {
+		return "", err
+	}
+	attempt := 1
+	for _, s := range res.StageStates {
+		e, m := s.LatestExecution, s.RetryStageMetadata
+		if e == nil || m == nil || aws.ToString(e.PipelineExecutionId) != executionID {
+			continue
+		}
+		if n := 1 + int(aws.ToInt32(m.AutoStageRetryAttempt)+
+			aws.ToInt32(m.ManualStageRetryAttempt)); n > attempt {
+			attempt = n
+		}
+	}
+	if attempt == 1 {
+		return "", nil
+	}
+	return fmt.Sprintf("Attempt %d", attempt), nil
+}
+
+// failureDescription names the first failed action of the execution and
+// why it failed, e.g. "Build: exit code 2". It returns an empty string if no
+// action failed.
+func failureDescription(actions []types.ActionExecutionDetail) string {
+	for _, s := range stageResults(actions) {
+		a := s.Failed
+		if a == nil {
+			continue
+		}
+		reason := "failed"
+		if o := a.Output; o != nil && o.ExecutionResult != nil {
+			r := o.ExecutionResult
+			if e := r.ErrorDetails; e != nil && aws.ToString(e.Message) != "" {
+				reason = aws.ToString(e.Message)
+			} else if summary := aws.ToString(r.ExternalExecutionSummary); summary != "" {
+				reason = summary
+			}
+		}
+		// Error messages may span several lines.
+		reason = strings.Join(strings.Fields(reason), " ")
+		return fmt.Sprintf("%s: %s", aws.ToString(a.ActionName), reason)
+	}
+	return ""
+}
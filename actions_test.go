@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+)
+
+// actionExecution returns the detail of an action's execution as
+// ListActionExecutions lists it.
+func actionExecution(stage, action string, category types.ActionCategory,
+	status types.ActionExecutionStatus) types.ActionExecutionDetail {
+	return types.ActionExecutionDetail{
+		StageName:  aws.String(stage),
+		ActionName: aws.String(action),
+		Status:     status,
+		Input: &types.ActionExecutionInput{
+			ActionTypeId: &types.ActionTypeId{Category: category},
+		},
+	}
+}
+
+var (
+	buildSucceeded = actionExecution("Build", "Build", types.ActionCategoryBuild,
+		types.ActionExecutionStatusSucceeded)
+	approvalAwaited = actionExecution("Approve", "Review", types.ActionCategoryApproval,
+		types.ActionExecutionStatusInProgress)
+	approvalGiven = actionExecution("Approve", "Review", types.ActionCategoryApproval,
+		types.ActionExecutionStatusSucceeded)
+	approvalRejected = actionExecution("Approve", "Review", types.ActionCategoryApproval,
+		types.ActionExecutionStatusFailed)
+	deploying = actionExecution("Deploy", "Deploy", types.ActionCategoryDeploy,
+		types.ActionExecutionStatusInProgress)
+)
+
+func TestApprovalState(t *testing.T) {
+	tests := []struct {
+		name               string
+		status             string
+		actions            []types.ActionExecutionDetail
+		state, description string
+	}{
+		{"approval pending", "InProgress",
+			[]types.ActionExecutionDetail{approvalAwaited, buildSucceeded},
+			"pending", "Awaiting approval"},
+		{"approved, deploying", "InProgress",
+			[]types.ActionExecutionDetail{deploying, approvalGiven, buildSucceeded},
+			"pending", "Approved, deploying"},
+		{"rejected", "InProgress",
+			[]types.ActionExecutionDetail{approvalRejected, buildSucceeded},
+			"failure", "Approval rejected"},
+		{"rejected and failed", "Failed",
+			[]types.ActionExecutionDetail{approvalRejected, buildSucceeded},
+			"failure", "Approval rejected"},
+		{"approved and succeeded", "Succeeded",
+			[]types.ActionExecutionDetail{approvalGiven, buildSucceeded}, "", ""},
+		{"building", "InProgress", []types.ActionExecutionDetail{buildSucceeded}, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, description := approvalState(tt.status, tt.actions)
+			if state != tt.state || description != tt.description {
+				t.Errorf("approvalState() = %q, %q, want %q, %q", state, description,
+					tt.state, tt.description)
+			}
+		})
+	}
+}
+
+// TestHandleApprovalTransitions follows an execution through its approval:
+// pending while awaiting it and while deploying, failed once rejected.
+func TestHandleApprovalTransitions(t *testing.T) {
+	tests := []struct {
+		name               string
+		actions            []types.ActionExecutionDetail
+		state, description string
+	}{
+		{"approval pending", []types.ActionExecutionDetail{approvalAwaited, buildSucceeded},
+			"pending", "Awaiting approval"},
+		{"approved, deploying", []types.ActionExecutionDetail{deploying, approvalGiven, buildSucceeded},
+			"pending", "Approved, deploying"},
+		{"rejected", []types.ActionExecutionDetail{approvalRejected, buildSucceeded},
+			"failure", "Approval rejected"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := withExecutionID(loadExecution(t, "connection"),
+				fmt.Sprintf("a0000000-0000-4000-8000-00000000000%d", i))
+			cp := newFakeCodePipeline(ex)
+			cp.actions = tt.actions
+			h, gh := newTestHandler(t, cp)
+			err := h.Handle(context.Background(), event{Pipeline: "hello-world",
+				ExecutionID: aws.ToString(ex.PipelineExecutionId), TrackApprovals: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := gh.statuses("octo-org/hello-world", "7638417db6d59f3c431d3e1f261cc637155684cd")
+			if len(got) != 1 {
+				t.Fatalf("got %d statuses, want 1", len(got))
+			}
+			if got[0]["state"] != tt.state || got[0]["description"] != tt.description {
+				t.Errorf("status = %v, want %s %q", got[0], tt.state, tt.description)
+			}
+		})
+	}
+}
+
+// stageState returns the state of a stage whose latest execution is id and
+// was retried automatically and manually as often as given.
+func stageState(stage, id string, auto, manual int32) types.StageState {
+	return types.StageState{
+		StageName: aws.String(stage),
+		LatestExecution: &types.StageExecution{
+			PipelineExecutionId: aws.String(id),
+			Status:              types.StageExecutionStatusInProgress,
+		},
+		RetryStageMetadata: &types.RetryStageMetadata{
+			AutoStageRetryAttempt:   aws.Int32(auto),
+			ManualStageRetryAttempt: aws.Int32(manual),
+		},
+	}
+}
+
+func TestAttemptDescription(t *testing.T) {
+	const id = "b0000000-0000-4000-8000-000000000001"
+	tests := []struct {
+		name   string
+		stages []types.StageState
+		want   string
+	}{
+		{"first attempt", []types.StageState{stageState("Build", id, 0, 0)}, ""},
+		{"no retry metadata", []types.StageState{{StageName: aws.String("Build"),
+			LatestExecution: &types.StageExecution{PipelineExecutionId: aws.String(id)}}}, ""},
+		{"manual retry", []types.StageState{stageState("Build", id, 0, 1)}, "Attempt 2"},
+		{"most retried stage", []types.StageState{
+			stageState("Build", id, 1, 0), stageState("Deploy", id, 1, 2),
+		}, "Attempt 4"},
+		{"other execution's retries", []types.StageState{
+			stageState("Build", id, 0, 1), stageState("Deploy", "newer", 0, 5),
+		}, "Attempt 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp := newFakeCodePipeline()
+			cp.state = &codepipeline.GetPipelineStateOutput{StageStates: tt.stages}
+			got, err := attemptDescription(context.Background(), cp, "hello-world", id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("attemptDescription() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleNumberAttempts(t *testing.T) {
+	const id = "b0000000-0000-4000-8000-000000000002"
+	cp := newFakeCodePipeline(withExecutionID(loadExecution(t, "connection"), id))
+	cp.state = &codepipeline.GetPipelineStateOutput{StageStates: []types.StageState{
+		stageState("Source", id, 0, 0), stageState("Build", id, 0, 1),
+	}}
+	h, gh := newTestHandler(t, cp)
+	err := h.Handle(context.Background(), event{Pipeline: "hello-world", ExecutionID: id,
+		NumberAttempts: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := gh.statuses("octo-org/hello-world", "7638417db6d59f3c431d3e1f261cc637155684cd")
+	if len(got) != 1 || got[0]["description"] != "Attempt 2" {
+		t.Errorf("statuses = %v, want one described as Attempt 2", got)
+	}
+}
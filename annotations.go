@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codebuild"
+	cbtypes "github.com/aws/aws-sdk-go-v2/service/codebuild/types"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+)
+
+// GitHub accepts at most this many annotations per request.
+const maxAnnotations = 50
+
+// ghAnnotation marks a line of a file in a check run.
+type ghAnnotation struct {
+	Path            string `json:"path"`
+	StartLine       int    `json:"start_line"`
+	EndLine         int    `json:"end_line"`
+	AnnotationLevel string `json:"annotation_level"`
+	Title           string `json:"title"`
+	Message         string `json:"message"`
+}
+
+type codebuildAPI interface {
+	BatchGetBuilds(context.Context, *codebuild.BatchGetBuildsInput,
+		...func(*codebuild.Options)) (*codebuild.BatchGetBuildsOutput, error)
+	codebuild.DescribeTestCasesAPIClient
+}
+
+// fileLine finds a location like pkg/foo_test.go:42 in test output.
+var fileLine = regexp.MustCompile(`([\w./-]+\.\w+):(\d+)`)
+
+// buildDirPrefix matches the directories CodeBuild checks sources out to,
+// e.g. /codebuild/output/src123/src/github.com/acme/app/, which paths in test
+// output start with.
+var buildDirPrefix = regexp.MustCompile(`^/codebuild/output/src\d+/src/(?:[^/]+\.[^/]+/[^/]+/[^/]+/)?`)
+
+// testAnnotations returns annotations for the test cases that failed in the
+// test reports of the failed CodeBuild actions, at the file and line their
+// message points to. Failed test cases whose message names no location are
+// left out.
+func testAnnotations(ctx context.Context, cb codebuildAPI, actions []types.ActionExecutionDetail) (
+	[]ghAnnotation, error) {
+	var ids []string
+	for _, s := range stageResults(actions) {
+		a := s.Failed
+		if a == nil || !isCodeBuild(a) || a.Output == nil || a.Output.ExecutionResult == nil {
+			continue
+		}
+		if id := aws.ToString(a.Output.ExecutionResult.ExternalExecutionId); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	res, err := cb.BatchGetBuilds(ctx, &codebuild.BatchGetBuildsInput{Ids: ids})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get builds: %w", err)
+	}
+
+	var annotations []ghAnnotation
+	for _, b := range res.Builds {
+		for _, report := range b.ReportArns {
+			for _, status := range []string{"FAILED", "ERROR"} {
+				pages := codebuild.NewDescribeTestCasesPaginator(cb, &codebuild.DescribeTestCasesInput{
+					ReportArn: aws.String(report),
+					Filter:    &cbtypes.TestCaseFilter{Status: aws.String(status)},
+				})
+				for pages.HasMorePages() && len(annotations) < maxAnnotations {
+					page, err := pages.NextPage(ctx)
+					if err != nil {
+						return nil, fmt.Errorf("failed to describe test cases of %s: %w", report, err)
+					}
+					for _, tc := range page.TestCases {
+						if a, ok := testCaseAnnotation(tc); ok && len(annotations) < maxAnnotations {
+							annotations = append(annotations, a)
+						}
+					}
+				}
+			}
+		}
+	}
+	return annotations, nil
+}
+
+func testCaseAnnotation(tc cbtypes.TestCase) (ghAnnotation, bool) {
+	msg := aws.ToString(tc.Message)
+	m := fileLine.FindStringSubmatch(msg)
+	if m == nil {
+		return ghAnnotation{}, false
+	}
+	line, err := strconv.Atoi(m[2])
+	if err != nil || line < 1 {
+		return ghAnnotation{}, false
+	}
+	title := aws.ToString(tc.Name)
+	if p := aws.ToString(tc.Prefix); p != "" {
+		title = p + " " + title
+	}
+	return ghAnnotation{
+		Path:            strings.TrimPrefix(buildDirPrefix.ReplaceAllString(m[1], ""), "./"),
+		StartLine:       line,
+		EndLine:         line,
+		AnnotationLevel: "failure",
+		Title:           title,
+		Message:         msg,
+	}, true
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"time"
+)
+
+// defaultGithubAPIVersion is the version of the GitHub REST API requested
+// unless GITHUB_API_VERSION selects another, e.g. to try an upcoming one.
+const defaultGithubAPIVersion = "2022-11-28"
+
+func githubAPIVersion() string {
+	if v := os.Getenv("GITHUB_API_VERSION"); v != "" {
+		return v
+	}
+	return defaultGithubAPIVersion
+}
+
+func validateGithubAPIVersion() error {
+	v := githubAPIVersion()
+	if _, err := time.Parse(time.DateOnly, v); err != nil {
+		return fmt.Errorf("invalid GITHUB_API_VERSION %q, expected a date like %s", v,
+			defaultGithubAPIVersion)
+	}
+	return nil
+}
+
+type requestHeadersKey struct{}
+
+// withGithubHeaders returns a context whose requests, see attemptRequest,
+// pin the GitHub API version and ask for GitHub's media type.
+func withGithubHeaders(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestHeadersKey{}, http.Header{
+		"Accept":               {"application/vnd.github+json"},
+		"X-Github-Api-Version": {githubAPIVersion()},
+	})
+}
+
+// requestHeaders returns the headers carried by ctx, if any.
+func requestHeaders(ctx context.Context) http.Header {
+	h, _ := ctx.Value(requestHeadersKey{}).(http.Header)
+	return h
+}
+
+// ghResponse is implemented by decoded GitHub responses that check the
+// fields they rely on. Fields that aren't declared are ignored, so that
+// additions in later API versions don't break decoding.
+type ghResponse interface {
+	check() error
+}
+
+// checkResponse checks the decoded response v, if it's a ghResponse, or each
+// of its elements if it's a list.
+func checkResponse(v interface{}) error {
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer &&
+		rv.Elem().Kind() == reflect.Slice {
+		for i := 0; i < rv.Elem().Len(); i++ {
+			if err := checkResponse(rv.Elem().Index(i).Addr().Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	r, ok := v.(ghResponse)
+	if !ok {
+		return nil
+	}
+	if err := r.check(); err != nil {
+		return fmt.Errorf("unexpected response from GitHub API version %s: %w",
+			githubAPIVersion(), err)
+	}
+	return nil
+}
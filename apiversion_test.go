@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckResponse(t *testing.T) {
+	tests := []struct {
+		name  string
+		v     interface{}
+		valid bool
+	}{
+		{"commit", &ghCommit{SHA: "6dcb09b5b57875f334f61aebed695e2e4193db5e"}, true},
+		{"commit without sha", &ghCommit{}, false},
+		{"created status", &ghStatusRef{ID: 1, URL: "https://api.github.com/repos/o/r/statuses/1"}, true},
+		{"created status without url", &ghStatusRef{ID: 1}, false},
+		{"statuses", &[]ghCommitStatus{{State: "success", Context: "ci"}}, true},
+		{"status without context", &[]ghCommitStatus{{State: "success"}}, false},
+		{"check runs", &ghCheckRuns{CheckRuns: []ghCheckRunRef{{ID: 4, URL: "u"}}}, true},
+		{"check run without id", &ghCheckRuns{CheckRuns: []ghCheckRunRef{{URL: "u"}}}, false},
+		{"no check runs", &ghCheckRuns{}, true},
+		{"deployment", &ghDeployment{ID: 7}, true},
+		{"deployments without id", &[]ghDeployment{{ID: 7}, {}}, false},
+		{"deployment status without state", &ghDeploymentStatus{}, false},
+		{"pulls", &[]ghPullRequest{{Number: 1, State: "open"}}, true},
+		{"pull without state", &[]ghPullRequest{{Number: 1}}, false},
+		{"pull without head", &ghWebhookPullRequest{Number: 1}, false},
+		{"comment without id", &[]ghIssueComment{{Body: "b"}}, false},
+		{"installation token", &ghInstallationToken{Token: "ghs_x", ExpiresAt: time.Now()}, true},
+		{"installation token without expiry", &ghInstallationToken{Token: "ghs_x"}, false},
+		{"unchecked", &struct{ Name string }{}, true},
+	}
+	for _, tt := range tests {
+		err := checkResponse(tt.v)
+		if valid := err == nil; valid != tt.valid {
+			t.Errorf("%s: checkResponse() = %v, want valid %t", tt.name, err, tt.valid)
+		}
+	}
+}
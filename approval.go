@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+)
+
+// approvalContext is the context of the status of manual approvals.
+const approvalContext = "codepipeline/approval"
+
+// approvalPayload returns the status of the execution's latest manual
+// approval, or nil if it has none. Pending approvals link to the pipeline's
+// page, where they're reviewed. An action event about the approval takes
+// precedence over its action execution, which may lag behind.
+func approvalPayload(ev event, actions []types.ActionExecutionDetail, reviewURL, deepLink string) *ghReqPayload {
+	var stage, action string
+	var status types.ActionExecutionStatus
+	// Actions come newest first.
+	for _, a := range actions {
+		if actionCategory(a) == types.ActionCategoryApproval {
+			stage, action, status = aws.ToString(a.StageName), aws.ToString(a.ActionName), a.Status
+			break
+		}
+	}
+	if ev.ApprovalStatus && ev.Action != "" {
+		stage, action, status = ev.Stage, ev.Action, stageEventStatuses[ev.ActionState]
+	}
+	if action == "" {
+		return nil
+	}
+
+	p := ghReqPayload{Context: approvalContext, TargetURL: deepLink, Stage: stage}
+	switch status {
+	case types.ActionExecutionStatusInProgress:
+		p.State = "pending"
+		p.Description = fmt.Sprintf("Awaiting approval of %s", action)
+		p.TargetURL = reviewURL
+	case types.ActionExecutionStatusSucceeded:
+		p.State = "success"
+		p.Description = fmt.Sprintf("%s approved", action)
+	case types.ActionExecutionStatusFailed:
+		p.State = "failure"
+		p.Description = fmt.Sprintf("%s rejected", action)
+	default:
+		return nil
+	}
+	p.Description = truncateDescription(p.Description)
+	return &p
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline"
+)
+
+// The CodePipeline clients of assumed roles and other regions, keyed by role,
+// external ID and region. Their credentials are cached and refreshed before
+// they expire, so warm invocations don't assume the role again.
+var assumedClients = struct {
+	sync.Mutex
+	m map[string]*codepipeline.Client
+}{m: map[string]*codepipeline.Client{}}
+
+// roleARN returns the role to assume to call CodePipeline for the event: the
+// event's role-arn or, for events from another account than the function's,
+// ASSUME_ROLE_ARN rendered with the account, e.g.
+// "arn:aws:iam::{{.Account}}:role/codepipeline-github-status". It returns an
+// empty string if the function's own role is used.
+func (ev event) roleARN(ctx context.Context) (string, error) {
+	if ev.RoleARN != "" {
+		return ev.RoleARN, nil
+	}
+	tmpl := os.Getenv("ASSUME_ROLE_ARN")
+	if tmpl == "" || ev.Account == "" || ev.Account == functionAccount(ctx) {
+		return "", nil
+	}
+	t, err := template.New("assume-role-arn").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("invalid ASSUME_ROLE_ARN: %w", err)
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, struct{ Account string }{ev.Account}); err != nil {
+		return "", fmt.Errorf("failed to render ASSUME_ROLE_ARN: %w", err)
+	}
+	return b.String(), nil
+}
+
+// functionAccount returns the account of the invoked function, if known.
+func functionAccount(ctx context.Context) string {
+	lc, ok := lambdacontext.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	// arn:aws:lambda:<region>:<account>:function:<name>
+	if parts := strings.Split(lc.InvokedFunctionArn, ":"); len(parts) > 4 {
+		return parts[4]
+	}
+	return ""
+}
+
+// pipelineRegion returns the region of the event's pipeline.
+func (ev event) pipelineRegion() string {
+	if ev.Region != "" {
+		return ev.Region
+	}
+	return awsConfig.Region
+}
+
+// codepipelineClientFor returns a CodePipeline client for the region of the
+// event's pipeline using the role, if any, with the event's external-id or
+// ASSUME_ROLE_EXTERNAL_ID as external ID.
+func codepipelineClientFor(ev event, role string) *codepipeline.Client {
+	externalID := ev.ExternalID
+	if externalID == "" {
+		externalID = os.Getenv("ASSUME_ROLE_EXTERNAL_ID")
+	}
+	region := ev.pipelineRegion()
+	key := strings.Join([]string{role, externalID, region}, "\x00")
+	assumedClients.Lock()
+	defer assumedClients.Unlock()
+	if c, ok := assumedClients.m[key]; ok {
+		return c
+	}
+	c := codepipeline.New(codepipelineClient.Options(), func(o *codepipeline.Options) {
+		o.Region = region
+		if role == "" {
+			return
+		}
+		creds := stscreds.NewAssumeRoleProvider(stsClient, role, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = "codepipeline-github-status"
+			if externalID != "" {
+				o.ExternalID = aws.String(externalID)
+			}
+		})
+		o.Credentials = aws.NewCredentialsCache(creds)
+	})
+	assumedClients.m[key] = c
+	return c
+}
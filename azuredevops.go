@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/codestarconnections/types"
+)
+
+// providerTypeAzureDevOps is the provider type of CodeStar connections to
+// Azure DevOps, which the SDK has no constant for.
+const providerTypeAzureDevOps types.ProviderType = "AzureDevOps"
+
+func init() {
+	registerProvider(providerRegistration{
+		name:            providerAzure,
+		hosts:           []string{"dev.azure.com"},
+		hostEnv:         "AZURE_DEVOPS_HOSTNAME",
+		connectionTypes: []types.ProviderType{providerTypeAzureDevOps},
+		newProvider: func(ctx context.Context, ev event) (provider, error) {
+			token, err := providerToken(ctx, "AZURE_DEVOPS")
+			if err != nil {
+				return nil, err
+			}
+			return &azureProvider{token: token}, nil
+		},
+	})
+	registerExtractor(repoExtractor{
+		name: "azure-devops",
+		matches: func(u *url.URL) bool {
+			return u.Hostname() == "dev.azure.com" || u.Hostname() == os.Getenv("AZURE_DEVOPS_HOSTNAME")
+		},
+		extract: repoFromAzureURL,
+	})
+}
+
+func azureAPIBaseURL() string {
+	if base := os.Getenv("AZURE_DEVOPS_API_BASE_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	if host := os.Getenv("AZURE_DEVOPS_HOSTNAME"); host != "" {
+		return "https://" + host
+	}
+	return "https://dev.azure.com"
+}
+
+// repoFromAzureURL handles Azure Repos commit URLs, e.g.
+// https://dev.azure.com/org/project/_git/repo/commit/<sha>. Repositories are
+// identified as org/project/repo, like in the FullRepositoryId of CodeStar
+// connections.
+func repoFromAzureURL(u *url.URL) (string, error) {
+	p := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(p) < 4 || p[2] != "_git" || p[0] == "" || p[1] == "" || p[3] == "" {
+		return "", fmt.Errorf("unexpected URL path: %v", u.Path)
+	}
+	return strings.Join([]string{p[0], p[1], strings.TrimSuffix(p[3], ".git")}, "/"), nil
+}
+
+type azureStatus struct {
+	State       string `json:"state"`
+	Description string `json:"description,omitempty"`
+	TargetURL   string `json:"targetUrl,omitempty"`
+	Context     struct {
+		Name  string `json:"name"`
+		Genre string `json:"genre,omitempty"`
+	} `json:"context"`
+}
+
+// azureProvider posts commit statuses to Azure Repos, authenticated with a
+// personal access token.
+type azureProvider struct {
+	token string
+}
+
+func (p *azureProvider) name() string {
+	return providerAzure
+}
+
+func (p *azureProvider) report(ctx context.Context, repo, rev string, payload ghReqPayload) (string, error) {
+	parts := strings.Split(repo, "/")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("invalid Azure DevOps repository %q, expected org/project/repo", repo)
+	}
+	st := azureStatus{
+		Description: payload.Description,
+		TargetURL:   payload.TargetURL,
+	}
+	// The part of the context before the last slash is the genre, e.g.
+	// continuous-integration of continuous-integration/codepipeline.
+	if i := strings.LastIndex(payload.Context, "/"); i >= 0 {
+		st.Context.Genre, st.Context.Name = payload.Context[:i], payload.Context[i+1:]
+	} else {
+		st.Context.Name = payload.Context
+	}
+	switch payload.State {
+	case "pending":
+		st.State = "pending"
+	case "success":
+		st.State = "succeeded"
+	case "failure":
+		st.State = "failed"
+	default:
+		st.State = "error"
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return "", err
+	}
+	azURL := fmt.Sprintf("%s/%s/%s/_apis/git/repositories/%s/commits/%s/statuses?api-version=7.1",
+		azureAPIBaseURL(), url.PathEscape(parts[0]), url.PathEscape(parts[1]),
+		url.PathEscape(parts[2]), rev)
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(":"+p.token))
+	res, resBody, err := doRequest(ctx, "POST", azURL, auth, b)
+	if err != nil {
+		return "", err
+	}
+	if err := expectStatus("Azure DevOps", res, resBody, http.StatusOK, http.StatusCreated); err != nil {
+		return "", err
+	}
+	var created struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(resBody, &created); err != nil {
+		return "", nil
+	}
+	return created.URL, nil
+}
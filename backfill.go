@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+)
+
+// backfill repairs the statuses of the pipeline's executions that finished
+// and were started within the given duration, e.g. after the function was
+// down. Executions are handled newest first, and only the newest execution
+// of a revision is, so that older executions don't replace its status.
+// Statuses already in the right state aren't posted again.
+func backfill(ctx context.Context, cp codepipeline.ListPipelineExecutionsAPIClient,
+	pipeline string, within time.Duration) error {
+	since := time.Now().Add(-within)
+	seen := map[string]bool{}
+	var failed []string
+	pages := codepipeline.NewListPipelineExecutionsPaginator(cp,
+		&codepipeline.ListPipelineExecutionsInput{PipelineName: aws.String(pipeline)})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list executions of %s: %w", pipeline, err)
+		}
+		for _, e := range page.PipelineExecutionSummaries {
+			if aws.ToTime(e.StartTime).Before(since) {
+				// Executions are listed newest first.
+				return backfillResult(failed)
+			}
+			if e.Status == types.PipelineExecutionStatusInProgress ||
+				e.Status == types.PipelineExecutionStatusStopping {
+				continue
+			}
+			covered := len(e.SourceRevisions) > 0
+			for _, r := range e.SourceRevisions {
+				covered = covered && seen[aws.ToString(r.RevisionId)]
+				seen[aws.ToString(r.RevisionId)] = true
+			}
+			if covered {
+				continue
+			}
+			id := aws.ToString(e.PipelineExecutionId)
+			err := HandleLambdaEvent(ctx, event{Pipeline: pipeline, ExecutionID: id, Repair: true})
+			if err != nil {
+				failed = append(failed, id)
+			}
+		}
+	}
+	return backfillResult(failed)
+}
+
+func backfillResult(failed []string) error {
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to repair the statuses of executions %s",
+			strings.Join(failed, ", "))
+	}
+	return nil
+}
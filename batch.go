@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const defaultBatchConcurrency = 4
+
+// batchConcurrency returns how many records of a batch are handled at once,
+// from BATCH_CONCURRENCY.
+func batchConcurrency() int {
+	n, err := strconv.Atoi(os.Getenv("BATCH_CONCURRENCY"))
+	if err != nil || n < 1 {
+		return defaultBatchConcurrency
+	}
+	return n
+}
+
+// isSQSBatch reports whether the payload is a batch of SQS messages, and
+// decodes it if so.
+func isSQSBatch(payload json.RawMessage) (events.SQSEvent, bool) {
+	var batch events.SQSEvent
+	if err := json.Unmarshal(payload, &batch); err != nil || len(batch.Records) == 0 ||
+		batch.Records[0].EventSource != "aws:sqs" {
+		return batch, false
+	}
+	return batch, true
+}
+
+// HandleInvocation is the Lambda function's entry point. It handles single
+// events like HandleLambdaEvent, SQS batches like HandleSQSBatch, HTTP
+// requests to /sync like HandleSyncRequest, other HTTP requests as GitHub
+// webhook deliveries like HandleWebhook, scheduled events by reconciling
+// statuses, see reconcile, and self-test events, see selfTest.
+func HandleInvocation(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	defer flushTelemetry(ctx)
+	if batch, ok := isSQSBatch(payload); ok {
+		return HandleSQSBatch(ctx, batch), nil
+	}
+	if isSelfTest(payload) {
+		return selfTest(ctx, codepipelineClient), nil
+	}
+	if isScheduledEvent(payload) {
+		return nil, reconcile(ctx, codepipelineClient)
+	}
+	if req, ok := isHTTPRequest(payload); ok {
+		if isSyncRequest(req) {
+			return newHandler().HandleSyncRequest(ctx, req)
+		}
+		return newHandler().HandleWebhook(ctx, req)
+	}
+	var ev event
+	if err := json.Unmarshal(payload, &ev); err != nil {
+		// Retrying a malformed event can't help.
+		logger(ctx).Error("invalid event", "error", err)
+		return nil, retryable(permanent(err))
+	}
+	return nil, retryable(HandleLambdaEvent(ctx, ev))
+}
+
+// HandleSQSBatch handles a batch of SQS messages, each carrying an event, with
+// up to BATCH_CONCURRENCY at a time. Failed messages are reported as batch
+// item failures, so that only those are retried.
+func HandleSQSBatch(ctx context.Context, batch events.SQSEvent) events.SQSEventResponse {
+	var mu sync.Mutex
+	var res events.SQSEventResponse
+	failed := func(msg events.SQSMessage) {
+		mu.Lock()
+		defer mu.Unlock()
+		res.BatchItemFailures = append(res.BatchItemFailures,
+			events.SQSBatchItemFailure{ItemIdentifier: msg.MessageId})
+	}
+
+	sem := make(chan struct{}, batchConcurrency())
+	var wg sync.WaitGroup
+	for _, msg := range batch.Records {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(msg events.SQSMessage) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			var ev event
+			if err := json.Unmarshal([]byte(msg.Body), &ev); err != nil {
+				logger(ctx).Error("failed to decode message", "message-id", msg.MessageId,
+					"error", err)
+				failed(msg)
+				return
+			}
+			if err := retryable(HandleLambdaEvent(ctx, ev)); err != nil {
+				failed(msg)
+			}
+		}(msg)
+	}
+	wg.Wait()
+	return res
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/codestarconnections/types"
+)
+
+func bitbucketAPIBaseURL() string {
+	if base := os.Getenv("BITBUCKET_API_BASE_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	return "https://api.bitbucket.org/2.0"
+}
+
+type bbBuildStatus struct {
+	Key         string `json:"key"`
+	State       string `json:"state"`
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+}
+
+func init() {
+	registerProvider(providerRegistration{
+		name:            providerBitbucket,
+		hosts:           []string{"bitbucket.org"},
+		connectionTypes: []types.ProviderType{types.ProviderTypeBitbucket},
+		newProvider: func(ctx context.Context, ev event) (provider, error) {
+			token, err := providerToken(ctx, "BITBUCKET")
+			if err != nil {
+				return nil, err
+			}
+			return &bitbucketProvider{token: token}, nil
+		},
+	})
+}
+
+// bitbucketProvider posts build statuses to Bitbucket Cloud.
+type bitbucketProvider struct {
+	token string
+}
+
+func (p *bitbucketProvider) name() string {
+	return providerBitbucket
+}
+
+func (p *bitbucketProvider) report(ctx context.Context, repo, rev string, payload ghReqPayload) (string, error) {
+	st := bbBuildStatus{
+		Key:         payload.Context,
+		URL:         payload.TargetURL,
+		Description: payload.Description,
+	}
+	switch payload.State {
+	case "pending":
+		st.State = "INPROGRESS"
+	case "success":
+		st.State = "SUCCESSFUL"
+	default:
+		st.State = "FAILED"
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return "", err
+	}
+	bbURL := fmt.Sprintf("%s/repositories/%s/commit/%s/statuses/build",
+		bitbucketAPIBaseURL(), repo, rev)
+	res, resBody, err := doRequest(ctx, "POST", bbURL, "Bearer "+p.token, b)
+	if err != nil {
+		return "", err
+	}
+	// Bitbucket answers 200 when updating an existing status with the key.
+	if err := expectStatus("Bitbucket", res, resBody, http.StatusOK, http.StatusCreated); err != nil {
+		return "", err
+	}
+	var created struct {
+		Links struct {
+			Self struct {
+				Href string `json:"href"`
+			} `json:"self"`
+		} `json:"links"`
+	}
+	if err := json.Unmarshal(resBody, &created); err != nil {
+		return "", nil
+	}
+	return created.Links.Self.Href, nil
+}
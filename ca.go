@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// configureCABundle makes requests to GitHub and the other providers trust
+// the PEM certificates of CA_BUNDLE, or of the Secrets Manager secret named
+// by CA_BUNDLE_SECRET_ARN, in addition to the system's, e.g. those of a
+// TLS-inspecting proxy.
+func configureCABundle(ctx context.Context) error {
+	pem := os.Getenv("CA_BUNDLE")
+	if arn := os.Getenv("CA_BUNDLE_SECRET_ARN"); arn != "" {
+		var err error
+		if pem, err = secretString(ctx, arn); err != nil {
+			return fmt.Errorf("failed to read CA bundle from secret %s: %w", arn, err)
+		}
+	}
+	if pem == "" {
+		return nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		return fmt.Errorf("failed to load system certificates: %w", err)
+	}
+	if !pool.AppendCertsFromPEM([]byte(pem)) {
+		return errors.New("CA bundle holds no PEM certificates")
+	}
+	httpTransport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+)
+
+// checksMode reports whether GITHUB_REPORTER selects check runs rather than
+// commit statuses.
+func checksMode() (bool, error) {
+	switch r := os.Getenv("GITHUB_REPORTER"); r {
+	case "", "statuses":
+		return false, nil
+	case "checks":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid GITHUB_REPORTER %q", r)
+	}
+}
+
+// checkName is the name of the check run, which shows up in the UI and in
+// branch protection rules. It defaults to the status context, so that
+// required checks keep working when switching between the two modes.
+// CHECK_NAME only renames the check run of the overall execution.
+func checkName(payload ghReqPayload) string {
+	if n := os.Getenv("CHECK_NAME"); n != "" && payload.Stage == "" {
+		return n
+	}
+	return payload.Context
+}
+
+type ghCheckRunOutput struct {
+	Title       string         `json:"title"`
+	Summary     string         `json:"summary"`
+	Annotations []ghAnnotation `json:"annotations,omitempty"`
+}
+
+type ghCheckRun struct {
+	Name       string            `json:"name,omitempty"`
+	HeadSHA    string            `json:"head_sha,omitempty"`
+	Status     string            `json:"status"`
+	Conclusion string            `json:"conclusion,omitempty"`
+	DetailsURL string            `json:"details_url"`
+	ExternalID string            `json:"external_id"`
+	Output     *ghCheckRunOutput `json:"output,omitempty"`
+}
+
+type ghCheckRunRef struct {
+	ID         int64  `json:"id"`
+	ExternalID string `json:"external_id"`
+	URL        string `json:"url"`
+	App        *struct {
+		ID int64 `json:"id"`
+	} `json:"app"`
+}
+
+func (r *ghCheckRunRef) check() error {
+	if r.ID == 0 || r.URL == "" {
+		return errors.New("check run without id or url")
+	}
+	return nil
+}
+
+// ghCheckRuns is a page of a commit's check runs.
+type ghCheckRuns struct {
+	CheckRuns []ghCheckRunRef `json:"check_runs"`
+}
+
+func (l *ghCheckRuns) check() error {
+	return checkResponse(&l.CheckRuns)
+}
+
+// postCheckRun creates the check run of the execution on the commit, or
+// updates it if it exists, and returns its API URL. The check run is
+// identified by its name and the execution ID or, if CHECK_RUN_MODE is
+// per-commit, by its name only, so that each execution updates the same one,
+// see reusableCheckRun.
+func postCheckRun(ctx context.Context, repo, rev, token, executionID string, payload ghReqPayload) (string, error) {
+	run := ghCheckRun{
+		Status:     "in_progress",
+		DetailsURL: payload.TargetURL,
+		ExternalID: executionID,
+		Output: &ghCheckRunOutput{
+			Title:       payload.Description,
+			Summary:     payload.Summary,
+			Annotations: payload.Annotations,
+		},
+	}
+	switch payload.State {
+	case "pending":
+		if run.Output.Title == "" {
+			run.Output.Title = "In progress"
+		}
+	case "success":
+		run.Status = "completed"
+		run.Conclusion = "success"
+		if run.Output.Title == "" {
+			run.Output.Title = "Succeeded"
+		}
+	default:
+		run.Status = "completed"
+		run.Conclusion = "failure"
+		if run.Output.Title == "" {
+			run.Output.Title = "Failed"
+		}
+	}
+	if run.Output.Summary == "" {
+		run.Output.Summary = run.Output.Title
+	}
+
+	run.Name = checkName(payload)
+	appID, err := checkRunAppID()
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{"check_name": {run.Name}, "app_id": {strconv.FormatInt(appID, 10)}}
+	var existing ghCheckRuns
+	err = getJSON(ctx, fmt.Sprintf("%s/repos/%s/commits/%s/check-runs?%s",
+		githubAPIBaseURL(ctx), repo, rev, q.Encode()), token, &existing)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up check runs: %w", err)
+	}
+	var created ghCheckRunRef
+	perCommit := os.Getenv("CHECK_RUN_MODE") == "per-commit"
+	if r := reusableCheckRun(existing.CheckRuns, appID, executionID, perCommit); r != nil {
+		err := sendJSON(ctx, "PATCH", fmt.Sprintf("%s/repos/%s/check-runs/%d",
+			githubAPIBaseURL(ctx), repo, r.ID), token, run, 200, &created)
+		return created.URL, err
+	}
+	run.HeadSHA = rev
+	err = sendJSON(ctx, "POST", fmt.Sprintf("%s/repos/%s/check-runs", githubAPIBaseURL(ctx), repo),
+		token, run, 201, &created)
+	return created.URL, err
+}
+
+// checkRunAppID returns the ID of the GitHub App creating the check runs.
+func checkRunAppID() (int64, error) {
+	id, err := strconv.ParseInt(os.Getenv("GITHUB_APP_ID"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid GITHUB_APP_ID %q, check runs need the App's numeric ID",
+			os.Getenv("GITHUB_APP_ID"))
+	}
+	return id, nil
+}
+
+// reusableCheckRun returns the check run of the commit to update, or nil if
+// a new one is to be created: the execution's own or, if perCommit is set,
+// the newest one. Only check runs created by the App are considered, other
+// Apps may use the same name.
+func reusableCheckRun(runs []ghCheckRunRef, appID int64, executionID string,
+	perCommit bool) *ghCheckRunRef {
+	var newest *ghCheckRunRef
+	for i := range runs {
+		r := &runs[i]
+		if r.App == nil || r.App.ID != appID {
+			continue
+		}
+		if r.ExternalID == executionID {
+			return r
+		}
+		// IDs grow with creation time, the order of the list isn't
+		// documented.
+		if perCommit && (newest == nil || r.ID > newest.ID) {
+			newest = r
+		}
+	}
+	return newest
+}
+
+// checkRunSummary renders the latest run of each action of the execution as
+// a markdown table with its stage, status, duration and a link to its logs,
+// in the order the stages and actions started.
+func checkRunSummary(actions []types.ActionExecutionDetail) string {
+	stages := stageResults(actions)
+	if len(stages) == 0 {
+		return ""
+	}
+	byStage := map[string][]*types.ActionExecutionDetail{}
+	seen := map[string]bool{}
+	// Actions come newest first; only the latest run of an action counts.
+	for i := range actions {
+		a := &actions[i]
+		stage := aws.ToString(a.StageName)
+		if k := stage + "/" + aws.ToString(a.ActionName); !seen[k] {
+			seen[k] = true
+			byStage[stage] = append(byStage[stage], a)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("| Stage | Action | Status | Duration | Logs |\n| --- | --- | --- | --- | --- |\n")
+	for _, s := range stages {
+		as := byStage[s.Name]
+		sort.SliceStable(as, func(i, j int) bool {
+			return aws.ToTime(as[i].StartTime).Before(aws.ToTime(as[j].StartTime))
+		})
+		for _, a := range as {
+			var duration, logs string
+			if a.Status != types.ActionExecutionStatusInProgress && a.StartTime != nil &&
+				a.LastUpdateTime != nil {
+				duration = a.LastUpdateTime.Sub(*a.StartTime).Round(time.Second).String()
+			}
+			if u := actionLogsURL(a); u != "" {
+				logs = fmt.Sprintf("[Logs](%s)", u)
+			}
+			fmt.Fprintf(&b, "| %s | %s | %s | %s | %s |\n", s.Name, aws.ToString(a.ActionName),
+				a.Status, duration, logs)
+		}
+	}
+	return b.String()
+}
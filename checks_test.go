@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func checkRun(id, appID int64, executionID string) ghCheckRunRef {
+	r := ghCheckRunRef{ID: id, ExternalID: executionID, URL: "u"}
+	r.App = &struct {
+		ID int64 `json:"id"`
+	}{appID}
+	return r
+}
+
+func TestReusableCheckRun(t *testing.T) {
+	runs := []ghCheckRunRef{
+		checkRun(30, 99, "other-app"),
+		checkRun(10, 7, "exec-1"),
+		checkRun(20, 7, "exec-2"),
+		checkRun(40, 99, "exec-3"),
+	}
+	tests := []struct {
+		name        string
+		executionID string
+		perCommit   bool
+		want        int64
+	}{
+		{"own run", "exec-1", false, 10},
+		{"own run per commit", "exec-1", true, 10},
+		{"new execution", "exec-4", false, 0},
+		// Run 40 is newer, but another App's.
+		{"newest of the App", "exec-4", true, 20},
+		{"other App's execution", "exec-3", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got int64
+			if r := reusableCheckRun(runs, 7, tt.executionID, tt.perCommit); r != nil {
+				got = r.ID
+			}
+			if got != tt.want {
+				t.Errorf("reusableCheckRun() = run %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPostCheckRunPerCommit(t *testing.T) {
+	gh := newFakeGitHub(t)
+	t.Setenv("GITHUB_API_BASE_URL", gh.URL)
+	t.Setenv("GITHUB_APP_ID", "7")
+	t.Setenv("CHECK_RUN_MODE", "per-commit")
+	const sha = "6dcb09b5b57875f334f61aebed695e2e4193db5e"
+	gh.mux.HandleFunc("GET /repos/octo-org/hello-world/commits/"+sha+"/check-runs",
+		func(w http.ResponseWriter, r *http.Request) {
+			if q := r.URL.Query(); q.Get("app_id") != "7" || q.Get("check_name") != defaultContext {
+				t.Errorf("check runs listed with query %v", q)
+			}
+			json.NewEncoder(w).Encode(ghCheckRuns{CheckRuns: []ghCheckRunRef{
+				checkRun(40, 99, "exec-3"), checkRun(10, 7, "exec-1"), checkRun(20, 7, "exec-2"),
+			}})
+		})
+	gh.mux.HandleFunc("PATCH /repos/octo-org/hello-world/check-runs/{id}",
+		func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(ghCheckRunRef{ID: 20, URL: gh.URL + r.URL.Path})
+		})
+
+	ctx := withHTTPClient(context.Background(), gh.Client())
+	u, err := postCheckRun(ctx, "octo-org/hello-world", sha, testToken, "exec-4",
+		ghReqPayload{State: "success", Context: defaultContext})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := gh.URL + "/repos/octo-org/hello-world/check-runs/20"; u != want {
+		t.Errorf("postCheckRun() = %q, want %q", u, want)
+	}
+	reqs := gh.received()
+	if last := reqs[len(reqs)-1]; last.Body["conclusion"] != "success" {
+		t.Errorf("check run updated with %v", last.Body)
+	}
+}
+
+func TestCheckRunModeValidated(t *testing.T) {
+	t.Setenv("CHECK_RUN_MODE", "per-branch")
+	if err := validateConfig(context.Background()); err == nil {
+		t.Error("validateConfig accepted CHECK_RUN_MODE per-branch")
+	}
+}
+
+// TestCheckName makes sure CHECK_NAME names the check run of the overall
+// execution when it's looked up, created and updated.
+func TestCheckName(t *testing.T) {
+	const sha = "6dcb09b5b57875f334f61aebed695e2e4193db5e"
+	const name = "CI / hello-world"
+	tests := []struct {
+		name     string
+		existing []ghCheckRunRef
+		method   string
+	}{
+		{"new", nil, http.MethodPost},
+		{"existing", []ghCheckRunRef{checkRun(10, 7, "exec-1")}, http.MethodPatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			t.Setenv("GITHUB_API_BASE_URL", gh.URL)
+			t.Setenv("GITHUB_APP_ID", "7")
+			t.Setenv("CHECK_NAME", name)
+			gh.mux.HandleFunc("GET /repos/octo-org/hello-world/commits/"+sha+"/check-runs",
+				func(w http.ResponseWriter, r *http.Request) {
+					if got := r.URL.Query().Get("check_name"); got != name {
+						t.Errorf("check runs listed by name %q, want %q", got, name)
+					}
+					json.NewEncoder(w).Encode(ghCheckRuns{CheckRuns: tt.existing})
+				})
+			created := func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPost {
+					w.WriteHeader(http.StatusCreated)
+				}
+				json.NewEncoder(w).Encode(ghCheckRunRef{ID: 10, URL: gh.URL + r.URL.Path})
+			}
+			gh.mux.HandleFunc("POST /repos/octo-org/hello-world/check-runs", created)
+			gh.mux.HandleFunc("PATCH /repos/octo-org/hello-world/check-runs/{id}", created)
+
+			ctx := withHTTPClient(context.Background(), gh.Client())
+			_, err := postCheckRun(ctx, "octo-org/hello-world", sha, testToken, "exec-1",
+				ghReqPayload{State: "success", Context: defaultContext})
+			if err != nil {
+				t.Fatal(err)
+			}
+			reqs := gh.received()
+			last := reqs[len(reqs)-1]
+			if last.Method != tt.method || last.Body["name"] != name {
+				t.Errorf("%s check run named %v, want %s named %q", last.Method, last.Body["name"],
+					tt.method, name)
+			}
+		})
+	}
+
+	t.Setenv("CHECK_NAME", name)
+	if got := checkName(ghReqPayload{Context: "codepipeline/Build", Stage: "Build"}); got != "codepipeline/Build" {
+		t.Errorf("check run of stage Build named %q, want its context", got)
+	}
+}
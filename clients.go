@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/codebuild"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline"
+	"github.com/aws/aws-sdk-go-v2/service/codestarconnections"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-xray-sdk-go/instrumentation/awsv2"
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+// Clients are created once per container and shared by all invocations, so
+// that warm invocations don't load the configuration or set up connections
+// again.
+var (
+	awsConfig          aws.Config
+	codepipelineClient *codepipeline.Client
+	codebuildClient    *codebuild.Client
+	secretsClient      *secretsmanager.Client
+	eventbridgeClient  *eventbridge.Client
+	dynamodbClient     dynamodbAPI
+	connectionsClient  *codestarconnections.Client
+	snsClient          *sns.Client
+	ssmClient          *ssm.Client
+	stsClient          *sts.Client
+	// httpTransport honors HTTPS_PROXY and NO_PROXY. Its connections are
+	// kept alive across warm invocations, which mostly talk to the same few
+	// hosts.
+	httpTransport = &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          20,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       5 * time.Minute,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	// httpClient records a subsegment per request in the invocation's X-Ray
+	// trace.
+	httpClient = xray.Client(&http.Client{Transport: httpTransport})
+
+	// githubTimeout limits each attempt of a request to GitHub or another
+	// provider.
+	githubTimeout time.Duration
+)
+
+// dynamodbAPI is the part of the DynamoDB API the optional tables use.
+type dynamodbAPI interface {
+	GetItem(context.Context, *dynamodb.GetItemInput,
+		...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(context.Context, *dynamodb.PutItemInput,
+		...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	UpdateItem(context.Context, *dynamodb.UpdateItemInput,
+		...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	DeleteItem(context.Context, *dynamodb.DeleteItemInput,
+		...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	dynamodb.QueryAPIClient
+}
+
+type httpClientKey struct{}
+
+// withHTTPClient returns a context carrying the client to send requests to
+// GitHub and the other providers with.
+func withHTTPClient(ctx context.Context, c *http.Client) context.Context {
+	return context.WithValue(ctx, httpClientKey{}, c)
+}
+
+// httpClientFrom returns the client carried by ctx, or the shared one.
+func httpClientFrom(ctx context.Context) *http.Client {
+	if c, ok := ctx.Value(httpClientKey{}).(*http.Client); ok && c != nil {
+		return c
+	}
+	return httpClient
+}
+
+const defaultTimeout = 10 * time.Second
+
+// durationEnv parses the environment variable as a duration such as "5s",
+// returning def if it's unset.
+func durationEnv(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return d, nil
+}
+
+// codepipelineRetryer retries CodePipeline calls more patiently than the
+// SDK's default, as bursts of pipeline events easily exceed its rate limits.
+// Adaptive mode slows down all calls of the container once they're throttled.
+// CODEPIPELINE_MAX_ATTEMPTS (default 6) and CODEPIPELINE_MAX_BACKOFF (default
+// 10s) tune it.
+func codepipelineRetryer() (aws.Retryer, error) {
+	attempts := 6
+	if v := os.Getenv("CODEPIPELINE_MAX_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid CODEPIPELINE_MAX_ATTEMPTS %q", v)
+		}
+		attempts = n
+	}
+	backoff, err := durationEnv("CODEPIPELINE_MAX_BACKOFF", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return retry.NewAdaptiveMode(func(o *retry.AdaptiveModeOptions) {
+		o.StandardOptions = append(o.StandardOptions, func(o *retry.StandardOptions) {
+			o.MaxAttempts = attempts
+			o.MaxBackoff = backoff
+		})
+	}), nil
+}
+
+func init() {
+	var err error
+	githubTimeout, err = durationEnv("GITHUB_TIMEOUT", defaultTimeout)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+	awsTimeout, err := durationEnv("AWS_TIMEOUT", defaultTimeout)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+
+	// The timeout applies to each attempt of an AWS API call.
+	cfg, err := config.LoadDefaultConfig(context.Background(),
+		config.WithHTTPClient(awshttp.NewBuildableClient().WithTimeout(awsTimeout)))
+	if err != nil {
+		log.Fatalf("failed to load AWS configuration: %v\n", err)
+	}
+	// Record a subsegment per AWS call in the invocation's X-Ray trace.
+	awsv2.AWSV2Instrumentor(&cfg.APIOptions)
+	if err := setupTelemetry(context.Background(), &cfg); err != nil {
+		log.Fatalf("failed to set up OpenTelemetry: %v\n", err)
+	}
+	awsConfig = cfg
+	cpRetryer, err := codepipelineRetryer()
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+	codepipelineClient = codepipeline.NewFromConfig(cfg, func(o *codepipeline.Options) {
+		o.Retryer = cpRetryer
+	})
+	codebuildClient = codebuild.NewFromConfig(cfg)
+	secretsClient = secretsmanager.NewFromConfig(cfg)
+	eventbridgeClient = eventbridge.NewFromConfig(cfg)
+	dynamodbClient = dynamodb.NewFromConfig(cfg)
+	connectionsClient = codestarconnections.NewFromConfig(cfg)
+	snsClient = sns.NewFromConfig(cfg)
+	ssmClient = ssm.NewFromConfig(cfg)
+	stsClient = sts.NewFromConfig(cfg)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// codecommitMirrors parses CODECOMMIT_MIRRORS, which maps CodeCommit
+// repositories to the GitHub repositories they're mirrored to, e.g.
+// "app=acme/app,lib=acme/lib".
+func codecommitMirrors() (map[string]string, error) {
+	return parseMirrors("CODECOMMIT_MIRRORS")
+}
+
+// parseMirrors parses a mapping of repositories to the GitHub repositories
+// they're mirrored to from the environment variable.
+func parseMirrors(name string) (map[string]string, error) {
+	mirrors := map[string]string{}
+	for _, entry := range strings.Split(os.Getenv(name), ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 || !strings.Contains(kv[1], "/") {
+			return nil, fmt.Errorf("invalid %s entry %q, "+
+				"expected <repository>=<owner>/<repo>", name, entry)
+		}
+		mirrors[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return mirrors, nil
+}
+
+// codecommitMirror returns the GitHub repository the CodeCommit repository
+// is mirrored to.
+func codecommitMirror(name string) (string, error) {
+	mirrors, err := codecommitMirrors()
+	if err != nil {
+		return "", err
+	}
+	if m, ok := mirrors[name]; ok {
+		return m, nil
+	}
+	return "", fmt.Errorf("CodeCommit repository %s has no GitHub mirror in CODECOMMIT_MIRRORS", name)
+}
+
+// repoFromCodeCommitURL handles the CodeCommit source action, whose revision
+// URL points at the commit in the console, e.g.
+// https://eu-west-1.console.aws.amazon.com/codesuite/codecommit/repositories/app/commit/<sha>.
+// Statuses are posted to the repository's GitHub mirror, as CodeCommit has
+// none.
+func repoFromCodeCommitURL(url *url.URL) (string, error) {
+	p := strings.Split(strings.Trim(url.Path, "/"), "/")
+	if len(p) < 4 || p[2] != "repositories" || p[3] == "" {
+		return "", fmt.Errorf("unexpected URL path: %v", url.Path)
+	}
+	return codecommitMirror(p[3])
+}
+
+// codecommitURL returns the console URL of the commit of the CodeCommit
+// repository in the region, in the shape of the action's revision URLs.
+func codecommitURL(region, repo, rev string) *url.URL {
+	return &url.URL{
+		Scheme:   "https",
+		Host:     region + "." + consoleDomain(region),
+		Path:     fmt.Sprintf("/codesuite/codecommit/repositories/%s/commit/%s", repo, rev),
+		RawQuery: "region=" + region,
+	}
+}
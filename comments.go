@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+)
+
+// commentMarker identifies the comment of a pipeline among those of a pull
+// request.
+func commentMarker(pipeline string) string {
+	return fmt.Sprintf("<!-- codepipeline-github-status: %s -->", pipeline)
+}
+
+type ghIssueComment struct {
+	ID   int64  `json:"id"`
+	Body string `json:"body,omitempty"`
+}
+
+func (c *ghIssueComment) check() error {
+	if c.ID == 0 {
+		return errors.New("comment without id")
+	}
+	return nil
+}
+
+// failureComment renders the body of the comment on a failed execution: the
+// first failed stage, the commit with the first line of its message,
+// mentioning its author, if known, and the failed actions of each stage with
+// links to their execution and their CloudWatch logs, if known.
+func failureComment(pipeline, deepLink string, commit *ghCommit,
+	actions []types.ActionExecutionDetail) string {
+	var b strings.Builder
+	b.WriteString(commentMarker(pipeline) + "\n")
+	stage := ""
+	for _, s := range stageResults(actions) {
+		if s.Failed != nil {
+			stage = fmt.Sprintf(" in stage **%s**", s.Name)
+			break
+		}
+	}
+	fmt.Fprintf(&b, ":x: Pipeline **%s** [failed](%s)%s.\n\n", pipeline, deepLink, stage)
+	if commit != nil {
+		subject, _, _ := strings.Cut(commit.Commit.Message, "\n")
+		// GitHub links and abbreviates the SHA.
+		fmt.Fprintf(&b, "Commit %s", commit.SHA)
+		if subject != "" {
+			fmt.Fprintf(&b, " \"%s\"", strings.TrimSpace(subject))
+		}
+		if commit.Author != nil && commit.Author.Login != "" {
+			fmt.Fprintf(&b, " by @%s", commit.Author.Login)
+		} else if n := commit.Commit.Author.Name; n != "" {
+			fmt.Fprintf(&b, " by %s", n)
+		}
+		b.WriteString("\n\n")
+	}
+	b.WriteString("| Stage | Action | Details |\n| --- | --- | --- |\n")
+	for _, s := range stageResults(actions) {
+		if s.Failed == nil {
+			continue
+		}
+		a := s.Failed
+		var details []string
+		if o := a.Output; o != nil && o.ExecutionResult != nil {
+			r := o.ExecutionResult
+			if summary := aws.ToString(r.ExternalExecutionSummary); summary != "" {
+				details = append(details, strings.Replace(summary, "|", "\\|", -1))
+			}
+			if u := aws.ToString(r.ExternalExecutionUrl); u != "" {
+				details = append(details, fmt.Sprintf("[execution](%s)", u))
+			}
+			if l := cloudwatchLogsURL(a); l != "" {
+				details = append(details, fmt.Sprintf("[logs](%s)", l))
+			}
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s |\n", s.Name, aws.ToString(a.ActionName),
+			strings.Join(details, " "))
+	}
+	return b.String()
+}
+
+// successComment replaces the comment once a later execution succeeded.
+func successComment(pipeline, deepLink string) string {
+	return fmt.Sprintf("%s\n:white_check_mark: Pipeline **%s** [succeeded](%s).\n",
+		commentMarker(pipeline), pipeline, deepLink)
+}
+
+// commentOnPullRequests posts body as a comment to each open pull request
+// containing the commit, or updates the pipeline's earlier comment. If
+// onlyUpdate is set, pull requests without an earlier comment are left
+// alone.
+func commentOnPullRequests(ctx context.Context, repo, rev, token, pipeline, body string,
+	onlyUpdate bool) error {
+	var prs []ghPullRequest
+	err := getJSON(ctx, fmt.Sprintf("%s/repos/%s/commits/%s/pulls", githubAPIBaseURL(ctx), repo, rev),
+		token, &prs)
+	if err != nil {
+		return fmt.Errorf("failed to look up pull requests: %w", err)
+	}
+	for _, pr := range prs {
+		if pr.State != "open" {
+			continue
+		}
+		existing, err := findComment(ctx, repo, pr.Number, token, commentMarker(pipeline))
+		if err != nil {
+			return fmt.Errorf("failed to list comments of #%d: %w", pr.Number, err)
+		}
+		var out ghIssueComment
+		switch {
+		case existing != 0:
+			err = sendJSON(ctx, "PATCH",
+				fmt.Sprintf("%s/repos/%s/issues/comments/%d", githubAPIBaseURL(ctx), repo, existing),
+				token, ghIssueComment{Body: body}, 200, &out)
+		case !onlyUpdate:
+			err = sendJSON(ctx, "POST",
+				fmt.Sprintf("%s/repos/%s/issues/%d/comments", githubAPIBaseURL(ctx), repo, pr.Number),
+				token, ghIssueComment{Body: body}, 201, &out)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to comment on #%d: %w", pr.Number, err)
+		}
+	}
+	return nil
+}
+
+// Pull requests with more comments than this are unlikely to be worth
+// searching through entirely.
+const maxCommentPages = 10
+
+// findComment returns the ID of the pull request's comment containing the
+// marker, or 0 if there is none.
+func findComment(ctx context.Context, repo string, number int, token, marker string) (int64, error) {
+	for page := 1; page <= maxCommentPages; page++ {
+		var comments []ghIssueComment
+		err := getJSON(ctx, fmt.Sprintf("%s/repos/%s/issues/%d/comments?per_page=100&page=%d",
+			githubAPIBaseURL(ctx), repo, number, page), token, &comments)
+		if err != nil {
+			return 0, err
+		}
+		for _, c := range comments {
+			if strings.Contains(c.Body, marker) {
+				return c.ID, nil
+			}
+		}
+		if len(comments) < 100 {
+			break
+		}
+	}
+	return 0, nil
+}
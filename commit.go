@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// errNotACommit is returned for revisions that aren't Git commits, e.g. the
+// image digests of ECR sources or the version IDs of S3 sources.
+var errNotACommit = errors.New("revision is not a Git commit")
+
+var (
+	fullSHA  = regexp.MustCompile(`^[0-9a-f]{40}$`)
+	shortSHA = regexp.MustCompile(`^[0-9a-f]{4,39}$`)
+)
+
+// normalizeCommit returns the full, lower-case SHA of the revision.
+// Abbreviated SHAs are resolved with GitHub's commits API; other providers
+// get them as they are.
+func normalizeCommit(ctx context.Context, prov provider, repo, rev, token string) (string, error) {
+	sha := strings.ToLower(strings.TrimSpace(rev))
+	switch {
+	case fullSHA.MatchString(sha):
+		return sha, nil
+	case !shortSHA.MatchString(sha):
+		return "", fmt.Errorf("%w: %q", errNotACommit, rev)
+	case prov.name() != providerGitHub:
+		return sha, nil
+	}
+	var c ghCommit
+	err := getJSON(ctx, fmt.Sprintf("%s/repos/%s/commits/%s", githubAPIBaseURL(ctx), repo, sha),
+		token, &c)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve abbreviated commit %s: %w", rev, err)
+	}
+	if !fullSHA.MatchString(c.SHA) {
+		return "", fmt.Errorf("GitHub resolved %s to unexpected SHA %q", rev, c.SHA)
+	}
+	logger(ctx).Info("resolved abbreviated commit", "commit", rev, "sha", c.SHA)
+	return c.SHA, nil
+}
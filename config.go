@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+const defaultConfigTTL = 5 * time.Minute
+
+// The settings loaded from Parameter Store by this container. Variables set
+// in the function's environment are never overridden, so only those that
+// came from Parameter Store are tracked.
+var paramConfig = struct {
+	sync.Mutex
+	loaded  time.Time
+	fromSSM map[string]bool
+}{fromSSM: map[string]bool{}}
+
+// loadConfig loads the settings stored below the Parameter Store path
+// CONFIG_PARAMETER_PATH, if set, into the environment. Each parameter is
+// named after the environment variable it sets, e.g.
+// /codepipeline-github-status/STATE_MAPPING. The settings are loaded on the
+// first invocation of a container and again once they're older than
+// CONFIG_TTL.
+func loadConfig(ctx context.Context) error {
+	prefix := os.Getenv("CONFIG_PARAMETER_PATH")
+	if prefix == "" {
+		return nil
+	}
+	ttl, err := durationEnv("CONFIG_TTL", defaultConfigTTL)
+	if err != nil {
+		return err
+	}
+
+	paramConfig.Lock()
+	defer paramConfig.Unlock()
+	if !paramConfig.loaded.IsZero() && time.Since(paramConfig.loaded) < ttl {
+		return nil
+	}
+	params, err := parametersByPath(ctx, prefix)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration from %s: %w", prefix, err)
+	}
+	for name := range paramConfig.fromSSM {
+		if _, ok := params[name]; !ok {
+			os.Unsetenv(name)
+			delete(paramConfig.fromSSM, name)
+		}
+	}
+	for name, v := range params {
+		if _, ok := os.LookupEnv(name); ok && !paramConfig.fromSSM[name] {
+			continue
+		}
+		os.Setenv(name, v)
+		paramConfig.fromSSM[name] = true
+	}
+	paramConfig.loaded = time.Now()
+	logger(ctx).Info("loaded configuration", "path", prefix, "parameters", len(params))
+	return nil
+}
+
+// parametersByPath returns the values of the parameters below prefix, keyed
+// by the last element of their names. SecureString parameters are
+// decrypted.
+func parametersByPath(ctx context.Context, prefix string) (map[string]string, error) {
+	params := map[string]string{}
+	p := ssm.NewGetParametersByPathPaginator(ssmClient, &ssm.GetParametersByPathInput{
+		Path:           aws.String("/" + strings.Trim(prefix, "/")),
+		Recursive:      aws.Bool(true),
+		WithDecryption: aws.Bool(true),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, param := range page.Parameters {
+			params[path.Base(aws.ToString(param.Name))] = aws.ToString(param.Value)
+		}
+	}
+	return params, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// failedPost is a status that couldn't be set.
+type failedPost struct {
+	Repository string       `json:"repository"`
+	Commit     string       `json:"commit"`
+	Payload    ghReqPayload `json:"payload"`
+	Error      string       `json:"error"`
+}
+
+// recordFailure writes a record of the failed invocation to the DynamoDB
+// table named by FAILURE_TABLE, if set, so that it can be replayed with
+// -replay. The record holds the event without its token, the error, when the
+// invocation started and failed, and the statuses it attempted to set.
+// Failing to write it is logged only.
+func recordFailure(ctx context.Context, ev event, started time.Time, posts []failedPost, failure error) {
+	table := os.Getenv("FAILURE_TABLE")
+	if table == "" {
+		return
+	}
+	// Events passed unchanged can't be decoded without their detail, but
+	// pipeline and execution ID are all a replay needs.
+	ev.GithubToken = ""
+	ev.DetailType = ""
+	evJSON, err := json.Marshal(ev)
+	if err != nil {
+		logger(ctx).Warn("failed to encode failure record", "error", err)
+		return
+	}
+	if posts == nil {
+		posts = []failedPost{}
+	}
+	postsJSON, err := json.Marshal(posts)
+	if err != nil {
+		logger(ctx).Warn("failed to encode failure record", "error", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	id := fmt.Sprintf("%s/%s/%s", ev.Pipeline, ev.ExecutionID, now.Format(time.RFC3339Nano))
+	_, err = dynamodbClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item: map[string]types.AttributeValue{
+			"id":           &types.AttributeValueMemberS{Value: id},
+			"pipeline":     &types.AttributeValueMemberS{Value: ev.Pipeline},
+			"execution-id": &types.AttributeValueMemberS{Value: ev.ExecutionID},
+			"event":        &types.AttributeValueMemberS{Value: string(evJSON)},
+			"error":        &types.AttributeValueMemberS{Value: failure.Error()},
+			"started-at":   &types.AttributeValueMemberS{Value: started.UTC().Format(time.RFC3339Nano)},
+			"failed-at":    &types.AttributeValueMemberS{Value: now.Format(time.RFC3339Nano)},
+			"statuses":     &types.AttributeValueMemberS{Value: string(postsJSON)},
+		},
+	})
+	if err != nil {
+		logger(ctx).Warn("failed to write failure record", "table", table, "error", err)
+		return
+	}
+	logger(ctx).Info("wrote failure record", "table", table, "record-id", id)
+}
+
+// failedEvent reads the event of a failure record written by recordFailure.
+func failedEvent(ctx context.Context, id string) (event, error) {
+	table := os.Getenv("FAILURE_TABLE")
+	if table == "" {
+		return event{}, errors.New("FAILURE_TABLE must be set")
+	}
+	res, err := dynamodbClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(table),
+		Key:       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
+	})
+	if err != nil {
+		return event{}, fmt.Errorf("failed to read failure record %s: %w", id, err)
+	}
+	attr, ok := res.Item["event"].(*types.AttributeValueMemberS)
+	if !ok {
+		return event{}, fmt.Errorf("no failure record %s in %s", id, table)
+	}
+	var ev event
+	if err := json.Unmarshal([]byte(attr.Value), &ev); err != nil {
+		return event{}, fmt.Errorf("invalid failure record %s: %w", id, err)
+	}
+	return ev, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Pending statuses posted by this container, keyed by dedupKey, with the time
+// until which repeating them is suppressed.
+var recentPending = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: map[string]time.Time{}}
+
+// dedupKey identifies a status regardless of the execution posting it.
+func dedupKey(repo, rev string, payload ghReqPayload) string {
+	return strings.Join([]string{repo, rev, payload.Context, payload.State}, "/")
+}
+
+// dedupWindow returns how long a pending status isn't posted again, from
+// DEDUP_WINDOW. Zero disables de-duplication.
+func dedupWindow() (time.Duration, error) {
+	return durationEnv("DEDUP_WINDOW", 0)
+}
+
+// claimPending records that the pending status identified by key is about to
+// be posted. It returns false if the same status was posted within
+// DEDUP_WINDOW, by this container or, if DEDUP_TABLE is set, by any. Only
+// pending statuses are de-duplicated, as stages keep refreshing them while
+// final states are posted once per execution anyway. The returned function
+// forgets the claim, so that a failed post doesn't suppress its retry.
+func claimPending(ctx context.Context, key, state string) (bool, func(), error) {
+	window, err := dedupWindow()
+	if err != nil || window == 0 || state != "pending" {
+		return true, func() {}, err
+	}
+	now := time.Now()
+	recentPending.Lock()
+	if until, ok := recentPending.m[key]; ok && now.Before(until) {
+		recentPending.Unlock()
+		return false, nil, nil
+	}
+	if len(recentPending.m) >= maxReported {
+		recentPending.m = map[string]time.Time{}
+	}
+	recentPending.m[key] = now.Add(window)
+	recentPending.Unlock()
+	forget := func() {
+		recentPending.Lock()
+		defer recentPending.Unlock()
+		delete(recentPending.m, key)
+	}
+
+	table := os.Getenv("DEDUP_TABLE")
+	if table == "" {
+		return true, forget, nil
+	}
+	until := now.Add(window).Unix()
+	_, err = dynamodbClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item: map[string]types.AttributeValue{
+			"id":    &types.AttributeValueMemberS{Value: key},
+			"until": &types.AttributeValueMemberN{Value: strconv.FormatInt(until, 10)},
+			// Expired items are deleted by DynamoDB eventually.
+			"ttl": &types.AttributeValueMemberN{Value: strconv.FormatInt(until, 10)},
+		},
+		ConditionExpression:      aws.String("attribute_not_exists(id) OR #until <= :now"),
+		ExpressionAttributeNames: map[string]string{"#until": "until"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+		},
+	})
+	var cerr *types.ConditionalCheckFailedException
+	if errors.As(err, &cerr) {
+		return false, nil, nil
+	}
+	if err != nil {
+		forget()
+		return false, nil, err
+	}
+	return true, func() {
+		forget()
+		_, err := dynamodbClient.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(table),
+			Key:       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: key}},
+		})
+		if err != nil {
+			logger(ctx).Warn("failed to delete de-duplication record", "error", err)
+		}
+	}, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+)
+
+// deployEnvironments parses the event's github-status:environment tag or, if
+// the pipeline has none, DEPLOY_ENVIRONMENTS, which maps deploy stages to
+// GitHub environments, e.g. "Deploy-Staging=staging,Deploy-Prod=production".
+// It returns nil if neither is set.
+func deployEnvironments(ev event) (map[string]string, error) {
+	v := ev.deployEnvironments
+	if v == "" {
+		v = os.Getenv("DEPLOY_ENVIRONMENTS")
+	}
+	if v == "" {
+		return nil, nil
+	}
+	envs := map[string]string{}
+	for _, entry := range strings.Split(v, ",") {
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("invalid DEPLOY_ENVIRONMENTS entry %q, expected <stage>=<environment>",
+				entry)
+		}
+		stage := strings.TrimSpace(kv[0])
+		if _, ok := envs[stage]; ok {
+			return nil, fmt.Errorf("DEPLOY_ENVIRONMENTS maps stage %s twice", stage)
+		}
+		envs[stage] = strings.TrimSpace(kv[1])
+	}
+	return envs, nil
+}
+
+// environmentData is available to environment URL templates.
+type environmentData struct {
+	Environment string
+	Stage       string
+	Pipeline    string
+	ExecutionID string
+	Repository  string
+	Commit      string
+}
+
+// environmentURL renders the template from the event's environment-url or,
+// if it has none, from ENVIRONMENT_URL, e.g.
+// "https://{{.Environment}}.example.com". It returns an empty string if
+// neither is set.
+func environmentURL(ev event, data environmentData) (string, error) {
+	tmpl := ev.EnvironmentURL
+	if tmpl == "" {
+		tmpl = os.Getenv("ENVIRONMENT_URL")
+	}
+	if tmpl == "" {
+		return "", nil
+	}
+	t, err := template.New("environment-url").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("invalid environment URL template: %w", err)
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render environment URL template: %w", err)
+	}
+	return b.String(), nil
+}
+
+type ghDeployment struct {
+	ID               int64    `json:"id,omitempty"`
+	Ref              string   `json:"ref,omitempty"`
+	Environment      string   `json:"environment"`
+	Description      string   `json:"description,omitempty"`
+	AutoMerge        bool     `json:"auto_merge"`
+	RequiredContexts []string `json:"required_contexts"`
+	// Payload identifies the execution that created the deployment.
+	Payload struct {
+		Pipeline    string `json:"pipeline"`
+		ExecutionID string `json:"execution-id"`
+	} `json:"payload"`
+}
+
+func (d *ghDeployment) check() error {
+	if d.ID == 0 {
+		return errors.New("deployment without id")
+	}
+	return nil
+}
+
+type ghDeploymentStatus struct {
+	State          string `json:"state"`
+	LogURL         string `json:"log_url,omitempty"`
+	EnvironmentURL string `json:"environment_url,omitempty"`
+	Description    string `json:"description,omitempty"`
+}
+
+func (s *ghDeploymentStatus) check() error {
+	if s.State == "" {
+		return errors.New("deployment status without state")
+	}
+	return nil
+}
+
+// deploymentState maps the status of a deploy stage to the state of a GitHub
+// deployment status. It returns an empty string for stages not to report.
+func deploymentState(status types.ActionExecutionStatus) string {
+	switch status {
+	case types.ActionExecutionStatusInProgress:
+		return "in_progress"
+	case types.ActionExecutionStatusSucceeded:
+		return "success"
+	case types.ActionExecutionStatusFailed:
+		return "failure"
+	}
+	return ""
+}
+
+// reportDeployments creates a GitHub deployment for each deploy stage the
+// execution reached and sets its status. Deployments are reused across
+// invocations for the same execution, and statuses aren't repeated.
+func reportDeployments(ctx context.Context, ev event, envs map[string]string, repo, rev,
+	deepLink string, stages []*stageResult) error {
+	for _, s := range stages {
+		env, ok := envs[s.Name]
+		if !ok {
+			continue
+		}
+		state := deploymentState(s.Status)
+		if state == "" {
+			continue
+		}
+		id, err := findOrCreateDeployment(ctx, ev, repo, rev, env)
+		if err != nil {
+			return fmt.Errorf("failed to create deployment to %s: %w", env, err)
+		}
+
+		st := ghDeploymentStatus{State: state, LogURL: deepLink}
+		if s.Failed != nil {
+			st.Description = truncateDescription(
+				fmt.Sprintf("%s failed", aws.ToString(s.Failed.ActionName)))
+		}
+		st.EnvironmentURL, err = environmentURL(ev, environmentData{
+			Environment: env,
+			Stage:       s.Name,
+			Pipeline:    ev.Pipeline,
+			ExecutionID: ev.ExecutionID,
+			Repository:  repo,
+			Commit:      rev,
+		})
+		if err != nil {
+			return err
+		}
+
+		statusesURL := fmt.Sprintf("%s/repos/%s/deployments/%d/statuses",
+			githubAPIBaseURL(ctx), repo, id)
+		var latest []ghDeploymentStatus
+		if err := getJSON(ctx, statusesURL+"?per_page=1", ev.GithubToken, &latest); err != nil {
+			return fmt.Errorf("failed to get status of deployment to %s: %w", env, err)
+		}
+		if len(latest) > 0 && latest[0].State == state {
+			continue
+		}
+		var created ghDeploymentStatus
+		if err := sendJSON(ctx, "POST", statusesURL, ev.GithubToken, st, 201, &created); err != nil {
+			return fmt.Errorf("failed to set status of deployment to %s: %w", env, err)
+		}
+		logger(ctx).Info("set deployment status", "repository", repo, "commit", rev,
+			"environment", env, "state", state)
+	}
+	return nil
+}
+
+// findOrCreateDeployment returns the ID of the execution's deployment of the
+// commit to env, creating it if necessary.
+func findOrCreateDeployment(ctx context.Context, ev event, repo, rev, env string) (int64, error) {
+	deploymentsURL := fmt.Sprintf("%s/repos/%s/deployments", githubAPIBaseURL(ctx), repo)
+	var existing []ghDeployment
+	err := getJSON(ctx, fmt.Sprintf("%s?sha=%s&environment=%s", deploymentsURL,
+		url.QueryEscape(rev), url.QueryEscape(env)),
+		ev.GithubToken, &existing)
+	if err != nil {
+		return 0, err
+	}
+	for _, d := range existing {
+		if d.Payload.Pipeline == ev.Pipeline && d.Payload.ExecutionID == ev.ExecutionID {
+			return d.ID, nil
+		}
+	}
+
+	d := ghDeployment{
+		Ref:         rev,
+		Environment: env,
+		Description: fmt.Sprintf("Pipeline %s", ev.Pipeline),
+		// The commit statuses are posted by this very pipeline.
+		RequiredContexts: []string{},
+	}
+	d.Payload.Pipeline = ev.Pipeline
+	d.Payload.ExecutionID = ev.ExecutionID
+	var created ghDeployment
+	if err := sendJSON(ctx, "POST", deploymentsURL, ev.GithubToken, d, 201, &created); err != nil {
+		return 0, err
+	}
+	return created.ID, nil
+}
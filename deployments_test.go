@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+)
+
+// deployAction returns the execution of a stage's deploy action, started
+// the given number of minutes into the execution.
+func deployAction(stage string, status types.ActionExecutionStatus, minute int) types.ActionExecutionDetail {
+	a := actionExecution(stage, "Deploy", types.ActionCategoryDeploy, status)
+	a.StartTime = aws.Time(time.Date(2024, 5, 1, 12, minute, 0, 0, time.UTC))
+	return a
+}
+
+// serveDeployments makes the fake GitHub create deployments of
+// octo-org/hello-world, numbered from 1, and their statuses.
+func serveDeployments(gh *fakeGitHub) {
+	var mu sync.Mutex
+	var n int
+	gh.mux.HandleFunc("GET /repos/octo-org/hello-world/deployments",
+		func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`[]`)) })
+	gh.mux.HandleFunc("POST /repos/octo-org/hello-world/deployments",
+		func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			n++
+			id := n
+			mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":` + strconv.Itoa(id) + `,"environment":"x"}`))
+		})
+	gh.mux.HandleFunc("GET /repos/octo-org/hello-world/deployments/{id}/statuses",
+		func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`[]`)) })
+	gh.mux.HandleFunc("POST /repos/octo-org/hello-world/deployments/{id}/statuses",
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(ghDeploymentStatus{State: "success"})
+		})
+}
+
+// deploymentRequests returns the bodies of the deployments and deployment
+// statuses the fake GitHub received, in order.
+func deploymentRequests(gh *fakeGitHub) (deployments, statuses []map[string]any) {
+	for _, r := range gh.received() {
+		switch {
+		case r.Method != http.MethodPost:
+		case r.Path == "/repos/octo-org/hello-world/deployments":
+			deployments = append(deployments, r.Body)
+		case strings.HasPrefix(r.Path, "/repos/octo-org/hello-world/deployments/"):
+			statuses = append(statuses, r.Body)
+		}
+	}
+	return deployments, statuses
+}
+
+// TestDeploymentEnvironmentURL makes sure the deployment of a stage and its
+// status link to the environment.
+func TestDeploymentEnvironmentURL(t *testing.T) {
+	const sha = "6dcb09b5b57875f334f61aebed695e2e4193db5e"
+	tests := []struct {
+		name  string
+		id    string
+		env   string
+		event string
+		want  string
+	}{
+		{"ENVIRONMENT_URL", "de000000-0000-4000-8000-000000000001",
+			"https://{{.Environment}}.example.com/{{.Commit}}", "",
+			"https://production.example.com/" + sha},
+		{"environment-url", "de000000-0000-4000-8000-000000000002",
+			"https://{{.Environment}}.example.com", "https://example.com/{{.Stage}}",
+			"https://example.com/Deploy"},
+		{"none", "de000000-0000-4000-8000-000000000003", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DEPLOY_ENVIRONMENTS", "Deploy=production")
+			t.Setenv("ENVIRONMENT_URL", tt.env)
+			cp := newFakeCodePipeline(withExecutionID(loadExecution(t, "github-v1"), tt.id))
+			cp.actions = []types.ActionExecutionDetail{
+				deployAction("Deploy", types.ActionExecutionStatusSucceeded, 1)}
+			h, gh := newTestHandler(t, cp)
+			serveDeployments(gh)
+
+			err := h.Handle(context.Background(), event{Pipeline: "hello-world", ExecutionID: tt.id,
+				EnvironmentURL: tt.event})
+			if err != nil {
+				t.Fatal(err)
+			}
+			deployments, statuses := deploymentRequests(gh)
+			wantDeployment := map[string]any{
+				"ref":               sha,
+				"environment":       "production",
+				"description":       "Pipeline hello-world",
+				"auto_merge":        false,
+				"required_contexts": []any{},
+				"payload":           map[string]any{"pipeline": "hello-world", "execution-id": tt.id},
+			}
+			if len(deployments) != 1 || !reflect.DeepEqual(deployments[0], wantDeployment) {
+				t.Errorf("deployments = %v, want %v", deployments, wantDeployment)
+			}
+			wantStatus := map[string]any{"state": "success", "log_url": executionURL(tt.id)}
+			if tt.want != "" {
+				wantStatus["environment_url"] = tt.want
+			}
+			if len(statuses) != 1 || !reflect.DeepEqual(statuses[0], wantStatus) {
+				t.Errorf("deployment statuses = %v, want %v", statuses, wantStatus)
+			}
+		})
+	}
+}
+
+// TestDeployEnvironments maps the stages of an execution to the
+// environments of the deployments posted for them.
+func TestDeployEnvironments(t *testing.T) {
+	build := actionExecution("Build", "Build", types.ActionCategoryBuild,
+		types.ActionExecutionStatusSucceeded)
+	build.StartTime = aws.Time(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	tests := []struct {
+		name    string
+		mapping string
+		actions []types.ActionExecutionDetail
+		// want holds the environment and state of each deployment.
+		want [][2]string
+	}{
+		{"one stage", "Deploy-Staging=staging", []types.ActionExecutionDetail{
+			deployAction("Deploy-Staging", types.ActionExecutionStatusSucceeded, 1), build,
+		}, [][2]string{{"staging", "success"}}},
+		{"stages in order", "Deploy-Staging=staging, Deploy-Prod=production",
+			[]types.ActionExecutionDetail{
+				deployAction("Deploy-Prod", types.ActionExecutionStatusInProgress, 2),
+				deployAction("Deploy-Staging", types.ActionExecutionStatusSucceeded, 1), build,
+			}, [][2]string{{"staging", "success"}, {"production", "in_progress"}}},
+		{"failed stage", "Deploy-Staging=staging,Deploy-Prod=production",
+			[]types.ActionExecutionDetail{
+				deployAction("Deploy-Staging", types.ActionExecutionStatusFailed, 1), build,
+			}, [][2]string{{"staging", "failure"}}},
+		{"unmapped stages", "Deploy-Prod=production", []types.ActionExecutionDetail{
+			deployAction("Deploy-Staging", types.ActionExecutionStatusSucceeded, 1), build,
+		}, nil},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DEPLOY_ENVIRONMENTS", tt.mapping)
+			id := "de100000-0000-4000-8000-00000000000" + strconv.Itoa(i)
+			cp := newFakeCodePipeline(withExecutionID(loadExecution(t, "github-v1"), id))
+			cp.actions = tt.actions
+			h, gh := newTestHandler(t, cp)
+			serveDeployments(gh)
+
+			if err := h.Handle(context.Background(), event{Pipeline: "hello-world", ExecutionID: id}); err != nil {
+				t.Fatal(err)
+			}
+			deployments, statuses := deploymentRequests(gh)
+			if len(deployments) != len(statuses) {
+				t.Fatalf("%d deployments got %d statuses", len(deployments), len(statuses))
+			}
+			var got [][2]string
+			for i, d := range deployments {
+				got = append(got, [2]string{d["environment"].(string), statuses[i]["state"].(string)})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("deployments = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
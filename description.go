@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+)
+
+// descriptionData is available to description templates.
+type descriptionData struct {
+	Pipeline    string
+	ExecutionID string
+	// Status is the CodePipeline execution status, e.g. Succeeded, and State
+	// the resulting GitHub state, e.g. success.
+	Status     string
+	State      string
+	Repository string
+	Commit     string
+	// Description is the description generated without a template.
+	Description string
+	// Duration is how long the execution has run so far. It's only looked up
+	// if the template refers to it.
+	Duration time.Duration
+	// Tag is the Git tag that triggered the execution, if any.
+	Tag string
+	// Trigger is how the execution started, e.g. Webhook or
+	// StartPipelineExecution.
+	Trigger string
+}
+
+// descriptionTemplate returns the template from the event or, if it has
+// none, from DESCRIPTION_TEMPLATE. It returns an empty string if neither is
+// set.
+func descriptionTemplate(ev event) string {
+	if ev.DescriptionTemplate != "" {
+		return ev.DescriptionTemplate
+	}
+	return os.Getenv("DESCRIPTION_TEMPLATE")
+}
+
+func renderDescription(tmpl string, data descriptionData) (string, error) {
+	t, err := template.New("description").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("invalid description template: %w", err)
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render description template: %w", err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// templateUsesDuration reports whether looking up the duration is necessary.
+func templateUsesDuration(tmpl string) bool {
+	return strings.Contains(tmpl, ".Duration")
+}
+
+// executionDuration returns how long the execution ran, or has been running
+// if it hasn't finished. GetPipelineExecution doesn't return timestamps, so
+// the execution is looked up among the pipeline's recent executions.
+func executionDuration(ctx context.Context, cp codepipeline.ListPipelineExecutionsAPIClient,
+	pipeline, executionID string) (time.Duration, error) {
+	var found *types.PipelineExecutionSummary
+	pages := codepipeline.NewListPipelineExecutionsPaginator(cp,
+		&codepipeline.ListPipelineExecutionsInput{PipelineName: aws.String(pipeline)})
+	// The execution is recent, no need to go through the whole history.
+	for n := 0; found == nil && n < 5 && pages.HasMorePages(); n++ {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return 0, err
+		}
+		for i, e := range page.PipelineExecutionSummaries {
+			if aws.ToString(e.PipelineExecutionId) == executionID {
+				found = &page.PipelineExecutionSummaries[i]
+				break
+			}
+		}
+	}
+	if found == nil {
+		return 0, fmt.Errorf("execution %s not found among recent executions", executionID)
+	}
+	end := time.Now()
+	if found.Status != types.PipelineExecutionStatusInProgress {
+		end = aws.ToTime(found.LastUpdateTime)
+	}
+	return end.Sub(aws.ToTime(found.StartTime)).Round(time.Second), nil
+}
+
+// durationDescription says how long a finished execution ran, e.g.
+// "Succeeded in 7m32s" or "Failed after 3m5s".
+func durationDescription(status string, d time.Duration) string {
+	if status == string(types.PipelineExecutionStatusSucceeded) {
+		return fmt.Sprintf("Succeeded in %s", d)
+	}
+	return fmt.Sprintf("%s after %s", status, d)
+}
+
+// stuckDescription warns that the execution has been running suspiciously
+// long, e.g. "Still running after 45m ⚠".
+func stuckDescription(d time.Duration) string {
+	return fmt.Sprintf("Still running after %s ⚠",
+		strings.TrimSuffix(d.Round(time.Minute).String(), "0s"))
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+// errDryRun is returned instead of sending a request that would change
+// something during a dry run.
+var errDryRun = errors.New("dry run")
+
+type dryRunKey struct{}
+
+// withDryRun returns a context in which doRequest only logs requests other
+// than GETs instead of sending them.
+func withDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+// withoutDryRun returns a context in which doRequest sends requests even
+// during a dry run.
+func withoutDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, false)
+}
+
+func isDryRun(ctx context.Context) bool {
+	dry, _ := ctx.Value(dryRunKey{}).(bool)
+	return dry
+}
+
+// dryRun reports whether statuses are only logged instead of set, as asked
+// by the event or DRY_RUN.
+func (ev event) dryRun() bool {
+	return ev.DryRun || os.Getenv("DRY_RUN") == "true"
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+)
+
+type event struct {
+	// Version is the schema version of custom events, see decodeCustom.
+	Version schemaVersion `json:"version,omitempty"`
+
+	ExecutionID string `json:"execution-id"`
+	GithubToken string `json:"github-token"`
+	Pipeline    string `json:"pipeline"`
+
+	// GithubTokenSecretARN names a Secrets Manager secret holding the token,
+	// as an alternative to passing it in the event.
+	GithubTokenSecretARN string `json:"github-token-secret-arn"`
+
+	SkipDraftPRs   bool `json:"skip-draft-prs"`
+	IncludeAuthor  bool `json:"include-author"`
+	TrackApprovals bool `json:"track-approvals"`
+	NumberAttempts bool `json:"number-attempts"`
+	CheckReachable bool `json:"check-reachable"`
+	CheckToken     bool `json:"check-token"`
+	PerStage       bool `json:"per-stage"`
+
+	DetectDisabledTransitions bool `json:"detect-disabled-transitions"`
+
+	// ContextCollision enables checking for statuses other systems posted
+	// under the same context: "warn" only logs them, "skip" doesn't post.
+	ContextCollision string `json:"context-collision"`
+
+	// Context overrides the status context, DescriptionTemplate the
+	// description template. See descriptionData.
+	Context             string `json:"context"`
+	DescriptionTemplate string `json:"description-template"`
+
+	// TargetURL is the template of the statuses' target URL, see
+	// targetURLData.
+	TargetURL string `json:"target-url"`
+
+	// EnvironmentURL is the template of the environment URL of deployments,
+	// see environmentData.
+	EnvironmentURL string `json:"environment-url"`
+
+	// IncludeDuration says how long finished executions ran in the
+	// description.
+	IncludeDuration bool `json:"include-duration"`
+
+	// IncludeTrigger says how the execution started in the description,
+	// see triggerDescription.
+	IncludeTrigger bool `json:"include-trigger"`
+
+	// FailureDetails names the failed action and its error in the
+	// description of failures.
+	FailureDetails bool `json:"failure-details"`
+
+	// TestAnnotations annotates failed check runs with the failed test
+	// cases of CodeBuild test reports.
+	TestAnnotations bool `json:"test-annotations"`
+
+	// LinkLogs links failures to the logs of the failed action.
+	LinkLogs bool `json:"link-logs"`
+
+	// PRComment comments on the open pull requests containing the commit
+	// when an execution fails.
+	PRComment bool `json:"pr-comment"`
+
+	// DryRun logs the requests that would set statuses instead of sending
+	// them, see dryRun.
+	DryRun bool `json:"dry-run"`
+
+	// ApprovalStatus posts the state of the latest manual approval under a
+	// context of its own.
+	ApprovalStatus bool `json:"approval-status"`
+
+	// Commits overrides the commit taken from the source artifact.
+	Commits []string `json:"commits"`
+
+	// Targets are further repositories to post the execution's status to.
+	Targets []statusTarget `json:"targets"`
+
+	// RoleARN is a role to assume to call CodePipeline, e.g. in the
+	// pipeline's account, with ExternalID as external ID. See roleARN.
+	RoleARN    string `json:"role-arn"`
+	ExternalID string `json:"external-id"`
+	// Account is the account of the pipeline, set by unchanged CodePipeline
+	// events.
+	Account string `json:"account"`
+	// Region is the region of the pipeline, set by unchanged CodePipeline
+	// events. It defaults to the function's.
+	Region string `json:"region"`
+
+	// Repair only posts statuses that are missing or in a different state,
+	// see backfill.
+	Repair bool `json:"-"`
+	// StuckFor is how long the execution has been running, if reconcile
+	// found it stuck, see STUCK_THRESHOLD.
+	StuckFor time.Duration `json:"-"`
+
+	// DetailType is only set if the rule passes the CodePipeline event
+	// unchanged. State is then taken from execution events; stage events
+	// set Stage and StageState instead, and action events Stage, Action and
+	// ActionState.
+	DetailType  string `json:"detail-type"`
+	State       string `json:"-"`
+	Stage       string `json:"-"`
+	StageState  string `json:"-"`
+	Action      string `json:"-"`
+	ActionState string `json:"-"`
+
+	// deployEnvironments is set from the pipeline's tags, see applyTags.
+	deployEnvironments string
+	// schema is the detected schema: schemaV1, schemaV2 or schemaNative.
+	schema string
+}
+
+const (
+	executionStateChange = "CodePipeline Pipeline Execution State Change"
+	stageStateChange     = "CodePipeline Stage Execution State Change"
+	actionStateChange    = "CodePipeline Action Execution State Change"
+)
+
+// isStateChange reports whether the detail type is that of an unchanged
+// CodePipeline event this function handles.
+func isStateChange(detailType string) bool {
+	switch detailType {
+	case executionStateChange, stageStateChange, actionStateChange:
+		return true
+	}
+	return false
+}
+
+type executionStateDetail struct {
+	Pipeline    string `json:"pipeline"`
+	ExecutionID string `json:"execution-id"`
+	Stage       string `json:"stage"`
+	Action      string `json:"action"`
+	State       string `json:"state"`
+	Type        struct {
+		Category string `json:"category"`
+	} `json:"type"`
+}
+
+// missing returns the fields every event of the detail type carries that d
+// lacks.
+func (d executionStateDetail) missing(detailType string) []string {
+	var missing []string
+	if d.Pipeline == "" {
+		missing = append(missing, "pipeline")
+	}
+	if d.ExecutionID == "" {
+		missing = append(missing, "execution-id")
+	}
+	if d.State == "" {
+		missing = append(missing, "state")
+	}
+	if detailType != executionStateChange && d.Stage == "" {
+		missing = append(missing, "stage")
+	}
+	if detailType == actionStateChange && d.Action == "" {
+		missing = append(missing, "action")
+	}
+	return missing
+}
+
+// Execution states in CodePipeline events are spelled differently from
+// those GetPipelineExecution returns.
+var eventStates = map[string]string{
+	"STARTED":    "InProgress",
+	"RESUMED":    "InProgress",
+	"STOPPING":   "Stopping",
+	"STOPPED":    "Stopped",
+	"SUCCEEDED":  "Succeeded",
+	"FAILED":     "Failed",
+	"CANCELED":   "Cancelled",
+	"SUPERSEDED": "Superseded",
+}
+
+// statusTarget is a repository that gets the execution's status in addition
+// to the source repository, e.g. a release repository. Context overrides the
+// status context.
+type statusTarget struct {
+	Repository string `json:"repository"`
+	Context    string `json:"context"`
+}
+
+// defaultPipeline returns name, or PIPELINE_NAME if name is empty.
+func defaultPipeline(name string) string {
+	if name == "" {
+		return os.Getenv("PIPELINE_NAME")
+	}
+	return name
+}
+
+// UnmarshalJSON accepts both the custom event produced by an input
+// transformer, see decodeCustom, and the unchanged "CodePipeline Pipeline
+// Execution State Change", "CodePipeline Stage Execution State Change" and
+// "CodePipeline Action Execution State Change" events.
+func (ev *event) UnmarshalJSON(b []byte) error {
+	var head struct {
+		DetailType string `json:"detail-type"`
+	}
+	if err := json.Unmarshal(b, &head); err != nil {
+		return eventParamError(err, "")
+	}
+	if head.DetailType == "" {
+		if err := ev.decodeCustom(b); err != nil {
+			return err
+		}
+		// Rules of a single pipeline needn't pass its name.
+		ev.Pipeline = defaultPipeline(ev.Pipeline)
+		return nil
+	}
+	type plain event
+	if err := json.Unmarshal(b, (*plain)(ev)); err != nil {
+		return err
+	}
+	// The version is EventBridge's.
+	ev.Version = ""
+	if !isStateChange(ev.DetailType) {
+		// validate rejects the event.
+		return nil
+	}
+	ev.schema = schemaNative
+	var cw events.CloudWatchEvent
+	if err := json.Unmarshal(b, &cw); err != nil {
+		return err
+	}
+	if ev.Region == "" {
+		// arn:aws:codepipeline:<region>:<account>:<pipeline>
+		for _, r := range cw.Resources {
+			if p := strings.SplitN(r, ":", 6); len(p) == 6 && p[2] == "codepipeline" {
+				ev.Region = p[3]
+			}
+		}
+	}
+	var d executionStateDetail
+	if len(cw.Detail) > 0 {
+		if err := json.Unmarshal(cw.Detail, &d); err != nil {
+			return fmt.Errorf("invalid %q detail: %w", cw.DetailType, err)
+		}
+	}
+	if missing := d.missing(ev.DetailType); len(missing) > 0 {
+		return fmt.Errorf("%q event lacks detail %s: the rule probably passes only part "+
+			"of the event through an input path or transformer, pass it unchanged instead",
+			cw.DetailType, strings.Join(missing, ", "))
+	}
+	ev.Pipeline = d.Pipeline
+	ev.ExecutionID = d.ExecutionID
+	if ev.DetailType == stageStateChange {
+		// The execution's state is looked up, as the stage's doesn't tell.
+		ev.Stage = d.Stage
+		ev.StageState = d.State
+		if _, ok := eventStates[d.State]; !ok || d.Stage == "" {
+			return fmt.Errorf("invalid stage %q state %q", d.Stage, d.State)
+		}
+		return nil
+	}
+	if ev.DetailType == actionStateChange {
+		// Only approvals are reported per action, other actions merely
+		// refresh the execution's status.
+		ev.Stage = d.Stage
+		ev.Action = d.Action
+		ev.ActionState = d.State
+		ev.ApprovalStatus = d.Type.Category == string(types.ActionCategoryApproval)
+		return nil
+	}
+	ev.State = eventStates[d.State]
+	if ev.State == "" {
+		return fmt.Errorf("unknown execution state %q", d.State)
+	}
+	return nil
+}
+
+func (ev event) validate() error {
+	if ev.DetailType != "" && !isStateChange(ev.DetailType) {
+		return fmt.Errorf("received an unsupported %q event: pass %q, %q or %q events "+
+			"unchanged or use an input transformer", ev.DetailType, executionStateChange,
+			stageStateChange, actionStateChange)
+	}
+	if ev.DetailType == "" && ev.ExecutionID == "" && ev.GithubToken == "" && ev.Pipeline == "" {
+		return errors.New("received an empty event: the rule's input transformer " +
+			"must pass execution-id, and github-token and pipeline unless " +
+			"GITHUB_TOKEN_SECRET_ARN or GITHUB_TOKEN and PIPELINE_NAME are set")
+	}
+	if ev.DetailType != "" && !ev.hasToken() {
+		return errors.New("GITHUB_TOKEN or GITHUB_TOKEN_SECRET_ARN must be set " +
+			"to handle unchanged CodePipeline events")
+	}
+	var missing []string
+	if ev.ExecutionID == "" {
+		missing = append(missing, "execution-id")
+	}
+	if !ev.hasToken() {
+		missing = append(missing, "github-token")
+	}
+	if ev.Pipeline == "" {
+		missing = append(missing, "pipeline")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing event param %s", strings.Join(missing, ", "))
+	}
+	switch ev.ContextCollision {
+	case "", "warn", "skip":
+	default:
+		return fmt.Errorf("invalid event param context-collision %q", ev.ContextCollision)
+	}
+	for _, t := range ev.Targets {
+		if strings.Count(t.Repository, "/") != 1 {
+			return fmt.Errorf("invalid event param targets: repository %q isn't owner/repo",
+				t.Repository)
+		}
+	}
+	return nil
+}
+
+// hasToken reports whether credentials for some provider are available.
+func (ev event) hasToken() bool {
+	if githubAppConfigured() || ev.GithubToken != "" || ev.GithubTokenSecretARN != "" ||
+		os.Getenv("GITHUB_TOKEN_SECRET_ARN") != "" || os.Getenv("GITHUB_TOKEN") != "" ||
+		os.Getenv("ROUTES") != "" || os.Getenv("ROUTES_SECRET_ARN") != "" {
+		return true
+	}
+	for _, p := range tokenEnvPrefixes {
+		if os.Getenv(p+"_TOKEN_SECRET_ARN") != "" || os.Getenv(p+"_TOKEN") != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveToken sets the GitHub token. A GitHub App's installation token takes
+// precedence over a token included in the event. Otherwise, a secret named in
+// the event takes precedence over the token of the route rt, if any, and the
+// environment variables GITHUB_TOKEN_SECRET_ARN and GITHUB_TOKEN, in that
+// order.
+func (ev *event) resolveToken(ctx context.Context, rt *route) error {
+	if githubAppConfigured() {
+		token, err := cachedGithubAppToken(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to authenticate as GitHub App: %w", err)
+		}
+		ev.GithubToken = token
+		return nil
+	}
+	if ev.GithubToken != "" {
+		addSecret(ev.GithubToken)
+		return nil
+	}
+	arn := ev.GithubTokenSecretARN
+	if arn == "" && rt != nil {
+		if rt.TokenSecretARN == "" && rt.Token != "" {
+			ev.GithubToken = rt.Token
+			addSecret(rt.Token)
+			return nil
+		}
+		arn = rt.TokenSecretARN
+	}
+	if arn == "" {
+		arn = os.Getenv("GITHUB_TOKEN_SECRET_ARN")
+	}
+	if arn == "" {
+		ev.GithubToken = os.Getenv("GITHUB_TOKEN")
+		addSecret(ev.GithubToken)
+		return nil
+	}
+	token, err := secretString(ctx, arn)
+	if err != nil {
+		return fmt.Errorf("failed to read GitHub token from secret %s: %w", arn, err)
+	}
+	if token == "" {
+		return fmt.Errorf("secret %s is empty", arn)
+	}
+	ev.GithubToken = token
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		json  string
+		token string
+		// err is a substring of the error, or empty if the event is valid.
+		err string
+	}{
+		{"empty", `{}`, "", "received an empty event"},
+		{"empty with token", `{}`, testToken, "received an empty event"},
+		{"execution ID only", `{"execution-id":"4f1c2a8e"}`, "",
+			"missing event param github-token, pipeline"},
+		{"no token", `{"execution-id":"4f1c2a8e","pipeline":"hello-world"}`, "",
+			"missing event param github-token"},
+		{"token in event", `{"execution-id":"4f1c2a8e","pipeline":"hello-world",` +
+			`"github-token":"` + testToken + `"}`, "", ""},
+		{"no execution ID", `{"pipeline":"hello-world"}`, testToken,
+			"missing event param execution-id"},
+		{"complete", `{"execution-id":"4f1c2a8e","pipeline":"hello-world"}`, testToken, ""},
+		{"unsupported detail type", `{"detail-type":"CodePipeline Stage Execution State Change ` +
+			`(beta)"}`, testToken, "received an unsupported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GITHUB_TOKEN", tt.token)
+			t.Setenv("PIPELINE_NAME", "")
+			var ev event
+			if err := json.Unmarshal([]byte(tt.json), &ev); err != nil {
+				t.Fatal(err)
+			}
+			err := ev.validate()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("validate() = %v, want valid", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("validate() = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// TestUnmarshalIncompleteDetail makes sure a native event whose detail was
+// dropped or cut down by the rule names what's missing.
+func TestUnmarshalIncompleteDetail(t *testing.T) {
+	tests := []struct {
+		name, json, err string
+	}{
+		{"empty execution detail",
+			`{"detail-type":"CodePipeline Pipeline Execution State Change","detail":{}}`,
+			"lacks detail pipeline, execution-id, state"},
+		{"no detail",
+			`{"detail-type":"CodePipeline Pipeline Execution State Change"}`,
+			"lacks detail pipeline, execution-id, state"},
+		{"stage without name",
+			`{"detail-type":"CodePipeline Stage Execution State Change","detail":` +
+				`{"pipeline":"hello-world","execution-id":"4f1c2a8e","state":"FAILED"}}`,
+			"lacks detail stage"},
+		{"action without names",
+			`{"detail-type":"CodePipeline Action Execution State Change","detail":` +
+				`{"pipeline":"hello-world","execution-id":"4f1c2a8e","state":"FAILED"}}`,
+			"lacks detail stage, action"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ev event
+			err := json.Unmarshal([]byte(tt.json), &ev)
+			if err == nil || !strings.Contains(err.Error(), tt.err) ||
+				!strings.Contains(err.Error(), "input path or transformer") {
+				t.Errorf("Unmarshal() = %v, want %q naming the rule's input", err, tt.err)
+			}
+		})
+	}
+}
+
+// TestDefaultPipeline makes sure events and -local fall back to
+// PIPELINE_NAME only when no pipeline is given.
+func TestDefaultPipeline(t *testing.T) {
+	t.Setenv("PIPELINE_NAME", "hello-world")
+	if got := defaultPipeline(""); got != "hello-world" {
+		t.Errorf(`defaultPipeline("") = %q, want "hello-world"`, got)
+	}
+	if got := defaultPipeline("hello-world-ci"); got != "hello-world-ci" {
+		t.Errorf(`defaultPipeline("hello-world-ci") = %q, want "hello-world-ci"`, got)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+)
+
+// eventbridgeAPI is the part of the EventBridge API the handler uses.
+type eventbridgeAPI interface {
+	PutEvents(context.Context, *eventbridge.PutEventsInput,
+		...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
+}
+
+type statusPostedDetail struct {
+	Pipeline    string `json:"pipeline"`
+	ExecutionID string `json:"execution-id"`
+	Repository  string `json:"repository"`
+	Commit      string `json:"commit"`
+	State       string `json:"state"`
+	Context     string `json:"context"`
+	TargetURL   string `json:"target-url"`
+	Posted      bool   `json:"posted"`
+	StatusURL   string `json:"status-url,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// maxEventEntries is the number of entries PutEvents accepts at once.
+const maxEventEntries = 10
+
+// statusEvents collects the GitHubStatusPosted events of an invocation, so
+// that publishing them doesn't delay setting the statuses, see publish.
+type statusEvents struct {
+	sync.Mutex
+	entries []types.PutEventsRequestEntry
+}
+
+type statusEventsKey struct{}
+
+func withStatusEvents(ctx context.Context, e *statusEvents) context.Context {
+	return context.WithValue(ctx, statusEventsKey{}, e)
+}
+
+// queueStatusEvent queues a GitHubStatusPosted event for the event bus named
+// by EVENT_BUS_NAME, if set. statusURL and postErr are the outcome of the
+// GitHub call. Outside of an invocation the event is dropped.
+func queueStatusEvent(ctx context.Context, ev event, repo, rev string,
+	payload ghReqPayload, statusURL string, postErr error) {
+	bus := os.Getenv("EVENT_BUS_NAME")
+	e, ok := ctx.Value(statusEventsKey{}).(*statusEvents)
+	if bus == "" || !ok {
+		return
+	}
+
+	detail := statusPostedDetail{
+		Pipeline:    ev.Pipeline,
+		ExecutionID: ev.ExecutionID,
+		Repository:  repo,
+		Commit:      rev,
+		State:       payload.State,
+		Context:     payload.Context,
+		TargetURL:   payload.TargetURL,
+		Posted:      postErr == nil,
+		StatusURL:   statusURL,
+	}
+	if postErr != nil {
+		// Error responses may echo the token.
+		detail.Error = redact(postErr.Error())
+	}
+	d, err := json.Marshal(detail)
+	if err != nil {
+		logger(ctx).Warn("failed to encode GitHubStatusPosted event", "error", err)
+		return
+	}
+	e.Lock()
+	defer e.Unlock()
+	e.entries = append(e.entries, types.PutEventsRequestEntry{
+		EventBusName: aws.String(bus),
+		Source:       aws.String("codepipeline-github-status"),
+		DetailType:   aws.String("GitHubStatusPosted"),
+		Detail:       aws.String(string(d)),
+	})
+}
+
+// publish sends the queued events in batches once the invocation set its
+// statuses. ctx carries the invocation's deadline, which bounds the calls.
+// The events are informational only, so failures are logged instead of
+// returned.
+func (e *statusEvents) publish(ctx context.Context, client eventbridgeAPI) {
+	e.Lock()
+	entries := e.entries
+	e.entries = nil
+	e.Unlock()
+	for len(entries) > 0 {
+		n := min(len(entries), maxEventEntries)
+		batch := entries[:n]
+		entries = entries[n:]
+		res, err := client.PutEvents(ctx, &eventbridge.PutEventsInput{Entries: batch})
+		if err != nil {
+			logger(ctx).Warn("failed to publish GitHubStatusPosted events",
+				"event-bus", aws.ToString(batch[0].EventBusName), "count", n, "error", err)
+			continue
+		}
+		if res.FailedEntryCount == 0 {
+			continue
+		}
+		for _, r := range res.Entries {
+			if r.ErrorCode != nil {
+				logger(ctx).Warn("failed to publish GitHubStatusPosted event",
+					"event-bus", aws.ToString(batch[0].EventBusName),
+					"error-code", aws.ToString(r.ErrorCode), "error", aws.ToString(r.ErrorMessage))
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+)
+
+// fakeEventBridge records the events it's sent.
+type fakeEventBridge struct {
+	mu     sync.Mutex
+	inputs []*eventbridge.PutEventsInput
+}
+
+func (f *fakeEventBridge) PutEvents(_ context.Context, in *eventbridge.PutEventsInput,
+	_ ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inputs = append(f.inputs, in)
+	return &eventbridge.PutEventsOutput{}, nil
+}
+
+func TestStatusEvents(t *testing.T) {
+	t.Setenv("EVENT_BUS_NAME", "status-bus")
+	ex := withExecutionID(loadExecution(t, "multi-artifact"), "eb000000-0000-4000-8000-000000000001")
+	h, gh := newTestHandler(t, newFakeCodePipeline(ex))
+	eb := &fakeEventBridge{}
+	h.EventBridge = eb
+	// The config repository rejects the token, echoing it.
+	gh.mux.HandleFunc("POST /repos/octo-org/hello-world-config/statuses/{sha}",
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message":"Resource not accessible by token ` + testToken + `"}`))
+		})
+
+	err := h.Handle(context.Background(), event{Pipeline: "hello-world",
+		ExecutionID: aws.ToString(ex.PipelineExecutionId)})
+	if err == nil {
+		t.Fatal("Handle succeeded although a status couldn't be set")
+	}
+
+	// Both events go out in a single call after the statuses were set.
+	if len(eb.inputs) != 1 {
+		t.Fatalf("PutEvents was called %d times, want 1", len(eb.inputs))
+	}
+	details := map[string]statusPostedDetail{}
+	for _, e := range eb.inputs[0].Entries {
+		if got := aws.ToString(e.EventBusName); got != "status-bus" {
+			t.Errorf("event bus = %q, want status-bus", got)
+		}
+		if got := aws.ToString(e.Source); got != "codepipeline-github-status" {
+			t.Errorf("source = %q", got)
+		}
+		if got := aws.ToString(e.DetailType); got != "GitHubStatusPosted" {
+			t.Errorf("detail type = %q", got)
+		}
+		var d statusPostedDetail
+		if err := json.Unmarshal([]byte(aws.ToString(e.Detail)), &d); err != nil {
+			t.Fatal(err)
+		}
+		details[d.Repository] = d
+	}
+
+	posted := details["octo-org/hello-world"]
+	want := statusPostedDetail{
+		Pipeline:    "hello-world",
+		ExecutionID: "eb000000-0000-4000-8000-000000000001",
+		Repository:  "octo-org/hello-world",
+		Commit:      "a9b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0",
+		State:       "failure",
+		Context:     defaultContext,
+		TargetURL:   executionURL("eb000000-0000-4000-8000-000000000001"),
+		Posted:      true,
+		StatusURL:   gh.URL + "/repos/octo-org/hello-world/statuses/a9b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0",
+	}
+	if !reflect.DeepEqual(posted, want) {
+		t.Errorf("detail = %+v, want %+v", posted, want)
+	}
+
+	failed := details["octo-org/hello-world-config"]
+	if failed.Posted || failed.StatusURL != "" || failed.Error == "" {
+		t.Errorf("detail of failed post = %+v", failed)
+	}
+	if strings.Contains(failed.Error, testToken) {
+		t.Errorf("error %q isn't redacted", failed.Error)
+	}
+}
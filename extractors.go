@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// repoExtractor recognizes the revision URLs of a kind of source action and
+// extracts the repository from them.
+type repoExtractor struct {
+	name    string
+	matches func(u *url.URL) bool
+	extract func(u *url.URL) (string, error)
+}
+
+// repoExtractors are the extractors registered by registerExtractor, tried
+// in order. The custom extractor configured by REPO_URL_PATTERN, if any,
+// comes first.
+var repoExtractors []repoExtractor
+
+func registerExtractor(e repoExtractor) {
+	repoExtractors = append(repoExtractors, e)
+}
+
+func init() {
+	registerExtractor(repoExtractor{
+		name: "commit-url",
+		matches: func(u *url.URL) bool {
+			host := u.Hostname()
+			return host == "github.com" || host == "www.github.com" ||
+				host == os.Getenv("GITHUB_HOSTNAME") || host == "bitbucket.org" ||
+				host == os.Getenv("GITEA_HOSTNAME")
+		},
+		extract: repoFromCommitURL,
+	})
+	registerExtractor(repoExtractor{
+		name: "gitlab",
+		matches: func(u *url.URL) bool {
+			return u.Hostname() == "gitlab.com" || u.Hostname() == os.Getenv("GITLAB_HOSTNAME")
+		},
+		extract: repoFromGitLabURL,
+	})
+	registerExtractor(repoExtractor{
+		name: "codecommit",
+		matches: func(u *url.URL) bool {
+			return isConsoleHost(u.Hostname()) && strings.HasPrefix(u.Path, "/codesuite/codecommit/")
+		},
+		extract: repoFromCodeCommitURL,
+	})
+	registerExtractor(repoExtractor{
+		name:    "connection",
+		matches: func(u *url.URL) bool { return isConsoleHost(u.Hostname()) },
+		extract: repoFromConnectionURL,
+	})
+	registerExtractor(repoExtractor{
+		name:    "codecatalyst",
+		matches: func(u *url.URL) bool { return u.Hostname() == "codecatalyst.aws" },
+		extract: repoFromCodeCatalystURL,
+	})
+}
+
+func extractRepoName(u *url.URL) (string, error) {
+	custom, err := customExtractor()
+	if err != nil {
+		return "", err
+	}
+	extractors := repoExtractors
+	if custom != nil {
+		extractors = append([]repoExtractor{*custom}, extractors...)
+	}
+	for _, e := range extractors {
+		if e.matches(u) {
+			return e.extract(u)
+		}
+	}
+	return "", fmt.Errorf("unknown hostname %v", u.Hostname())
+}
+
+// customExtractor returns the extractor configured by REPO_URL_PATTERN, a
+// regular expression matched against the whole revision URL whose group
+// named repo is the repository, e.g.
+// ^https://git\.example\.com/scm/(?P<repo>[^/]+/[^/]+)/. It returns nil if
+// the pattern isn't set.
+func customExtractor() (*repoExtractor, error) {
+	p := os.Getenv("REPO_URL_PATTERN")
+	if p == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REPO_URL_PATTERN: %w", err)
+	}
+	group := re.SubexpIndex("repo")
+	if group < 0 {
+		return nil, fmt.Errorf("invalid REPO_URL_PATTERN: no group named repo")
+	}
+	return &repoExtractor{
+		name:    "custom",
+		matches: func(u *url.URL) bool { return re.MatchString(u.String()) },
+		extract: func(u *url.URL) (string, error) {
+			repo := strings.TrimSuffix(re.FindStringSubmatch(u.String())[group], ".git")
+			if strings.Count(repo, "/") < 1 {
+				return "", fmt.Errorf("REPO_URL_PATTERN matched %q, expected owner/repo", repo)
+			}
+			return repo, nil
+		},
+	}, nil
+}
+
+// scpLikeURL matches SSH remotes in the short scp-like syntax, e.g.
+// git@github.com:owner/repo.git.
+var scpLikeURL = regexp.MustCompile(`^([\w.-]+@)?([\w.-]+):([^/][^:]*)$`)
+
+// scpToSSHURL turns SSH remotes in scp-like syntax, which don't parse as
+// URLs, into ssh:// URLs, e.g. ssh://git@github.com/owner/repo.git, whose
+// repository the extractor of the host then finds.
+func scpToSSHURL(rawURL string) string {
+	if strings.Contains(rawURL, "://") {
+		return rawURL
+	}
+	m := scpLikeURL.FindStringSubmatch(rawURL)
+	if m == nil || m[1] == "" && !strings.Contains(m[2], ".") {
+		return rawURL
+	}
+	return "ssh://" + m[1] + m[2] + "/" + m[3]
+}
+
+// repoFromCodeCatalystURL handles CodeCatalyst source repositories, e.g.
+// https://codecatalyst.aws/spaces/acme/projects/web/source-repositories/app/commits/<sha>.
+// Like for CodeCommit, statuses are posted to the repository's GitHub
+// mirror, which CODECATALYST_MIRRORS names.
+func repoFromCodeCatalystURL(u *url.URL) (string, error) {
+	p := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := 0; i+1 < len(p); i++ {
+		if p[i] == "source-repositories" && p[i+1] != "" {
+			mirrors, err := parseMirrors("CODECATALYST_MIRRORS")
+			if err != nil {
+				return "", err
+			}
+			if m, ok := mirrors[p[i+1]]; ok {
+				return m, nil
+			}
+			return "", fmt.Errorf("CodeCatalyst repository %s has no GitHub mirror in "+
+				"CODECATALYST_MIRRORS", p[i+1])
+		}
+	}
+	return "", fmt.Errorf("unexpected URL path: %v", u.Path)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// repoTest is a revision URL and the repository extracted from it, or an
+// error if repo is empty.
+type repoTest struct {
+	name, url, repo string
+}
+
+func testRepoExtraction(t *testing.T, tests []repoTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := parseRevisionURL(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			repo, err := extractRepoName(u)
+			switch {
+			case tt.repo == "" && err == nil:
+				t.Errorf("extractRepoName(%s) = %q, want an error", tt.url, repo)
+			case tt.repo != "" && err != nil:
+				t.Errorf("extractRepoName(%s) failed: %v", tt.url, err)
+			case repo != tt.repo:
+				t.Errorf("extractRepoName(%s) = %q, want %q", tt.url, repo, tt.repo)
+			}
+		})
+	}
+}
+
+// TestGitHubSourceURLs covers the revision URLs of both versions of the
+// GitHub source action: version 1 links the commit on github.com, version 2
+// redirects through the CodeStar connection.
+func TestGitHubSourceURLs(t *testing.T) {
+	testRepoExtraction(t, []repoTest{
+		{"v1 commit",
+			"https://github.com/octo-org/hello-world/commit/6dcb09b5b57875f334f61aebed695e2e4193db5e",
+			"octo-org/hello-world"},
+		{"v1 branch", "https://github.com/octo-org/hello-world/tree/main", "octo-org/hello-world"},
+		{"v1 .git", "https://github.com/octo-org/hello-world.git", "octo-org/hello-world"},
+		{"v1 without repository", "https://github.com/octo-org", ""},
+		{"v2 connections",
+			"https://eu-west-1.console.aws.amazon.com/codesuite/settings/connections/redirect?" +
+				"connectionArn=arn:aws:codestar-connections:eu-west-1:123456789012:connection/abc&" +
+				"FullRepositoryId=octo-org/hello-world&Commit=6dcb09b5b57875f334f61aebed695e2e4193db5e",
+			"octo-org/hello-world"},
+		{"v2 codestar-connections",
+			"https://us-east-1.console.aws.amazon.com/codesuite/settings/codestar-connections/redirect?" +
+				"FullRepositoryId=octo-org/hello-world&Commit=6dcb09b",
+			"octo-org/hello-world"},
+		{"v2 account and region",
+			"https://console.aws.amazon.com/codesuite/settings/123456789012/eu-west-1/connections/redirect?" +
+				"FullRepositoryId=octo-org/hello-world",
+			"octo-org/hello-world"},
+		{"v2 GovCloud",
+			"https://us-gov-west-1.console.amazonaws-us-gov.com/codesuite/settings/connections/redirect?" +
+				"FullRepositoryId=octo-org/hello-world",
+			"octo-org/hello-world"},
+		{"v2 without repository",
+			"https://eu-west-1.console.aws.amazon.com/codesuite/settings/connections/redirect?Commit=6dcb09b",
+			""},
+		{"v2 other console page",
+			"https://eu-west-1.console.aws.amazon.com/codesuite/codepipeline/pipelines/hello-world/view",
+			""},
+	})
+}
+
+func TestCodeCommitExtractor(t *testing.T) {
+	t.Setenv("CODECOMMIT_MIRRORS", "app=octo-org/app, lib=octo-org/lib")
+	testRepoExtraction(t, []repoTest{
+		{"mirrored",
+			"https://eu-west-1.console.aws.amazon.com/codesuite/codecommit/repositories/app/commit/" +
+				"6dcb09b5b57875f334f61aebed695e2e4193db5e?region=eu-west-1",
+			"octo-org/app"},
+		{"China",
+			"https://cn-north-1.console.amazonaws.cn/codesuite/codecommit/repositories/lib/commit/6dcb09b",
+			"octo-org/lib"},
+		{"not mirrored",
+			"https://eu-west-1.console.aws.amazon.com/codesuite/codecommit/repositories/tools/commit/6dcb09b",
+			""},
+		{"no repository",
+			"https://eu-west-1.console.aws.amazon.com/codesuite/codecommit/home", ""},
+	})
+}
+
+func TestSSHExtractor(t *testing.T) {
+	t.Setenv("GITLAB_HOSTNAME", "gitlab.example.com")
+	testRepoExtraction(t, []repoTest{
+		{"scp-like GitHub", "git@github.com:octo-org/hello-world.git", "octo-org/hello-world"},
+		{"scp-like without user", "github.com:octo-org/hello-world", "octo-org/hello-world"},
+		{"scp-like GitLab subgroup", "git@gitlab.example.com:group/sub/project.git", "group/sub/project"},
+		{"ssh URL", "ssh://git@github.com/octo-org/hello-world.git", "octo-org/hello-world"},
+		{"scp-like unknown host", "git@git.example.com:octo-org/hello-world.git", ""},
+	})
+	// Windows paths and relative URLs aren't SSH remotes.
+	for _, raw := range []string{`C:\src\repo`, "octo-org/hello-world", "https://github.com/o/r"} {
+		if got := scpToSSHURL(raw); got != raw {
+			t.Errorf("scpToSSHURL(%q) = %q, want it unchanged", raw, got)
+		}
+	}
+}
+
+func TestCodeCatalystExtractor(t *testing.T) {
+	t.Setenv("CODECATALYST_MIRRORS", "web=octo-org/web")
+	testRepoExtraction(t, []repoTest{
+		{"mirrored",
+			"https://codecatalyst.aws/spaces/acme/projects/shop/source-repositories/web/commits/" +
+				"6dcb09b5b57875f334f61aebed695e2e4193db5e",
+			"octo-org/web"},
+		{"not mirrored",
+			"https://codecatalyst.aws/spaces/acme/projects/shop/source-repositories/api/commits/6dcb09b",
+			""},
+		{"no repository", "https://codecatalyst.aws/spaces/acme/projects/shop", ""},
+	})
+}
+
+func TestCustomExtractor(t *testing.T) {
+	t.Setenv("REPO_URL_PATTERN", `^https://git\.example\.com/scm/(?P<repo>[^/]+/[^/]+)/`)
+	testRepoExtraction(t, []repoTest{
+		{"matched", "https://git.example.com/scm/octo-org/hello-world.git/commits/6dcb09b",
+			"octo-org/hello-world"},
+		// The custom extractor comes first, others still apply to other URLs.
+		{"built-in", "https://github.com/octo-org/hello-world/commit/6dcb09b", "octo-org/hello-world"},
+		{"unknown", "https://git.example.com/other/octo-org/hello-world", ""},
+	})
+
+	for _, p := range []string{`(`, `^https://git\.example\.com/(.+)/`} {
+		t.Setenv("REPO_URL_PATTERN", p)
+		if _, err := customExtractor(); err == nil {
+			t.Errorf("customExtractor() accepted REPO_URL_PATTERN %q", p)
+		}
+	}
+	t.Setenv("REPO_URL_PATTERN", `^https://git\.example\.com/(?P<repo>[^/]+)/`)
+	testRepoExtraction(t, []repoTest{
+		{"without owner", "https://git.example.com/hello-world/commits/6dcb09b", ""},
+	})
+}
+
+// TestParseRevisionURL covers relative and malformed revision URLs.
+func TestParseRevisionURL(t *testing.T) {
+	const sha = "6dcb09b5b57875f334f61aebed695e2e4193db5e"
+	tests := []struct {
+		name, url, base string
+		// want is the parsed URL, err a substring of the error otherwise.
+		want, err string
+	}{
+		{"absolute", "https://github.com/octo-org/hello-world/commit/" + sha, "",
+			"https://github.com/octo-org/hello-world/commit/" + sha, ""},
+		{"absolute with base", "https://github.com/octo-org/hello-world/commit/" + sha,
+			"https://github.example.com", "https://github.com/octo-org/hello-world/commit/" + sha, ""},
+		{"scp-like", "git@github.com:octo-org/hello-world.git", "",
+			"ssh://git@github.com/octo-org/hello-world.git", ""},
+		{"absolute path", "/octo-org/hello-world/commit/" + sha, "https://github.example.com/mirror/",
+			"https://github.example.com/octo-org/hello-world/commit/" + sha, ""},
+		{"relative path", "octo-org/hello-world/commit/" + sha, "https://github.example.com/mirror/",
+			"https://github.example.com/mirror/octo-org/hello-world/commit/" + sha, ""},
+		{"relative without base", "/octo-org/hello-world/commit/" + sha, "", "",
+			"has no hostname: this source type is not supported unless REVISION_BASE_URL is set"},
+		{"empty", "", "", "", "has no hostname"},
+		{"malformed", "https://github.com/octo-org/%zz", "", "", "invalid URL escape"},
+		{"malformed host", "https://github.com:port/octo-org/hello-world", "", "", "invalid port"},
+		{"invalid base", "/octo-org/hello-world/commit/" + sha, "://github.example.com", "",
+			"invalid REVISION_BASE_URL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("REVISION_BASE_URL", tt.base)
+			u, err := parseRevisionURL(tt.url)
+			switch {
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("parseRevisionURL(%q) = %v, %v, want error %q", tt.url, u, err, tt.err)
+			case tt.err == "" && err != nil:
+				t.Errorf("parseRevisionURL(%q) failed: %v", tt.url, err)
+			case tt.err == "" && u.String() != tt.want:
+				t.Errorf("parseRevisionURL(%q) = %s, want %s", tt.url, u, tt.want)
+			}
+		})
+	}
+}
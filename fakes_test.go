@@ -0,0 +1,594 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// testToken is the GitHub token the tests configure and the fake GitHub
+// expects.
+const testToken = "ghp_test0123456789"
+
+func TestMain(m *testing.M) {
+	// Console links and the CodePipeline client depend on the function's
+	// region, which the tests mustn't take from the environment.
+	awsConfig.Region = "eu-west-1"
+	os.Exit(m.Run())
+}
+
+// loadExecution returns the recorded GetPipelineExecution response
+// testdata/executions/<name>.json.
+func loadExecution(t *testing.T, name string) *types.PipelineExecution {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", "executions", name+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res codepipeline.GetPipelineExecutionOutput
+	if err := json.Unmarshal(b, &res); err != nil {
+		t.Fatalf("invalid fixture %s: %v", name, err)
+	}
+	return res.PipelineExecution
+}
+
+// fakeCodePipeline serves the executions it holds. Calls the handler makes
+// only for some features return what the fields configure, or nothing.
+type fakeCodePipeline struct {
+	executions map[string]*types.PipelineExecution
+	actions    []types.ActionExecutionDetail
+	state      *codepipeline.GetPipelineStateOutput
+	pipeline   *types.PipelineDeclaration
+	// executionErr is returned by GetPipelineExecution, if set.
+	executionErr error
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func newFakeCodePipeline(executions ...*types.PipelineExecution) *fakeCodePipeline {
+	cp := &fakeCodePipeline{executions: map[string]*types.PipelineExecution{}}
+	for _, ex := range executions {
+		cp.executions[aws.ToString(ex.PipelineExecutionId)] = ex
+	}
+	return cp
+}
+
+func (cp *fakeCodePipeline) called(op string) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.calls = append(cp.calls, op)
+}
+
+func (cp *fakeCodePipeline) GetPipelineExecution(_ context.Context,
+	in *codepipeline.GetPipelineExecutionInput, _ ...func(*codepipeline.Options)) (
+	*codepipeline.GetPipelineExecutionOutput, error) {
+	cp.called("GetPipelineExecution")
+	if cp.executionErr != nil {
+		return nil, cp.executionErr
+	}
+	ex, ok := cp.executions[aws.ToString(in.PipelineExecutionId)]
+	if !ok {
+		return nil, &types.PipelineExecutionNotFoundException{
+			Message: aws.String("execution " + aws.ToString(in.PipelineExecutionId) + " not found"),
+		}
+	}
+	return &codepipeline.GetPipelineExecutionOutput{PipelineExecution: ex}, nil
+}
+
+func (cp *fakeCodePipeline) GetPipeline(_ context.Context, in *codepipeline.GetPipelineInput,
+	_ ...func(*codepipeline.Options)) (*codepipeline.GetPipelineOutput, error) {
+	cp.called("GetPipeline")
+	if cp.pipeline == nil {
+		return nil, &types.PipelineNotFoundException{Message: in.Name}
+	}
+	return &codepipeline.GetPipelineOutput{Pipeline: cp.pipeline}, nil
+}
+
+func (cp *fakeCodePipeline) GetPipelineState(_ context.Context, in *codepipeline.GetPipelineStateInput,
+	_ ...func(*codepipeline.Options)) (*codepipeline.GetPipelineStateOutput, error) {
+	cp.called("GetPipelineState")
+	if cp.state == nil {
+		return &codepipeline.GetPipelineStateOutput{PipelineName: in.Name}, nil
+	}
+	return cp.state, nil
+}
+
+func (cp *fakeCodePipeline) PutApprovalResult(context.Context, *codepipeline.PutApprovalResultInput,
+	...func(*codepipeline.Options)) (*codepipeline.PutApprovalResultOutput, error) {
+	cp.called("PutApprovalResult")
+	return &codepipeline.PutApprovalResultOutput{}, nil
+}
+
+func (cp *fakeCodePipeline) ListActionExecutions(context.Context, *codepipeline.ListActionExecutionsInput,
+	...func(*codepipeline.Options)) (*codepipeline.ListActionExecutionsOutput, error) {
+	cp.called("ListActionExecutions")
+	return &codepipeline.ListActionExecutionsOutput{ActionExecutionDetails: cp.actions}, nil
+}
+
+func (cp *fakeCodePipeline) ListPipelineExecutions(context.Context,
+	*codepipeline.ListPipelineExecutionsInput, ...func(*codepipeline.Options)) (
+	*codepipeline.ListPipelineExecutionsOutput, error) {
+	cp.called("ListPipelineExecutions")
+	return &codepipeline.ListPipelineExecutionsOutput{}, nil
+}
+
+func (cp *fakeCodePipeline) ListTagsForResource(context.Context,
+	*codepipeline.ListTagsForResourceInput, ...func(*codepipeline.Options)) (
+	*codepipeline.ListTagsForResourceOutput, error) {
+	cp.called("ListTagsForResource")
+	return &codepipeline.ListTagsForResourceOutput{}, nil
+}
+
+// ghRequest is a request the fake GitHub received.
+type ghRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   map[string]any
+}
+
+// fakeGitHub is a GitHub API that creates statuses. Tests register handlers
+// for other endpoints on its mux. Every request must carry the pinned API
+// version and an accepted token: the test token or one of the installation
+// tokens handed out, see useGitHubApp. Requests of the App itself must carry
+// a JWT.
+type fakeGitHub struct {
+	*httptest.Server
+	mux *http.ServeMux
+
+	mu       sync.Mutex
+	requests []ghRequest
+	tokens   map[string]bool
+}
+
+func newFakeGitHub(t *testing.T) *fakeGitHub {
+	t.Helper()
+	gh := &fakeGitHub{mux: http.NewServeMux(), tokens: map[string]bool{testToken: true}}
+	gh.mux.HandleFunc("POST /repos/{owner}/{repo}/statuses/{sha}", func(w http.ResponseWriter,
+		r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{
+			"id":  1,
+			"url": gh.URL + r.URL.Path,
+		})
+	})
+	gh.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := ghRequest{Method: r.Method, Path: r.URL.Path, Header: r.Header.Clone()}
+		if r.ContentLength != 0 && r.Method != http.MethodGet {
+			if err := json.NewDecoder(r.Body).Decode(&req.Body); err != nil {
+				t.Errorf("%s %s: invalid JSON body: %v", r.Method, r.URL.Path, err)
+			}
+			if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+				t.Errorf("%s %s: Content-Type = %q, want JSON", r.Method, r.URL.Path, ct)
+			}
+		}
+		auth := r.Header.Get("Authorization")
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/app/"):
+			if !strings.HasPrefix(auth, "Bearer ") {
+				t.Errorf("%s %s: Authorization = %q, want the App's JWT", r.Method, r.URL.Path, auth)
+			}
+		case !strings.HasPrefix(auth, "token ") || !gh.accepts(strings.TrimPrefix(auth, "token ")):
+			t.Errorf("%s %s: Authorization = %q, want an accepted token", r.Method, r.URL.Path, auth)
+		}
+		if got := r.Header.Get("X-GitHub-Api-Version"); got != defaultGithubAPIVersion {
+			t.Errorf("%s %s: X-GitHub-Api-Version = %q, want %q", r.Method, r.URL.Path, got,
+				defaultGithubAPIVersion)
+		}
+		gh.mu.Lock()
+		gh.requests = append(gh.requests, req)
+		gh.mu.Unlock()
+		gh.mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(gh.Close)
+	return gh
+}
+
+// accepts reports whether the token was handed out.
+func (gh *fakeGitHub) accepts(token string) bool {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	return gh.tokens[token]
+}
+
+// received returns the requests received so far.
+func (gh *fakeGitHub) received() []ghRequest {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	return append([]ghRequest(nil), gh.requests...)
+}
+
+// statuses returns the bodies of the statuses posted to the commit, in order.
+func (gh *fakeGitHub) statuses(repo, sha string) []map[string]any {
+	var bodies []map[string]any
+	for _, r := range gh.received() {
+		if r.Method == http.MethodPost && r.Path == "/repos/"+repo+"/statuses/"+sha {
+			bodies = append(bodies, r.Body)
+		}
+	}
+	return bodies
+}
+
+// testAppKey is the private key of the GitHub App, generated once.
+var testAppKey = sync.OnceValue(func() string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+})
+
+// useGitHubApp authenticates as GitHub App 7, whose installation 42 the fake
+// GitHub hands out the installation tokens ghs_1, ghs_2 and so on to. It
+// returns the tokens handed out so far.
+func useGitHubApp(t *testing.T, gh *fakeGitHub) func() []string {
+	t.Helper()
+	t.Setenv("GITHUB_APP_ID", "7")
+	t.Setenv("GITHUB_APP_INSTALLATION_ID", "42")
+	t.Setenv("GITHUB_APP_PRIVATE_KEY", testAppKey())
+	// The container's token belongs to other tests' fake GitHub.
+	resetAppToken := func() {
+		appToken.Lock()
+		appToken.token, appToken.expires = "", time.Time{}
+		appToken.Unlock()
+	}
+	resetAppToken()
+	t.Cleanup(resetAppToken)
+
+	var issued []string
+	gh.mux.HandleFunc("POST /app/installations/42/access_tokens",
+		func(w http.ResponseWriter, r *http.Request) {
+			gh.mu.Lock()
+			token := fmt.Sprintf("ghs_%d", len(issued)+1)
+			issued = append(issued, token)
+			gh.tokens[token] = true
+			gh.mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(ghInstallationToken{Token: token,
+				ExpiresAt: time.Now().Add(time.Hour)})
+		})
+	return func() []string {
+		gh.mu.Lock()
+		defer gh.mu.Unlock()
+		return append([]string(nil), issued...)
+	}
+}
+
+// newTestHandler returns a Handler talking to the fakes, with GitHub
+// configured through the environment as in a deployed function.
+func newTestHandler(t *testing.T, cp *fakeCodePipeline) (*Handler, *fakeGitHub) {
+	t.Helper()
+	gh := newFakeGitHub(t)
+	t.Setenv("GITHUB_API_BASE_URL", gh.URL)
+	t.Setenv("GITHUB_TOKEN", testToken)
+	// The fixtures' connection leads to GitHub, see connectionProviderType.
+	connectionTypes.Lock()
+	connectionTypes.m[testConnectionARN] = "GitHub"
+	connectionTypes.Unlock()
+	return &Handler{CodePipeline: cp, HTTP: gh.Client(), NewProvider: newProvider}, gh
+}
+
+// testConnectionARN is the connection of the fixtures' CodeStar sources.
+const testConnectionARN = "arn:aws:codestar-connections:eu-west-1:123456789012:connection/" +
+	"0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d"
+
+// fakeDynamoDB is a table keyed by id. It evaluates the condition and update
+// expressions the function writes with.
+type fakeDynamoDB struct {
+	mu    sync.Mutex
+	items map[string]map[string]ddbtypes.AttributeValue
+}
+
+// useFakeDynamoDB replaces the shared DynamoDB client for the test.
+func useFakeDynamoDB(t *testing.T) *fakeDynamoDB {
+	f := &fakeDynamoDB{items: map[string]map[string]ddbtypes.AttributeValue{}}
+	prev := dynamodbClient
+	dynamodbClient = f
+	t.Cleanup(func() { dynamodbClient = prev })
+	return f
+}
+
+// item returns the item with the ID, or nil.
+func (f *fakeDynamoDB) item(id string) map[string]ddbtypes.AttributeValue {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.items[id]
+}
+
+func itemID(key map[string]ddbtypes.AttributeValue) string {
+	if id, ok := key["id"].(*ddbtypes.AttributeValueMemberS); ok {
+		return id.Value
+	}
+	return ""
+}
+
+func (f *fakeDynamoDB) check(cond *string, names map[string]string,
+	values map[string]ddbtypes.AttributeValue, item map[string]ddbtypes.AttributeValue) error {
+	if cond == nil {
+		return nil
+	}
+	ok, err := evalCondition(aws.ToString(cond), names, values, item)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &ddbtypes.ConditionalCheckFailedException{Message: aws.String("condition failed")}
+	}
+	return nil
+}
+
+func (f *fakeDynamoDB) GetItem(_ context.Context, in *dynamodb.GetItemInput,
+	_ ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: f.item(itemID(in.Key))}, nil
+}
+
+func (f *fakeDynamoDB) PutItem(_ context.Context, in *dynamodb.PutItemInput,
+	_ ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := itemID(in.Item)
+	old := f.items[id]
+	if err := f.check(in.ConditionExpression, in.ExpressionAttributeNames,
+		in.ExpressionAttributeValues, old); err != nil {
+		return nil, err
+	}
+	f.items[id] = in.Item
+	out := &dynamodb.PutItemOutput{}
+	if in.ReturnValues == ddbtypes.ReturnValueAllOld {
+		out.Attributes = old
+	}
+	return out, nil
+}
+
+func (f *fakeDynamoDB) UpdateItem(_ context.Context, in *dynamodb.UpdateItemInput,
+	_ ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := itemID(in.Key)
+	old := f.items[id]
+	if err := f.check(in.ConditionExpression, in.ExpressionAttributeNames,
+		in.ExpressionAttributeValues, old); err != nil {
+		return nil, err
+	}
+	item := map[string]ddbtypes.AttributeValue{"id": in.Key["id"]}
+	for k, v := range old {
+		item[k] = v
+	}
+	if err := applyUpdate(aws.ToString(in.UpdateExpression), in.ExpressionAttributeNames,
+		in.ExpressionAttributeValues, item); err != nil {
+		return nil, err
+	}
+	f.items[id] = item
+	out := &dynamodb.UpdateItemOutput{}
+	if in.ReturnValues == ddbtypes.ReturnValueAllNew {
+		out.Attributes = item
+	}
+	return out, nil
+}
+
+func (f *fakeDynamoDB) DeleteItem(_ context.Context, in *dynamodb.DeleteItemInput,
+	_ ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := itemID(in.Key)
+	if err := f.check(in.ConditionExpression, in.ExpressionAttributeNames,
+		in.ExpressionAttributeValues, f.items[id]); err != nil {
+		return nil, err
+	}
+	delete(f.items, id)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func (f *fakeDynamoDB) Query(context.Context, *dynamodb.QueryInput,
+	...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	return nil, errors.New("fakeDynamoDB doesn't support queries")
+}
+
+// expressionTokens splits a condition or update expression into tokens.
+func expressionTokens(expr string) []string {
+	return strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ", ",", " , ").Replace(expr))
+}
+
+func attributeName(names map[string]string, token string) string {
+	if strings.HasPrefix(token, "#") {
+		return names[token]
+	}
+	return token
+}
+
+// conditionParser evaluates condition expressions made of comparisons,
+// attribute_exists, attribute_not_exists, AND, OR and parentheses.
+type conditionParser struct {
+	tokens []string
+	names  map[string]string
+	values map[string]ddbtypes.AttributeValue
+	item   map[string]ddbtypes.AttributeValue
+}
+
+func evalCondition(expr string, names map[string]string, values map[string]ddbtypes.AttributeValue,
+	item map[string]ddbtypes.AttributeValue) (bool, error) {
+	p := &conditionParser{tokens: expressionTokens(expr), names: names, values: values, item: item}
+	ok, err := p.or()
+	if err == nil && len(p.tokens) > 0 {
+		err = fmt.Errorf("unexpected %q in condition %q", p.tokens[0], expr)
+	}
+	return ok, err
+}
+
+func (p *conditionParser) peek() string {
+	if len(p.tokens) == 0 {
+		return ""
+	}
+	return p.tokens[0]
+}
+
+func (p *conditionParser) next() string {
+	t := p.peek()
+	if t != "" {
+		p.tokens = p.tokens[1:]
+	}
+	return t
+}
+
+func (p *conditionParser) expect(token string) error {
+	if t := p.next(); t != token {
+		return fmt.Errorf("expected %q in condition, got %q", token, t)
+	}
+	return nil
+}
+
+func (p *conditionParser) or() (bool, error) {
+	ok, err := p.and()
+	for err == nil && p.peek() == "OR" {
+		p.next()
+		var r bool
+		r, err = p.and()
+		ok = ok || r
+	}
+	return ok, err
+}
+
+func (p *conditionParser) and() (bool, error) {
+	ok, err := p.operand()
+	for err == nil && p.peek() == "AND" {
+		p.next()
+		var r bool
+		r, err = p.operand()
+		ok = ok && r
+	}
+	return ok, err
+}
+
+func (p *conditionParser) operand() (bool, error) {
+	switch t := p.next(); t {
+	case "(":
+		ok, err := p.or()
+		if err != nil {
+			return false, err
+		}
+		return ok, p.expect(")")
+	case "attribute_exists", "attribute_not_exists":
+		if err := p.expect("("); err != nil {
+			return false, err
+		}
+		_, exists := p.item[attributeName(p.names, p.next())]
+		return exists == (t == "attribute_exists"), p.expect(")")
+	default:
+		l := p.value(t)
+		op := p.next()
+		r := p.value(p.next())
+		return compareAttributes(l, op, r)
+	}
+}
+
+// value returns the value the token refers to, nil if the item lacks it.
+func (p *conditionParser) value(token string) ddbtypes.AttributeValue {
+	if strings.HasPrefix(token, ":") {
+		return p.values[token]
+	}
+	return p.item[attributeName(p.names, token)]
+}
+
+// compareAttributes compares strings and numbers. Like DynamoDB, it's false
+// if an attribute is missing or the types differ.
+func compareAttributes(l ddbtypes.AttributeValue, op string, r ddbtypes.AttributeValue) (bool, error) {
+	var c int
+	switch l := l.(type) {
+	case nil:
+		return false, nil
+	case *ddbtypes.AttributeValueMemberS:
+		r, ok := r.(*ddbtypes.AttributeValueMemberS)
+		if !ok {
+			return false, nil
+		}
+		c = strings.Compare(l.Value, r.Value)
+	case *ddbtypes.AttributeValueMemberN:
+		r, ok := r.(*ddbtypes.AttributeValueMemberN)
+		if !ok {
+			return false, nil
+		}
+		a, err := strconv.ParseFloat(l.Value, 64)
+		if err != nil {
+			return false, err
+		}
+		b, err := strconv.ParseFloat(r.Value, 64)
+		if err != nil {
+			return false, err
+		}
+		c = cmp.Compare(a, b)
+	default:
+		return false, fmt.Errorf("unsupported attribute %T in condition", l)
+	}
+	switch op {
+	case "=":
+		return c == 0, nil
+	case "<>":
+		return c != 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	}
+	return false, fmt.Errorf("unsupported operator %q in condition", op)
+}
+
+// applyUpdate applies SET, also with if_not_exists, and REMOVE actions to
+// the item.
+func applyUpdate(expr string, names map[string]string, values map[string]ddbtypes.AttributeValue,
+	item map[string]ddbtypes.AttributeValue) error {
+	tokens := expressionTokens(expr)
+	action := ""
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t == "SET" || t == "REMOVE":
+			action = t
+		case t == ",":
+		case action == "REMOVE":
+			delete(item, attributeName(names, t))
+		case action == "SET" && i+2 < len(tokens) && tokens[i+1] == "=":
+			name := attributeName(names, t)
+			// if_not_exists ( #name , :value )
+			if tokens[i+2] == "if_not_exists" && i+7 < len(tokens) {
+				if _, ok := item[name]; !ok {
+					item[name] = values[tokens[i+6]]
+				}
+				i += 7
+				continue
+			}
+			item[name] = values[tokens[i+2]]
+			i += 2
+		default:
+			return fmt.Errorf("unsupported update expression %q", expr)
+		}
+	}
+	return nil
+}
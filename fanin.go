@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Fan-in records expire after a week, long after the pipelines finished.
+const fanInTTL = 7 * 24 * time.Hour
+
+// fanInStatePrefix prefixes the attributes holding each pipeline's state.
+const fanInStatePrefix = "state:"
+
+// fanInPipelines returns the pipelines, from FAN_IN_PIPELINES, whose overall
+// statuses on a commit are combined into one, or nil if the pipeline isn't
+// one of them or FAN_IN_TABLE isn't set.
+func fanInPipelines(pipeline string) []string {
+	if os.Getenv("FAN_IN_TABLE") == "" {
+		return nil
+	}
+	pipelines := splitList(os.Getenv("FAN_IN_PIPELINES"))
+	if !slices.Contains(pipelines, pipeline) {
+		return nil
+	}
+	return pipelines
+}
+
+// fanIn records the pipeline's state on the commit in the table named by
+// FAN_IN_TABLE (partition key "id") and returns the combined status of the
+// pipelines: failure or error as soon as one of them failed, success once
+// all succeeded, and pending otherwise. A late pending state doesn't replace
+// a pipeline's final state.
+func fanIn(ctx context.Context, pipelines []string, pipeline, repo, rev string,
+	payload ghReqPayload) (ghReqPayload, error) {
+	now := time.Now()
+	update := "SET #state = :state, #ttl = :ttl"
+	if payload.State == "pending" {
+		update = "SET #state = if_not_exists(#state, :state), #ttl = :ttl"
+	}
+	res, err := dynamodbClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(os.Getenv("FAN_IN_TABLE")),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{
+				Value: "fan-in/" + historyKey(repo, rev) + "/" + payload.Context},
+		},
+		UpdateExpression:         aws.String(update),
+		ExpressionAttributeNames: map[string]string{"#state": fanInStatePrefix + pipeline, "#ttl": "ttl"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":state": &types.AttributeValueMemberS{Value: payload.State},
+			":ttl": &types.AttributeValueMemberN{
+				Value: strconv.FormatInt(now.Add(fanInTTL).Unix(), 10)},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	if err != nil {
+		return payload, err
+	}
+	states := map[string]string{}
+	for name, v := range res.Attributes {
+		if p, ok := strings.CutPrefix(name, fanInStatePrefix); ok {
+			if s, ok := v.(*types.AttributeValueMemberS); ok {
+				states[p] = s.Value
+			}
+		}
+	}
+	combined := payload
+	combined.State, combined.Description = combinedState(pipelines, states)
+	logger(ctx).Info("combined statuses of pipelines", "pipelines", pipelines,
+		"state", combined.State)
+	if payload.State == combined.State && (payload.State == "failure" || payload.State == "error") {
+		// The pipeline's own description says why it failed.
+		combined.Description = payload.Description
+	}
+	return combined, nil
+}
+
+// combinedState combines the pipelines' states and describes the result.
+func combinedState(pipelines []string, states map[string]string) (string, string) {
+	succeeded := 0
+	for _, p := range pipelines {
+		switch states[p] {
+		case "failure", "error":
+			return states[p], fmt.Sprintf("Pipeline %s failed", p)
+		case "success":
+			succeeded++
+		}
+	}
+	if succeeded == len(pipelines) {
+		return "success", fmt.Sprintf("All %d pipelines succeeded", len(pipelines))
+	}
+	return "pending", fmt.Sprintf("%d of %d pipelines succeeded", succeeded, len(pipelines))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+func init() {
+	registerProvider(providerRegistration{
+		name:    providerGitea,
+		hostEnv: "GITEA_HOSTNAME",
+		newProvider: func(ctx context.Context, ev event) (provider, error) {
+			token, err := providerToken(ctx, "GITEA")
+			if err != nil {
+				return nil, err
+			}
+			return &giteaProvider{token: token}, nil
+		},
+	})
+}
+
+func giteaAPIBaseURL() string {
+	if base := os.Getenv("GITEA_API_BASE_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	return "https://" + os.Getenv("GITEA_HOSTNAME") + "/api/v1"
+}
+
+// giteaProvider posts commit statuses to Gitea or Forgejo, whose API mirrors
+// GitHub's.
+type giteaProvider struct {
+	token string
+}
+
+func (p *giteaProvider) name() string {
+	return providerGitea
+}
+
+func (p *giteaProvider) report(ctx context.Context, repo, rev string, payload ghReqPayload) (string, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	gtURL := fmt.Sprintf("%s/repos/%s/statuses/%s", giteaAPIBaseURL(), repo, rev)
+	res, resBody, err := doRequest(ctx, "POST", gtURL, "token "+p.token, b)
+	if err != nil {
+		return "", err
+	}
+	if err := expectStatus("Gitea", res, resBody, http.StatusOK, http.StatusCreated); err != nil {
+		return "", err
+	}
+	var created struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(resBody, &created); err != nil {
+		return "", nil
+	}
+	return created.URL, nil
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// githubAPIBaseURL returns the base URL of the GitHub REST API. It defaults to
+// api.github.com. Setting GITHUB_HOSTNAME targets a GitHub Enterprise Server
+// instance instead, whose API is served below GITHUB_API_PATH_PREFIX (default
+// /api/v3; set it to an empty string if the API lives at the root of an API
+// subdomain). GITHUB_API_BASE_URL overrides all of this, and the API base URL
+// of the route matching the invocation, see findRoute, overrides that.
+func githubAPIBaseURL(ctx context.Context) string {
+	if base, ok := ctx.Value(apiBaseURLKey{}).(string); ok && base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	if base := os.Getenv("GITHUB_API_BASE_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	host := os.Getenv("GITHUB_HOSTNAME")
+	if host == "" {
+		return "https://api.github.com"
+	}
+	prefix, ok := os.LookupEnv("GITHUB_API_PATH_PREFIX")
+	if !ok {
+		prefix = "/api/v3"
+	}
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return "https://" + host
+	}
+	return "https://" + host + "/" + prefix
+}
+
+// githubRequest sends a request authenticated with the token to the GitHub
+// API. If GitHub rejects the token, the request is retried once with a new
+// one: a GitHub App's new installation token, e.g. if the old one was
+// revoked, or the current value of the Secrets Manager secret the token was
+// read from, if it was rotated since.
+func githubRequest(ctx context.Context, method, ghURL, token string, body []byte) (
+	*http.Response, []byte, error) {
+	ctx = withGithubHeaders(ctx)
+	ghRes, resBody, err := doRequest(ctx, method, ghURL, "token "+token, body)
+	if err != nil || ghRes.StatusCode != http.StatusUnauthorized {
+		return ghRes, resBody, err
+	}
+	var fresh string
+	var rerr error
+	if githubAppConfigured() {
+		fresh, rerr = refreshGithubAppToken(ctx, token)
+	} else {
+		fresh, rerr = refreshSecret(ctx, token)
+	}
+	if rerr != nil {
+		logger(ctx).Warn("failed to replace rejected token", "error", rerr)
+		return ghRes, resBody, err
+	}
+	if fresh == "" {
+		return ghRes, resBody, err
+	}
+	logger(ctx).Warn("GitHub rejected the token, retrying with a new one", "url", ghURL)
+	return doRequest(ctx, method, ghURL, "token "+fresh, body)
+}
+
+// errUnknownCommit is returned if the repository doesn't have the commit, e.g.
+// because a mirror built a pull request from a fork.
+var errUnknownCommit = errors.New("commit not found in repository")
+
+// Kinds of unexpected responses, see responseError. Rate limited requests
+// may succeed later, the others won't.
+var (
+	errRateLimited  = errors.New("rate limited")
+	errNotFound     = errors.New("not found")
+	errUnauthorized = errors.New("unauthorized")
+)
+
+// responseError is an unexpected response from the API of GitHub or another
+// provider. errors.Is matches it against errRateLimited, errNotFound and
+// errUnauthorized.
+type responseError struct {
+	// From names the API, e.g. GitHub, if known.
+	From        string
+	StatusCode  int
+	Body        string
+	rateLimited bool
+}
+
+func newResponseError(from string, res *http.Response, body []byte) *responseError {
+	return &responseError{From: from, StatusCode: res.StatusCode, Body: string(body),
+		rateLimited: res.StatusCode == http.StatusTooManyRequests ||
+			res.StatusCode == http.StatusForbidden && retryableStatus(res)}
+}
+
+func (e *responseError) Error() string {
+	from := ""
+	if e.From != "" {
+		from = " from " + e.From
+	}
+	return fmt.Sprintf("unexpected response%s: %d body: %s", from, e.StatusCode, e.Body)
+}
+
+// expectStatus returns a responseError unless the response has one of the
+// status codes the API answers successful requests with, e.g. 201 for
+// created and 200 for updated resources.
+func expectStatus(from string, res *http.Response, body []byte, codes ...int) error {
+	for _, c := range codes {
+		if res.StatusCode == c {
+			return nil
+		}
+	}
+	return newResponseError(from, res, body)
+}
+
+func (e *responseError) Is(target error) bool {
+	switch target {
+	case errRateLimited:
+		return e.rateLimited
+	case errNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case errUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	}
+	return false
+}
+
+// postStatus creates the status and returns its ID and API URL, taken from
+// the response body or, failing that, from the Location header some proxies
+// set. The ID is 0 if the response doesn't carry one.
+func postStatus(ctx context.Context, ghURL, token string, payload ghReqPayload) (int64, string, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return 0, "", err
+	}
+	ghRes, resBody, err := githubRequest(ctx, "POST", ghURL, token, b)
+	if err != nil {
+		return 0, "", err
+	}
+	if ghRes.StatusCode == 422 && strings.Contains(string(resBody), "No commit found") {
+		return 0, "", fmt.Errorf("%w: %s", errUnknownCommit, string(resBody))
+	}
+	if perm := ghRes.Header.Get("X-Accepted-GitHub-Permissions"); perm != "" &&
+		(ghRes.StatusCode == 403 || ghRes.StatusCode == 404) {
+		return 0, "", fmt.Errorf("token lacks the permission %s needed to post statuses: %d body: %s",
+			perm, ghRes.StatusCode, string(resBody))
+	}
+	if err := expectStatus("GitHub", ghRes, resBody, http.StatusCreated); err != nil {
+		return 0, "", err
+	}
+
+	var created ghStatusRef
+	err = json.Unmarshal(resBody, &created)
+	if err == nil {
+		err = checkResponse(&created)
+	}
+	if err == nil {
+		logger(ctx).Info("created status", "status-id", created.ID, "status-url", created.URL)
+		return created.ID, created.URL, nil
+	}
+	logger(ctx).Warn("GitHub didn't describe the created status",
+		"location", ghRes.Header.Get("Location"), "error", err)
+	return 0, ghRes.Header.Get("Location"), nil
+}
+
+// ghStatusRef identifies a status.
+type ghStatusRef struct {
+	ID  int64  `json:"id"`
+	URL string `json:"url"`
+}
+
+func (s *ghStatusRef) check() error {
+	switch {
+	case s.ID == 0:
+		return errors.New("status without id")
+	case s.URL == "":
+		return errors.New("status without url")
+	}
+	return nil
+}
+
+// verifyStatus makes sure the status with the given ID is among the commit's
+// statuses. GitHub accepts statuses for commits that exist in a fork only,
+// but they never show up on the repository's pull requests.
+func verifyStatus(ctx context.Context, repo, rev, token string, id int64) error {
+	var statuses []ghStatusRef
+	err := getJSON(ctx, fmt.Sprintf("%s/repos/%s/commits/%s/statuses", githubAPIBaseURL(ctx), repo, rev),
+		token, &statuses)
+	if err != nil {
+		return err
+	}
+	for _, st := range statuses {
+		if st.ID == id {
+			return nil
+		}
+	}
+	return fmt.Errorf("status %d was created but isn't listed on %s@%s", id, repo, rev)
+}
+
+// getJSON performs an authenticated GET against the GitHub API and decodes the
+// JSON response into v.
+func getJSON(ctx context.Context, ghURL, token string, v interface{}) error {
+	ghRes, resBody, err := githubRequest(ctx, "GET", ghURL, token, nil)
+	if err != nil {
+		return err
+	}
+	if err := expectStatus("GitHub", ghRes, resBody, http.StatusOK); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(resBody, v); err != nil {
+		return err
+	}
+	return checkResponse(v)
+}
+
+// sendJSON sends v as the JSON body of an authenticated request to the
+// GitHub API, expects the response status want and decodes the response into
+// out.
+func sendJSON(ctx context.Context, method, ghURL, token string, v interface{}, want int, out interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	ghRes, resBody, err := githubRequest(ctx, method, ghURL, token, b)
+	if err != nil {
+		return err
+	}
+	if err := expectStatus("GitHub", ghRes, resBody, want); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(resBody, out); err != nil {
+		return err
+	}
+	return checkResponse(out)
+}
+
+type ghPullRequest struct {
+	Number int    `json:"number"`
+	State  string `json:"state"`
+	Draft  bool   `json:"draft"`
+}
+
+func (pr *ghPullRequest) check() error {
+	if pr.Number == 0 || pr.State == "" {
+		return errors.New("pull request without number or state")
+	}
+	return nil
+}
+
+// onlyDraftPullRequests reports whether the commit is associated with at
+// least one open pull request and all of those are drafts.
+func onlyDraftPullRequests(ctx context.Context, repo, rev, token string) (bool, error) {
+	var prs []ghPullRequest
+	err := getJSON(ctx, fmt.Sprintf("%s/repos/%s/commits/%s/pulls", githubAPIBaseURL(ctx), repo, rev),
+		token, &prs)
+	if err != nil {
+		return false, err
+	}
+	open := 0
+	for _, pr := range prs {
+		if pr.State != "open" {
+			continue
+		}
+		if !pr.Draft {
+			return false, nil
+		}
+		open++
+	}
+	return open > 0, nil
+}
+
+type ghCommit struct {
+	SHA    string `json:"sha"`
+	Author *struct {
+		Login string `json:"login"`
+		// Type is "Bot" for GitHub Apps.
+		Type string `json:"type"`
+	} `json:"author"`
+	Commit struct {
+		Author struct {
+			Name string `json:"name"`
+		} `json:"author"`
+		Message string `json:"message"`
+	} `json:"commit"`
+}
+
+func (c *ghCommit) check() error {
+	if c.SHA == "" {
+		return errors.New("commit without sha")
+	}
+	return nil
+}
+
+// getCommit looks up the commit with GitHub's commits API.
+func getCommit(ctx context.Context, repo, rev, token string) (*ghCommit, error) {
+	var c ghCommit
+	err := getJSON(ctx, fmt.Sprintf("%s/repos/%s/commits/%s", githubAPIBaseURL(ctx), repo, rev),
+		token, &c)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// commitAuthor returns the GitHub login of the commit's author, or the git
+// author name if the commit isn't linked to a GitHub account. E-mail
+// addresses are never returned.
+func commitAuthor(ctx context.Context, repo, rev, token string) (string, error) {
+	c, err := getCommit(ctx, repo, rev, token)
+	if err != nil {
+		return "", err
+	}
+	if c.Author != nil && c.Author.Login != "" {
+		return "@" + c.Author.Login, nil
+	}
+	return c.Commit.Author.Name, nil
+}
+
+type ghCommitStatus struct {
+	State       string `json:"state"`
+	TargetURL   string `json:"target_url"`
+	Description string `json:"description"`
+	Context     string `json:"context"`
+}
+
+func (s *ghCommitStatus) check() error {
+	if s.State == "" || s.Context == "" {
+		return errors.New("status without state or context")
+	}
+	return nil
+}
+
+// foreignStatus returns the most recent status of the commit that uses
+// statusContext but whose target URL isn't own, i.e. that was posted by some
+// other system. It returns nil if there is none.
+func foreignStatus(ctx context.Context, repo, rev, statusContext string, own func(string) bool,
+	token string) (*ghCommitStatus, error) {
+	var statuses []ghCommitStatus
+	err := getJSON(ctx, fmt.Sprintf("%s/repos/%s/commits/%s/statuses", githubAPIBaseURL(ctx), repo, rev),
+		token, &statuses)
+	if err != nil {
+		return nil, err
+	}
+	for i, st := range statuses {
+		if st.Context == statusContext && !own(st.TargetURL) {
+			return &statuses[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// latestStatus returns the most recent status of the commit that uses
+// statusContext, or nil if there is none.
+func latestStatus(ctx context.Context, repo, rev, statusContext, token string) (
+	*ghCommitStatus, error) {
+	return foreignStatus(ctx, repo, rev, statusContext, func(string) bool { return false }, token)
+}
+
+// checkReachable makes sure the GitHub API answers at all. Any HTTP response
+// counts, only connection errors and timeouts fail.
+func checkReachable(ctx context.Context) error {
+	base := githubAPIBaseURL(ctx)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "HEAD", base+"/", nil)
+	if err != nil {
+		return err
+	}
+	res, err := httpClientFrom(ctx).Do(req)
+	if err != nil {
+		return fmt.Errorf("GitHub endpoint %s unreachable: %w", base, err)
+	}
+	res.Body.Close()
+	return nil
+}
+
+// checkedTokens remembers the tokens and repositories checkTokenScope passed,
+// so that the check runs once per container.
+var checkedTokens sync.Map
+
+// checkTokenScope makes sure the token can access the repository and, for
+// classic tokens, that it has a scope allowing it to post statuses.
+// Fine-grained and GitHub App tokens don't reveal their permissions; if they
+// lack the one to write statuses, posting fails with an error naming it.
+func checkTokenScope(ctx context.Context, repo, token string) error {
+	key := repo + "\x00" + token
+	if _, ok := checkedTokens.Load(key); ok {
+		return nil
+	}
+	ghRes, resBody, err := githubRequest(ctx, "GET",
+		fmt.Sprintf("%s/repos/%s", githubAPIBaseURL(ctx), repo), token, nil)
+	if err != nil {
+		return err
+	}
+	switch ghRes.StatusCode {
+	case 200:
+	case 401:
+		return fmt.Errorf("token was rejected by GitHub: %s", string(resBody))
+	case 403, 404:
+		return fmt.Errorf("token can't access %s: %d body: %s", repo, ghRes.StatusCode,
+			string(resBody))
+	default:
+		return newResponseError("GitHub", ghRes, resBody)
+	}
+	if scopes, ok := ghRes.Header["X-Oauth-Scopes"]; ok {
+		var granted bool
+		for _, sc := range strings.Split(strings.Join(scopes, ","), ",") {
+			sc = strings.TrimSpace(sc)
+			granted = granted || sc == "repo" || sc == "repo:status"
+		}
+		if !granted {
+			return fmt.Errorf("token lacks the repo:status scope needed for %s, it has %q",
+				repo, strings.Join(scopes, ","))
+		}
+	}
+	checkedTokens.Store(key, true)
+	return nil
+}
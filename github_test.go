@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// roundTripFunc answers requests without a server, e.g. for hosts the tests
+// can't listen on.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func jsonResponse(code int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: code,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func TestGithubAPIBaseURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		wantBase string
+	}{
+		{"github.com", nil, "https://api.github.com"},
+		{"Enterprise Server default prefix", map[string]string{
+			"GITHUB_HOSTNAME": "github.example.com",
+		}, "https://github.example.com/api/v3"},
+		{"custom prefix", map[string]string{
+			"GITHUB_HOSTNAME":        "github.example.com",
+			"GITHUB_API_PATH_PREFIX": "/github/api/v3/",
+		}, "https://github.example.com/github/api/v3"},
+		{"empty prefix", map[string]string{
+			"GITHUB_HOSTNAME":        "api.github.example.com",
+			"GITHUB_API_PATH_PREFIX": "",
+		}, "https://api.github.example.com"},
+		{"base URL", map[string]string{
+			"GITHUB_API_BASE_URL": "https://proxy.example.com/github/",
+			"GITHUB_HOSTNAME":     "github.example.com",
+		}, "https://proxy.example.com/github"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"GITHUB_API_BASE_URL", "GITHUB_HOSTNAME"} {
+				t.Setenv(name, "")
+			}
+			for name, v := range tt.env {
+				t.Setenv(name, v)
+			}
+			var posted string
+			ctx := withHTTPClient(context.Background(), &http.Client{
+				Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+					posted = r.URL.String()
+					return jsonResponse(http.StatusCreated,
+						`{"id":1,"url":"`+r.URL.String()+`"}`), nil
+				}),
+			})
+			if got := githubAPIBaseURL(ctx); got != tt.wantBase {
+				t.Errorf("githubAPIBaseURL() = %q, want %q", got, tt.wantBase)
+			}
+			p := &githubProvider{token: testToken}
+			statusURL, err := p.report(ctx, "octo-org/hello-world",
+				"6dcb09b5b57875f334f61aebed695e2e4193db5e", ghReqPayload{State: "success"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.wantBase + "/repos/octo-org/hello-world/statuses/" +
+				"6dcb09b5b57875f334f61aebed695e2e4193db5e"
+			if posted != want || statusURL != want {
+				t.Errorf("status posted to %q, returned %q, want %q", posted, statusURL, want)
+			}
+		})
+	}
+}
+
+// TestRejectedAppToken makes sure an installation token GitHub rejects,
+// e.g. because it expired early, is replaced and the request retried.
+func TestRejectedAppToken(t *testing.T) {
+	const id = "a1000000-0000-4000-8000-000000000001"
+	const sha = "6dcb09b5b57875f334f61aebed695e2e4193db5e"
+	h, gh := newTestHandler(t, newFakeCodePipeline(withExecutionID(loadExecution(t, "github-v1"), id)))
+	issued := useGitHubApp(t, gh)
+	var auths []string
+	gh.mux.HandleFunc("POST /repos/octo-org/hello-world/statuses/{sha}",
+		func(w http.ResponseWriter, r *http.Request) {
+			auths = append(auths, r.Header.Get("Authorization"))
+			if len(auths) == 1 {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"message":"Bad credentials"}`))
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":1,"url":"` + gh.URL + r.URL.Path + `"}`))
+		})
+
+	if err := h.Handle(context.Background(), event{Pipeline: "hello-world", ExecutionID: id}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := issued(), []string{"ghs_1", "ghs_2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("installation tokens issued = %v, want %v", got, want)
+	}
+	if want := []string{"token ghs_1", "token ghs_2"}; !reflect.DeepEqual(auths, want) {
+		t.Errorf("statuses posted with %v, want %v", auths, want)
+	}
+	if n := len(gh.statuses("octo-org/hello-world", sha)); n != 2 {
+		t.Errorf("got %d status requests, want 2", n)
+	}
+}
+
+// TestPostStatusURL makes sure the URL of the created status is taken from
+// the response body or, if that doesn't describe it, from the Location
+// header.
+func TestPostStatusURL(t *testing.T) {
+	const path = "/repos/octo-org/hello-world/statuses/6dcb09b5b57875f334f61aebed695e2e4193db5e"
+	const location = "https://proxy.example.com/statuses/42"
+	tests := []struct {
+		name, body, location string
+		wantID               int64
+		wantURL              string
+	}{
+		{"body", `{"id":42,"url":"https://api.github.com/statuses/42"}`, location,
+			42, "https://api.github.com/statuses/42"},
+		{"Location only", "", location, 0, location},
+		{"body without url", `{"id":42}`, location, 0, location},
+		{"neither", `{}`, "", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.mux.HandleFunc("POST "+path, func(w http.ResponseWriter, r *http.Request) {
+				if tt.location != "" {
+					w.Header().Set("Location", tt.location)
+				}
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(tt.body))
+			})
+			ctx := withHTTPClient(context.Background(), gh.Client())
+			id, statusURL, err := postStatus(ctx, gh.URL+path, testToken,
+				ghReqPayload{State: "success", Context: defaultContext})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != tt.wantID || statusURL != tt.wantURL {
+				t.Errorf("postStatus() = %d, %q, want %d, %q", id, statusURL, tt.wantID, tt.wantURL)
+			}
+		})
+	}
+}
+
+// TestCheckReachable makes sure only failing to connect fails the check,
+// naming the endpoint.
+func TestCheckReachable(t *testing.T) {
+	t.Setenv("GITHUB_API_BASE_URL", "https://github.example.com/api/v3")
+	tests := []struct {
+		name string
+		res  *http.Response
+		err  error
+		want string
+	}{
+		{"answered", jsonResponse(http.StatusOK, `{}`), nil, ""},
+		{"not found", jsonResponse(http.StatusNotFound, `{}`), nil, ""},
+		{"unreachable", nil, errors.New("dial tcp: lookup github.example.com: no such host"),
+			"GitHub endpoint https://github.example.com/api/v3 unreachable: " +
+				"Head \"https://github.example.com/api/v3/\": dial tcp: lookup github.example.com: no such host"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := withHTTPClient(context.Background(), &http.Client{
+				Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+					if r.Method != http.MethodHead {
+						t.Errorf("checked with %s, want HEAD", r.Method)
+					}
+					return tt.res, tt.err
+				}),
+			})
+			err := checkReachable(ctx)
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("checkReachable() = %v, want reachable", err)
+			case tt.want != "" && (err == nil || err.Error() != tt.want):
+				t.Errorf("checkReachable() = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
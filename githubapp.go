@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// githubAppConfigured reports whether to authenticate as a GitHub App
+// rather than with a token.
+func githubAppConfigured() bool {
+	return os.Getenv("GITHUB_APP_ID") != ""
+}
+
+// Installation tokens are replaced this long before they expire, so that
+// they don't expire during an invocation.
+const appTokenRefreshMargin = 5 * time.Minute
+
+// The installation token of this container, reused across warm invocations
+// until shortly before it expires. Concurrent invocations, e.g. of an SQS
+// batch, share it.
+var appToken struct {
+	sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// cachedGithubAppToken returns a valid installation token, creating a new one
+// if necessary.
+func cachedGithubAppToken(ctx context.Context) (string, error) {
+	appToken.Lock()
+	defer appToken.Unlock()
+	if appToken.token != "" && time.Now().Add(appTokenRefreshMargin).Before(appToken.expires) {
+		return appToken.token, nil
+	}
+	return newCachedGithubAppToken(ctx)
+}
+
+// refreshGithubAppToken replaces the installation token that GitHub
+// rejected, unless a concurrent invocation did already.
+func refreshGithubAppToken(ctx context.Context, rejected string) (string, error) {
+	appToken.Lock()
+	defer appToken.Unlock()
+	if appToken.token != "" && appToken.token != rejected &&
+		time.Now().Add(appTokenRefreshMargin).Before(appToken.expires) {
+		return appToken.token, nil
+	}
+	return newCachedGithubAppToken(ctx)
+}
+
+// newCachedGithubAppToken creates an installation token and caches it. The
+// caller must hold the lock.
+func newCachedGithubAppToken(ctx context.Context) (string, error) {
+	appToken.token = ""
+	token, expires, err := githubAppToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	appToken.token, appToken.expires = token, expires
+	addSecret(token)
+	return token, nil
+}
+
+// githubAppPrivateKey loads the GitHub App's private key from
+// GITHUB_APP_PRIVATE_KEY or, if that's unset, from the Secrets Manager secret
+// named by GITHUB_APP_PRIVATE_KEY_SECRET_ARN.
+func githubAppPrivateKey(ctx context.Context) (*rsa.PrivateKey, error) {
+	keyPEM := os.Getenv("GITHUB_APP_PRIVATE_KEY")
+	if keyPEM == "" {
+		arn := os.Getenv("GITHUB_APP_PRIVATE_KEY_SECRET_ARN")
+		if arn == "" {
+			return nil, errors.New("GITHUB_APP_PRIVATE_KEY or " +
+				"GITHUB_APP_PRIVATE_KEY_SECRET_ARN must be set")
+		}
+		var err error
+		keyPEM, err = secretString(ctx, arn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read GitHub App private key from secret %s: %w",
+				arn, err)
+		}
+	}
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, errors.New("GitHub App private key is not PEM encoded")
+	}
+	// GitHub hands out PKCS #1 keys, but converted keys are PKCS #8.
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub App private key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("GitHub App private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// githubAppJWT returns the JSON Web Token authenticating as the App itself.
+func githubAppJWT(appID string, key *rsa.PrivateKey, now time.Time) (string, error) {
+	enc := base64.RawURLEncoding
+	header := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(struct {
+		IssuedAt  int64  `json:"iat"`
+		ExpiresAt int64  `json:"exp"`
+		Issuer    string `json:"iss"`
+	}{
+		// Allow for clock drift, as recommended by GitHub.
+		IssuedAt:  now.Add(-time.Minute).Unix(),
+		ExpiresAt: now.Add(9 * time.Minute).Unix(),
+		Issuer:    appID,
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := header + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + enc.EncodeToString(sig), nil
+}
+
+// githubAppToken exchanges a JWT of the App configured by GITHUB_APP_ID for
+// an access token of its installation GITHUB_APP_INSTALLATION_ID.
+func githubAppToken(ctx context.Context) (string, time.Time, error) {
+	appID := os.Getenv("GITHUB_APP_ID")
+	installationID, err := strconv.ParseInt(os.Getenv("GITHUB_APP_INSTALLATION_ID"), 10, 64)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid GITHUB_APP_INSTALLATION_ID: %w", err)
+	}
+	key, err := githubAppPrivateKey(ctx)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	jwt, err := githubAppJWT(appID, key, time.Now())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	ghURL := fmt.Sprintf("%s/app/installations/%d/access_tokens", githubAPIBaseURL(ctx),
+		installationID)
+	// Creating a token changes nothing on GitHub, and dry runs need one to
+	// look things up.
+	ghRes, resBody, err := doRequest(withoutDryRun(withGithubHeaders(ctx)), "POST", ghURL,
+		"Bearer "+jwt, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if ghRes.StatusCode != 201 {
+		return "", time.Time{}, fmt.Errorf("failed to create installation token: %w",
+			newResponseError("GitHub", ghRes, resBody))
+	}
+	var t ghInstallationToken
+	if err := json.Unmarshal(resBody, &t); err != nil {
+		return "", time.Time{}, err
+	}
+	if err := checkResponse(&t); err != nil {
+		return "", time.Time{}, err
+	}
+	return t.Token, t.ExpiresAt, nil
+}
+
+type ghInstallationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (t *ghInstallationToken) check() error {
+	if t.Token == "" || t.ExpiresAt.IsZero() {
+		return errors.New("installation token without token or expires_at")
+	}
+	return nil
+}
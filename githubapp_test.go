@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// TestGithubAppTokenDryRun makes sure dry runs still get installation
+// tokens, e.g. to replace one GitHub rejected while looking things up.
+func TestGithubAppTokenDryRun(t *testing.T) {
+	gh := newFakeGitHub(t)
+	t.Setenv("GITHUB_API_BASE_URL", gh.URL)
+	issued := useGitHubApp(t, gh)
+	ctx := withDryRun(withHTTPClient(context.Background(), gh.Client()))
+
+	token, err := refreshGithubAppToken(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := issued(), []string{token}; !reflect.DeepEqual(got, want) {
+		t.Errorf("installation tokens issued = %v, want %v", got, want)
+	}
+}
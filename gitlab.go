@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/codestarconnections/types"
+)
+
+func gitlabAPIBaseURL() string {
+	if base := os.Getenv("GITLAB_API_BASE_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	if host := os.Getenv("GITLAB_HOSTNAME"); host != "" {
+		return "https://" + host + "/api/v4"
+	}
+	return "https://gitlab.com/api/v4"
+}
+
+type glCommitStatus struct {
+	State       string `json:"state"`
+	Name        string `json:"name"`
+	TargetURL   string `json:"target_url"`
+	Description string `json:"description,omitempty"`
+}
+
+func init() {
+	registerProvider(providerRegistration{
+		name:    providerGitLab,
+		hosts:   []string{"gitlab.com"},
+		hostEnv: "GITLAB_HOSTNAME",
+		connectionTypes: []types.ProviderType{
+			types.ProviderTypeGitlab, types.ProviderTypeGitlabSelfManaged},
+		newProvider: func(ctx context.Context, ev event) (provider, error) {
+			token, err := providerToken(ctx, "GITLAB")
+			if err != nil {
+				return nil, err
+			}
+			return &gitlabProvider{token: token}, nil
+		},
+	})
+}
+
+// gitlabProvider posts commit statuses to GitLab.
+type gitlabProvider struct {
+	token string
+}
+
+func (p *gitlabProvider) name() string {
+	return providerGitLab
+}
+
+func (p *gitlabProvider) report(ctx context.Context, repo, rev string, payload ghReqPayload) (string, error) {
+	st := glCommitStatus{
+		Name:        payload.Context,
+		TargetURL:   payload.TargetURL,
+		Description: payload.Description,
+	}
+	switch payload.State {
+	case "pending":
+		st.State = "running"
+	case "success":
+		st.State = "success"
+	default:
+		st.State = "failed"
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return "", err
+	}
+	// Projects can be addressed by their URL-encoded path instead of their ID.
+	glURL := fmt.Sprintf("%s/projects/%s/statuses/%s",
+		gitlabAPIBaseURL(), url.PathEscape(repo), rev)
+	res, resBody, err := doRequest(ctx, "POST", glURL, "Bearer "+p.token, b)
+	if err != nil {
+		return "", err
+	}
+	if err := expectStatus("GitLab", res, resBody, http.StatusOK, http.StatusCreated); err != nil {
+		return "", err
+	}
+	return "", nil
+}
@@ -1,147 +1,1110 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/codepipeline"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/aws/smithy-go"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-type event struct {
-	ExecutionID string `json:"execution-id"`
-	GithubToken string `json:"github-token"`
-	Pipeline    string `json:"pipeline"`
-}
-
 type ghReqPayload struct {
 	State       string `json:"state"`
 	TargetURL   string `json:"target_url"`
 	Description string `json:"description"`
 	Context     string `json:"context"`
+
+	// Summary is the markdown body of check runs.
+	Summary string `json:"-"`
+	// Annotations mark lines of the check run's commit.
+	Annotations []ghAnnotation `json:"-"`
+	// Stage is the stage reported on, or empty for the overall execution.
+	Stage string `json:"-"`
 }
 
-// HandleLambdaEvent is triggered by a CloudWatch event rule.
-func HandleLambdaEvent(ev event) error {
-	if ev.ExecutionID == "" {
-		return errors.New("missing event param execution-id")
+const defaultPostConcurrency = 4
+
+// postConcurrency returns how many repositories statuses are set in at once,
+// from POST_CONCURRENCY.
+func postConcurrency() int {
+	n, err := strconv.Atoi(os.Getenv("POST_CONCURRENCY"))
+	if err != nil || n < 1 {
+		return defaultPostConcurrency
 	}
-	if ev.GithubToken == "" {
-		return errors.New("missing event param github-token")
+	return n
+}
+
+// defaultContext is the default context of the status of the overall
+// execution.
+const defaultContext = "continuous-integration/codepipeline"
+
+// interruption describes how an execution that didn't run to completion is
+// reported. An empty state means it isn't reported at all.
+type interruption struct {
+	state       string
+	description string
+}
+
+// interruptions holds the environment variable configuring each state that
+// interrupts an execution, its default and its description.
+var interruptions = map[string]struct {
+	env         string
+	def         string
+	description string
+}{
+	"Stopping":   {"STOPPING_BEHAVIOR", "skip", "Cancelling"},
+	"Stopped":    {"STOPPED_BEHAVIOR", "error", "Stopped"},
+	"Superseded": {"SUPERSEDED_BEHAVIOR", "skip", "Superseded by a newer execution"},
+	"Cancelled":  {"CANCELLED_BEHAVIOR", "error", "Cancelled"},
+}
+
+// interruptedBehavior returns how to report an execution in the given state.
+// The behavior is one of skip, pending, error or failure.
+func interruptedBehavior(status string) (interruption, error) {
+	i := interruptions[status]
+	b := os.Getenv(i.env)
+	if b == "" {
+		b = i.def
 	}
-	if ev.Pipeline == "" {
-		return errors.New("missing event param pipeline")
+	switch b {
+	case "skip":
+		return interruption{}, nil
+	case "pending", "error", "failure":
+		return interruption{state: b, description: i.description}, nil
 	}
+	return interruption{}, fmt.Errorf("invalid %s %q", i.env, b)
+}
 
-	sess := session.Must(session.NewSession())
-	cpSvc := codepipeline.New(sess)
-	res, err := cpSvc.GetPipelineExecution(&codepipeline.GetPipelineExecutionInput{
-		PipelineExecutionId: aws.String(ev.ExecutionID),
-		PipelineName:        aws.String(ev.Pipeline),
-	})
+// stateMapping returns the states configured by STATE_MAPPING, a JSON object
+// mapping execution statuses to skip, pending, success, error or failure.
+func stateMapping() (map[string]string, error) {
+	m := map[string]string{}
+	v := os.Getenv("STATE_MAPPING")
+	if v == "" {
+		return m, nil
+	}
+	if err := json.Unmarshal([]byte(v), &m); err != nil {
+		return nil, fmt.Errorf("invalid STATE_MAPPING: %w", err)
+	}
+	for status, state := range m {
+		known := false
+		for _, s := range types.PipelineExecutionStatus("").Values() {
+			known = known || string(s) == status
+		}
+		if !known {
+			return nil, fmt.Errorf("invalid STATE_MAPPING: unknown status %q", status)
+		}
+		switch state {
+		case "skip", "pending", "success", "error", "failure":
+		default:
+			return nil, fmt.Errorf("invalid STATE_MAPPING: invalid state %q for %s", state, status)
+		}
+	}
+	return m, nil
+}
+
+// executionState maps the status of a pipeline execution to the state of a
+// status and its description. The state is empty if the execution isn't
+// reported in its current status. STATE_MAPPING takes precedence over the
+// defaults and the behaviors of interruptions.
+func executionState(status string) (string, string, error) {
+	m, err := stateMapping()
 	if err != nil {
-		return err
+		return "", "", err
+	}
+	if state, ok := m[status]; ok {
+		if state == "skip" {
+			return "", "", nil
+		}
+		return state, interruptions[status].description, nil
+	}
+	switch status {
+	case "InProgress":
+		return "pending", "", nil
+	case "Stopping", "Stopped", "Superseded", "Cancelled":
+		b, err := interruptedBehavior(status)
+		return b.state, b.description, err
+	case "Succeeded":
+		return "success", "", nil
+	}
+	return "failure", "", nil
+}
+
+// codepipelineAPI is the part of the CodePipeline API the handler uses.
+type codepipelineAPI interface {
+	GetPipelineExecution(context.Context, *codepipeline.GetPipelineExecutionInput,
+		...func(*codepipeline.Options)) (*codepipeline.GetPipelineExecutionOutput, error)
+	GetPipeline(context.Context, *codepipeline.GetPipelineInput,
+		...func(*codepipeline.Options)) (*codepipeline.GetPipelineOutput, error)
+	GetPipelineState(context.Context, *codepipeline.GetPipelineStateInput,
+		...func(*codepipeline.Options)) (*codepipeline.GetPipelineStateOutput, error)
+	PutApprovalResult(context.Context, *codepipeline.PutApprovalResultInput,
+		...func(*codepipeline.Options)) (*codepipeline.PutApprovalResultOutput, error)
+	codepipeline.ListActionExecutionsAPIClient
+	codepipeline.ListPipelineExecutionsAPIClient
+	codepipeline.ListTagsForResourceAPIClient
+}
+
+// Handler sets the statuses of pipeline executions. Its dependencies can be
+// replaced, e.g. by fakes.
+type Handler struct {
+	CodePipeline codepipelineAPI
+	// CodeBuild looks up the test reports of failed builds.
+	CodeBuild codebuildAPI
+	// EventBridge publishes the events of EVENT_BUS_NAME.
+	EventBridge eventbridgeAPI
+	// HTTP sends the requests to GitHub and the other providers.
+	HTTP *http.Client
+	// NewProvider returns the provider hosting the repository the revision
+	// URL points to.
+	NewProvider func(ctx context.Context, u *url.URL, ev event) (provider, error)
+}
+
+// newHandler returns a Handler using the clients shared by all invocations.
+func newHandler() *Handler {
+	return &Handler{CodePipeline: codepipelineClient, CodeBuild: codebuildClient,
+		EventBridge: eventbridgeClient, HTTP: httpClient, NewProvider: newProvider}
+}
+
+// HandleLambdaEvent is triggered by a CloudWatch event rule.
+func HandleLambdaEvent(ctx context.Context, ev event) error {
+	return newHandler().Handle(ctx, ev)
+}
+
+// Handle sets the statuses for the event.
+func (h *Handler) Handle(ctx context.Context, ev event) error {
+	// The subsegment ties the trace to the pipeline execution.
+	ctx, seg := xray.BeginSubsegment(ctx, "set-status")
+	if seg != nil {
+		seg.AddAnnotation("pipeline", ev.Pipeline)
+		seg.AddAnnotation("execution_id", ev.ExecutionID)
 	}
+	ctx, span := startSpan(ctx, "set-status", attribute.String("pipeline", ev.Pipeline),
+		attribute.String("execution_id", ev.ExecutionID))
+	started := time.Now()
+	l := invocationLogger(ctx, ev)
+	m := newMetrics()
+	events := &statusEvents{}
+	ctx = withHTTPClient(withMetrics(withLogger(ctx, l), m), h.HTTP)
+	ctx = withStatusEvents(ctx, events)
+	sum := summary{Pipeline: ev.Pipeline, ExecutionID: ev.ExecutionID, logger: l}
+	err := loadConfig(ctx)
+	if err == nil {
+		err = h.handleEvent(ctx, ev, &sum)
+	}
+	err = redactError(err)
+	if err != nil {
+		l.Error("failed to set status", "error", err, "category", errorCategory(err),
+			"permanent", isPermanent(err))
+		if !m.hasErrors() {
+			m.failed(err)
+		}
+		recordFailure(ctx, ev, started, sum.failedPosts, err)
+	}
+	events.publish(ctx, h.EventBridge)
+	sum.write(err)
+	m.write(ev.Pipeline)
+	if seg != nil {
+		seg.Close(err)
+	}
+	endSpan(span, err)
+	return err
+}
 
-	var sourceArti *codepipeline.ArtifactRevision
-	for _, a := range res.PipelineExecution.ArtifactRevisions {
-		if aws.StringValue(a.Name) == "SourceArtifact" {
-			sourceArti = a
-			break
+func (h *Handler) handleEvent(ctx context.Context, ev event, sum *summary) error {
+	// A rule matching all pipelines passes events of pipelines not configured
+	// here, which needn't be valid.
+	if ev.Pipeline != "" {
+		reason, err := pipelineFilter(ev.Pipeline)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			sum.skip(reason)
+			return nil
 		}
 	}
-	if sourceArti == nil {
-		return errors.New("missing SourceArtifact")
+	if err := ev.validate(); err != nil {
+		return permanent(err)
 	}
 
-	rev := aws.StringValue(sourceArti.RevisionId)
-	url, err := url.Parse(aws.StringValue(sourceArti.RevisionUrl))
+	checks, err := checksEnabled(ev)
 	if err != nil {
 		return err
 	}
-	log.Printf("revision ID: %v URL: %v\n", rev, url)
 
-	status := aws.StringValue(res.PipelineExecution.Status)
-	var ghStatus string
-	switch status {
-	case "InProgress":
-		ghStatus = "pending"
-	case "Succeeded":
-		ghStatus = "success"
-	default:
-		ghStatus = "failure"
+	if checks && !githubAppConfigured() {
+		// Only GitHub Apps may create check runs.
+		return errors.New("check runs (GITHUB_REPORTER=checks or ROLLOUT_CHECKS) require GitHub App authentication")
 	}
 
-	repo, err := extractRepoName(url)
+	role, err := ev.roleARN(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to extract repo name from artifact url %v: %w", url, err)
+		return err
+	}
+	region := ev.pipelineRegion()
+	if role != "" || region != awsConfig.Region {
+		logger(ctx).Info("using CodePipeline client", "role-arn", role, "region", region)
+		other := *h
+		other.CodePipeline = codepipelineClientFor(ev, role)
+		h = &other
 	}
 
-	deepLink := fmt.Sprintf(
-		"https://%s.console.aws.amazon.com/codesuite/codepipeline/pipelines/%s/executions/%s",
-		"eu-west-1", ev.Pipeline, ev.ExecutionID)
-	ghURL := fmt.Sprintf("https://api.github.com/repos/%s/statuses/%s", repo, rev)
-
-	log.Printf("Setting status for repo=%s, commit=%s to %s\n", repo, rev, ghStatus)
+	tags, err := pipelineTags(ctx, h.CodePipeline, ev.Pipeline)
+	if err != nil {
+		return err
+	}
+	if ev.applyTags(tags) {
+		sum.skip("Disabled by the pipeline's " + tagPrefix + "disabled tag")
+		return nil
+	}
+	features, err := ev.applyRollouts()
+	if err != nil {
+		return err
+	}
+	if checks && os.Getenv("GITHUB_REPORTER") == "" {
+		features = append(features, featureChecks)
+	}
+	if len(features) > 0 {
+		logger(ctx).Info("enabled rolled out features", "features", features)
+	}
 
-	var b bytes.Buffer
-	err = json.NewEncoder(&b).Encode(ghReqPayload{
-		State:     ghStatus,
-		TargetURL: deepLink,
-		Context:   "continuous-integration/codepipeline",
+	res, err := h.CodePipeline.GetPipelineExecution(ctx, &codepipeline.GetPipelineExecutionInput{
+		PipelineExecutionId: aws.String(ev.ExecutionID),
+		PipelineName:        aws.String(ev.Pipeline),
 	})
+	if isAccessDenied(err) {
+		return fmt.Errorf("the Lambda's role is not allowed to call "+
+			"codepipeline:GetPipelineExecution on pipeline %s, check its IAM policy: %w",
+			ev.Pipeline, err)
+	}
+	if err != nil {
+		return err
+	}
+	if res.PipelineExecution, err = awaitArtifacts(ctx, h.CodePipeline, ev,
+		res.PipelineExecution); err != nil {
+		return err
+	}
+
+	sources, err := findSources(ctx, res.PipelineExecution.ArtifactRevisions,
+		&pipelineDeclaration{cp: h.CodePipeline, pipeline: ev.Pipeline, region: region})
+	sources, err = variableSources(ctx, res.PipelineExecution, sources, err)
+	if errors.Is(err, errNoGitSources) {
+		logger(ctx).Info("not setting status", "reason", err.Error())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(ev.Commits) > 0 && len(sources) > 1 {
+		return errors.New("commits can't be combined with several source artifacts")
+	}
+	rt, err := findRoute(ctx, ev.Pipeline, sources)
+	if err != nil {
+		return err
+	}
+	ctx = withRoute(ctx, rt)
+	if err := ev.resolveToken(ctx, rt); err != nil {
+		return err
+	}
+
+	status := ev.State
+	if status == "" {
+		status = string(res.PipelineExecution.Status)
+	}
+	sum.PipelineStatus = status
+	ghStatus, description, err := executionState(status)
 	if err != nil {
 		return err
 	}
+	if ghStatus == "" {
+		sum.skip(fmt.Sprintf("Execution is %s", strings.ToLower(status)))
+		return nil
+	}
+	if status == "Superseded" || os.Getenv("LATEST_EXECUTION_ONLY") == "true" {
+		if sources, err = latestSources(ctx, h.CodePipeline, ev, sources); err != nil {
+			return err
+		}
+		if len(sources) == 0 {
+			sum.skip("A newer execution builds the same commit")
+			return nil
+		}
+	}
 
-	ghReq, err := http.NewRequest("POST", ghURL, &b)
+	execKey := executionKey(ev.Pipeline, ev.ExecutionID)
+	if !isTerminal(ghStatus) {
+		if prev := reportedTerminalState(execKey); prev != "" {
+			sum.skip(fmt.Sprintf("Execution already reported as %s, ignoring late %s", prev, status))
+			return nil
+		}
+	}
+
+	var checkSummary string
+	var annotations []ghAnnotation
+	var stages []*stageResult
+	var actions []types.ActionExecutionDetail
+	commentOnFailure := ev.PRComment && ghStatus == "failure"
+	failureDetails := ev.FailureDetails && ghStatus == "failure"
+	linkLogs := ev.LinkLogs && ghStatus == "failure"
+	envs, err := deployEnvironments(ev)
 	if err != nil {
 		return err
 	}
-	ghReq.Header.Set("Accept", "application/json")
-	ghReq.Header.Set("Authorization", "token "+ev.GithubToken)
-	ghReq.Header.Set("Content-Type", "application/json; charset=utf-8")
-	client := &http.Client{}
-	ghRes, err := client.Do(ghReq)
+	notifyState := ghStatus
+	if ev.StuckFor > 0 && ghStatus == "pending" {
+		notifyState = "stuck"
+	}
+	var notifiers []notifier
+	if notifyStates()[notifyState] {
+		notifiers, err = configuredNotifiers(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	jira, err := configuredJira(ctx)
 	if err != nil {
 		return err
 	}
-	defer ghRes.Body.Close()
-	if ghRes.StatusCode != 201 {
-		resBody, _ := ioutil.ReadAll(ghRes.Body)
-		return fmt.Errorf("unexpected response from GitHub: %d body: %s",
-			ghRes.StatusCode, string(resBody))
+	urlTmpl := targetURLTemplate(ev)
+	if ev.TrackApprovals || ev.PerStage || ev.StageState != "" ||
+		ev.ApprovalStatus || checks || commentOnFailure || failureDetails || linkLogs ||
+		envs != nil || templateUsesActions(urlTmpl) {
+		actions, err = listActionExecutions(ctx, h.CodePipeline, ev.Pipeline, ev.ExecutionID)
+		if err != nil {
+			return fmt.Errorf("failed to list action executions: %w", err)
+		}
+		if checks {
+			checkSummary = checkRunSummary(actions)
+			if ev.TestAnnotations && ghStatus == "failure" {
+				annotations, err = testAnnotations(ctx, h.CodeBuild, actions)
+				if err != nil {
+					return fmt.Errorf("failed to annotate test failures: %w", err)
+				}
+			}
+		}
+		switch {
+		case ev.StageState != "":
+			stages = []*stageResult{eventStage(ev, actions)}
+		case ev.PerStage:
+			stages = stageResults(actions)
+		}
+		if failureDetails {
+			if d := failureDescription(actions); d != "" {
+				description = appendDescription(description, d)
+			}
+		}
+		if ev.TrackApprovals {
+			if s, d := approvalState(status, actions); s != "" {
+				ghStatus = s
+				description = appendDescription(description, d)
+			}
+		}
+	}
+
+	if ev.NumberAttempts {
+		d, err := attemptDescription(ctx, h.CodePipeline, ev.Pipeline, ev.ExecutionID)
+		if err != nil {
+			return fmt.Errorf("failed to get pipeline state: %w", err)
+		}
+		if d != "" {
+			description = appendDescription(description, d)
+		}
+	}
+
+	if ev.DetectDisabledTransitions && status == string(types.PipelineExecutionStatusInProgress) {
+		d, err := disabledTransitionDescription(ctx, h.CodePipeline, ev.Pipeline, ev.ExecutionID)
+		if err != nil {
+			return fmt.Errorf("failed to get pipeline state: %w", err)
+		}
+		if d != "" {
+			description = appendDescription(description, d)
+		}
+	}
+
+	// The pipeline may live in another region than the function, see
+	// pipelineRegion.
+	deepLink := fmt.Sprintf(
+		"https://%s.%s/codesuite/codepipeline/pipelines/%s/executions/%s",
+		region, consoleDomain(region), ev.Pipeline, ev.ExecutionID)
+	// Approvals are reviewed on the pipeline's page.
+	reviewURL := fmt.Sprintf(
+		"https://%s.%s/codesuite/codepipeline/pipelines/%s/view?region=%s",
+		region, consoleDomain(region), ev.Pipeline, region)
+	rollback := isRollback(res.PipelineExecution)
+	if rollback {
+		// The execution page of a rollback only shows the stages it re-ran;
+		// its timeline shows what was rolled back to.
+		deepLink += "/timeline"
+		if m := res.PipelineExecution.RollbackMetadata; m != nil {
+			logger(ctx).Info("rollback",
+				"target-execution-id", aws.ToString(m.RollbackTargetPipelineExecutionId))
+		}
+	}
+	tag := executionTag(res.PipelineExecution)
+	if tag != "" {
+		logger(ctx).Info("execution was triggered by a tag", "tag", tag)
+	}
+	linkData := targetURLData{
+		Region:        region,
+		Partition:     partition(region),
+		ConsoleDomain: consoleDomain(region),
+		Pipeline:      ev.Pipeline,
+		ExecutionID:   ev.ExecutionID,
+		Tag:           tag,
+		ExecutionURL:  deepLink,
+	}
+	stageLink := func(stage, action string) string {
+		d := linkData
+		d.Stage, d.Action = stage, action
+		// The template rendered for the overall status already.
+		u, _ := renderTargetURL(urlTmpl, d)
+		return u
+	}
+	linkData.Stage, linkData.Action = failedAction(actions)
+	if deepLink, err = renderTargetURL(urlTmpl, linkData); err != nil {
+		return err
+	}
+
+	statusContext := ev.Context
+	if statusContext == "" && rollback {
+		statusContext = rollbackContext()
+	}
+	if statusContext == "" && isManual(res.PipelineExecution) {
+		statusContext = manualContext()
+	}
+	if statusContext == "" && tag != "" {
+		statusContext = tagContext()
+	}
+	if statusContext == "" {
+		statusContext = os.Getenv("STATUS_CONTEXT")
+	}
+	if statusContext == "" {
+		statusContext = defaultContext
+	}
+
+	var duration time.Duration
+	tmpl := descriptionTemplate(ev)
+	includeDuration := ev.IncludeDuration && isTerminal(ghStatus)
+	if templateUsesDuration(tmpl) || includeDuration {
+		duration, err = executionDuration(ctx, h.CodePipeline, ev.Pipeline, ev.ExecutionID)
+		if err != nil {
+			return fmt.Errorf("failed to look up duration of execution: %w", err)
+		}
+	}
+	if includeDuration {
+		description = appendDescription(description, durationDescription(status, duration))
+		metricsFrom(ctx).executionDone(duration)
+	}
+	if ev.StuckFor > 0 && ghStatus == "pending" {
+		description = appendDescription(description, stuckDescription(ev.StuckFor))
+	}
+	if rollback {
+		description = appendDescription(description, rollbackDescription(res.PipelineExecution))
+	}
+	if tag != "" {
+		description = appendDescription(description, "Tag "+tag)
+	}
+	// Mentioning the author of manual executions says "started by" already.
+	if ev.IncludeTrigger && !rollback && !(ev.IncludeAuthor && isManual(res.PipelineExecution)) {
+		if d := triggerDescription(res.PipelineExecution); d != "" {
+			description = appendDescription(description, d)
+		}
 	}
 
+	// A post is a status to set on a commit of a source.
+	type post struct {
+		src     source
+		prov    provider
+		commit  string
+		payload ghReqPayload
+	}
+	// extras are the commits to comment on or deploy.
+	var posts, extras []post
+	var notifications []notification
+	reachable := false
+	for i, src := range sources {
+		prov, err := h.NewProvider(ctx, src.url, ev)
+		if err != nil {
+			return err
+		}
+		onGitHub := prov.name() == providerGitHub
+
+		srcDescription := description
+		var author string
+		if (ev.IncludeAuthor || len(notifiers) > 0) && onGitHub {
+			author, err = executionAuthor(ctx, res.PipelineExecution, src.repo, src.rev, ev.GithubToken)
+			if err != nil {
+				logger(ctx).Warn("failed to resolve author",
+					"repository", src.repo, "commit", src.rev, "error", err)
+			} else if author != "" && ev.IncludeAuthor {
+				srcDescription = appendDescription(srcDescription, author)
+			}
+		}
+
+		if tmpl != "" {
+			srcDescription, err = renderDescription(tmpl, descriptionData{
+				Pipeline:    ev.Pipeline,
+				ExecutionID: ev.ExecutionID,
+				Status:      status,
+				State:       notifyState,
+				Repository:  src.repo,
+				Commit:      src.rev,
+				Description: srcDescription,
+				Duration:    duration,
+				Tag:         tag,
+				Trigger:     triggerType(res.PipelineExecution),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		targetURL := deepLink
+		if linkLogs {
+			if u := failedActionLogsURL(actions); u != "" {
+				targetURL = u
+			}
+		}
+		payload := ghReqPayload{
+			State:       ghStatus,
+			TargetURL:   targetURL,
+			Description: truncateDescription(srcDescription),
+			Context:     statusContext,
+			Summary:     checkSummary,
+			Annotations: annotations,
+		}
+		payloads := append([]ghReqPayload{payload}, stagePayloads(stages, stageLink)...)
+		if ev.ApprovalStatus {
+			if p := approvalPayload(ev, actions, reviewURL, deepLink); p != nil {
+				payloads = append(payloads, *p)
+			}
+		}
+
+		if i == 0 {
+			sum.Provider = prov.name()
+			sum.Repository = src.repo
+			sum.State = payload.State
+			sum.Context = payload.Context
+			sum.Description = payload.Description
+			sum.TargetURL = payload.TargetURL
+		}
+
+		if ev.CheckReachable && onGitHub && !reachable {
+			if err := checkReachable(ctx); err != nil {
+				return err
+			}
+			reachable = true
+		}
+		if ev.CheckToken && onGitHub {
+			if err := checkTokenScope(ctx, src.repo, ev.GithubToken); err != nil {
+				return err
+			}
+		}
+
+		commits := ev.Commits
+		if len(commits) == 0 {
+			commits = []string{src.rev}
+		}
+		if len(notifiers) > 0 {
+			notifications = append(notifications, notification{
+				Pipeline:    ev.Pipeline,
+				ExecutionID: ev.ExecutionID,
+				State:       notifyState,
+				Repository:  src.repo,
+				Commits:     commits,
+				Author:      author,
+				URL:         deepLink,
+			})
+		}
+		for _, c := range commits {
+			for _, p := range payloads {
+				posts = append(posts, post{src: src, prov: prov, commit: c, payload: p})
+			}
+			for _, t := range ev.Targets {
+				tp := payload
+				if t.Context != "" {
+					tp.Context = t.Context
+				}
+				tsrc := src
+				tsrc.repo = t.Repository
+				posts = append(posts, post{src: tsrc, prov: prov, commit: c, payload: tp})
+			}
+			if (ev.PRComment || envs != nil || jira != nil) && onGitHub {
+				extras = append(extras, post{src: src, prov: prov, commit: c})
+			}
+		}
+	}
+
+	// Statuses of up to POST_CONCURRENCY repositories are set concurrently,
+	// those of a repository one after the other. A failure doesn't keep the
+	// other statuses from being set.
+	byRepo := map[string][]int{}
+	var repos []string
+	for i, p := range posts {
+		if _, ok := byRepo[p.src.repo]; !ok {
+			repos = append(repos, p.src.repo)
+		}
+		byRepo[p.src.repo] = append(byRepo[p.src.repo], i)
+	}
+	summaries := make([]commitSummary, len(posts))
+	errs := make([]error, len(posts))
+	sem := make(chan struct{}, postConcurrency())
+	var wg sync.WaitGroup
+	for _, repo := range repos {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(indices []int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			for n, i := range indices {
+				if n > 0 {
+					// GitHub asks clients to pause between requests
+					// creating content to stay clear of its secondary rate
+					// limits.
+					if err := sleep(ctx, time.Second); err != nil {
+						errs[i] = err
+						continue
+					}
+				}
+				p := posts[i]
+				summaries[i], errs[i] = reportCommit(ctx, ev, p.prov, p.src.repo, p.commit, p.payload)
+			}
+		}(byRepo[repo])
+	}
+	wg.Wait()
+
+	var failed []error
+	for i, p := range posts {
+		cs, err := summaries[i], errs[i]
+		if err != nil {
+			cs.Action = "failed"
+			cs.Error = redact(err.Error())
+		}
+		sum.Commits = append(sum.Commits, cs)
+		if err != nil {
+			metricsFrom(ctx).failed(err)
+			sum.failedPosts = append(sum.failedPosts, failedPost{Repository: p.src.repo,
+				Commit: p.commit, Payload: p.payload, Error: redact(err.Error())})
+			if len(posts) == 1 {
+				return err
+			}
+			logger(ctx).Warn("failed to set status", "repository", p.src.repo,
+				"commit", p.commit, "context", p.payload.Context, "error", err)
+			failed = append(failed, fmt.Errorf("%s@%s %s: %w", p.src.repo, p.commit,
+				p.payload.Context, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to set %d of %d statuses: %w",
+			len(failed), len(posts), errors.Join(failed...))
+	}
+
+	if ev.dryRun() {
+		logger(ctx).Info("dry run, not reporting deployments, commenting or notifying")
+		return nil
+	}
+
+	deployStages := stageResults(actions)
+	if envs != nil {
+		for _, c := range extras {
+			err := reportDeployments(ctx, ev, envs, c.src.repo, c.commit, deepLink, deployStages)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if jira != nil && jira.deployed(envs, ghStatus, deployStages) {
+		for _, c := range extras {
+			if err := jira.transitionCommitIssues(ctx, c.src.repo, c.commit, ev.GithubToken); err != nil {
+				logger(ctx).Warn("failed to transition Jira issues", "repository", c.src.repo,
+					"commit", c.commit, "error", err)
+			}
+		}
+	}
+
+	if ev.PRComment && (ghStatus == "failure" || ghStatus == "success") {
+		// A comment on success only updates the one left by a failure.
+		for _, c := range extras {
+			body := successComment(ev.Pipeline, deepLink)
+			if ghStatus == "failure" {
+				commit, err := getCommit(ctx, c.src.repo, c.commit, ev.GithubToken)
+				if err != nil {
+					logger(ctx).Warn("failed to look up commit", "repository", c.src.repo,
+						"commit", c.commit, "error", err)
+				}
+				body = failureComment(ev.Pipeline, deepLink, commit, actions)
+			}
+			err := commentOnPullRequests(ctx, c.src.repo, c.commit, ev.GithubToken, ev.Pipeline,
+				body, ghStatus == "success")
+			if err != nil {
+				logger(ctx).Warn("failed to comment on pull requests",
+					"repository", c.src.repo, "commit", c.commit, "error", err)
+			}
+		}
+	}
+
+	for _, n := range notifications {
+		for _, nt := range notifiers {
+			if err := nt.notify(ctx, n); err != nil {
+				logger(ctx).Warn("failed to send notification", "repository", n.Repository,
+					"error", err)
+			}
+		}
+	}
+
+	if isTerminal(ghStatus) {
+		recordTerminalState(execKey, ghStatus)
+	}
 	return nil
 }
 
-func extractRepoName(url *url.URL) (string, error) {
-	switch url.Hostname() {
-	case "github.com":
-		p := strings.Split(url.Path, "/")
-		if len(p) < 3 {
-			return "", fmt.Errorf("too few path components")
+// reportCommit posts the status to a single commit.
+func reportCommit(ctx context.Context, ev event, prov provider, repo, rev string,
+	payload ghReqPayload) (commitSummary, error) {
+	payload.Description = truncateDescription(payload.Description)
+	payload.Context = sanitizeContext(payload.Context)
+	cs := commitSummary{Repository: repo, Commit: rev, Context: payload.Context}
+	l := logger(ctx).With("repository", repo, "commit", rev, "context", payload.Context,
+		"state", payload.State)
+	ctx = withLogger(ctx, l)
+	skip := func(reason string) (commitSummary, error) {
+		l.Info("not setting status", "reason", reason)
+		cs.Action = "skipped"
+		cs.Reason = reason
+		return cs, nil
+	}
+
+	sha, err := normalizeCommit(ctx, prov, repo, rev, ev.GithubToken)
+	if errors.Is(err, errNotACommit) {
+		return skip(fmt.Sprintf("Revision %s is not a Git commit", rev))
+	}
+	if err != nil {
+		return cs, err
+	}
+	rev = sha
+	cs.Commit = sha
+
+	onGitHub := prov.name() == providerGitHub
+	if ev.SkipDraftPRs && onGitHub {
+		draft, err := onlyDraftPullRequests(ctx, repo, rev, ev.GithubToken)
+		if err != nil {
+			return cs, fmt.Errorf("failed to look up pull requests of %s@%s: %w", repo, rev, err)
+		}
+		if draft {
+			return skip("Commit only belongs to draft pull requests")
+		}
+	}
+
+	if onGitHub {
+		rules, err := configuredSuppression()
+		if err != nil {
+			return cs, err
+		}
+		if rules != nil {
+			reason, err := rules.suppressionReason(ctx, repo, rev, ev.GithubToken)
+			if err != nil {
+				return cs, fmt.Errorf("failed to apply suppression rules to %s@%s: %w", repo, rev, err)
+			}
+			if reason != "" {
+				return skip(reason)
+			}
+		}
+	}
+
+	if ev.Repair && onGitHub {
+		st, err := latestStatus(ctx, repo, rev, payload.Context, ev.GithubToken)
+		if err != nil {
+			return cs, fmt.Errorf("failed to look up statuses of %s@%s: %w", repo, rev, err)
+		}
+		// Stuck executions get a warning, which the status may lack.
+		if st != nil && st.State == payload.State &&
+			(ev.StuckFor == 0 || st.Description == payload.Description) {
+			return skip("Status is already set")
+		}
+	}
+
+	if ev.ContextCollision != "" && onGitHub {
+		// This function's statuses link into the AWS console, to the
+		// execution or the logs of a failed action, or to wherever the
+		// target URL template points.
+		var ownHost string
+		if u, err := url.Parse(payload.TargetURL); err == nil {
+			ownHost = u.Hostname()
+		}
+		own := func(targetURL string) bool {
+			u, err := url.Parse(targetURL)
+			return err == nil && (isConsoleHost(u.Hostname()) ||
+				ownHost != "" && u.Hostname() == ownHost)
+		}
+		st, err := foreignStatus(ctx, repo, rev, payload.Context, own, ev.GithubToken)
+		if err != nil {
+			return cs, fmt.Errorf("failed to look up statuses of %s@%s: %w", repo, rev, err)
+		}
+		if st != nil {
+			l.Warn("context is also used by another system", "other-target-url", st.TargetURL)
+			if ev.ContextCollision == "skip" {
+				return skip("Context is used by another system")
+			}
+		}
+	}
+
+	if ev.dryRun() {
+		// The provider logs the request it would send.
+		_, err := prov.report(withDryRun(ctx), repo, rev, payload)
+		if err != nil && !errors.Is(err, errDryRun) {
+			return cs, err
 		}
-		return fmt.Sprintf("%s/%s", p[1], p[2]), nil
-	case "eu-west-1.console.aws.amazon.com":
-		if url.Path != "/codesuite/settings/connections/redirect" {
-			return "", fmt.Errorf("unexpected URL path: %v", url.Path)
+		return skip("Dry run")
+	}
+
+	if pipelines := fanInPipelines(ev.Pipeline); pipelines != nil && payload.Stage == "" {
+		combined, err := fanIn(ctx, pipelines, ev.Pipeline, repo, rev, payload)
+		if err != nil {
+			return cs, fmt.Errorf("failed to write fan-in record: %w", err)
 		}
-		repo := url.Query().Get("FullRepositoryId")
-		if repo == "" {
-			return "", fmt.Errorf("missing FullRepositoryId URL param")
+		payload = combined
+		l = l.With("combined-state", payload.State)
+		ctx = withLogger(ctx, l)
+	}
+
+	if requiredCheck() && payload.Stage == "" {
+		ok, err := claimCommitState(ctx, repo, rev, payload.Context, payload.State)
+		if err != nil {
+			return cs, fmt.Errorf("failed to write required check record: %w", err)
+		}
+		if !ok {
+			return skip("Required check has a final state already, or only gets final states")
+		}
+	}
+
+	claimed, claimDone, err := claimState(ctx, idempotencyKey(ev, rev, payload), payload.State)
+	if err != nil {
+		return cs, fmt.Errorf("failed to write idempotency record: %w", err)
+	}
+	if !claimed {
+		return skip("Status was already posted, or a later one was")
+	}
+	fresh, forget, err := claimPending(ctx, dedupKey(repo, rev, payload), payload.State)
+	if err != nil {
+		claimDone(false)
+		return cs, fmt.Errorf("failed to write de-duplication record: %w", err)
+	}
+	if !fresh {
+		claimDone(false)
+		return skip("Same pending status was posted recently")
+	}
+
+	l.Info("setting status")
+
+	posting := time.Now()
+	statusURL, err := prov.report(ctx, repo, rev, payload)
+	queueStatusEvent(ctx, ev, repo, rev, payload, statusURL, err)
+	if errors.Is(err, errUnknownCommit) && os.Getenv("UNKNOWN_COMMIT_BEHAVIOR") != "error" {
+		// Retrying can't help, the commit won't appear.
+		claimDone(false)
+		forget()
+		l.Warn("commit not found", "error", err)
+		return skip("Commit not found in the repository")
+	}
+	if err != nil {
+		claimDone(false)
+		forget()
+		return cs, err
+	}
+	claimDone(true)
+	l.Info("set status", "status-url", statusURL)
+	recordHistory(ctx, ev, repo, rev, payload, statusURL, time.Since(posting))
+	publishTransition(ctx, ev, repo, rev, payload)
+	metricsFrom(ctx).statusPosted(payload.State)
+	countPosted(ctx, ev.Pipeline, payload.State)
+	cs.Action = "posted"
+	cs.StatusURL = statusURL
+	return cs, nil
+}
+
+func isAccessDenied(err error) bool {
+	var aerr smithy.APIError
+	if !errors.As(err, &aerr) {
+		return false
+	}
+	switch aerr.ErrorCode() {
+	case "AccessDenied", "AccessDeniedException", "UnauthorizedOperation":
+		return true
+	}
+	return false
+}
+
+// appendDescription adds a clause to a status description.
+func appendDescription(description, clause string) string {
+	if description == "" {
+		return clause
+	}
+	return description + "; " + clause
+}
+
+// executionAuthor describes who is responsible for the execution: the user
+// who started it manually, otherwise the author of the commit.
+func executionAuthor(ctx context.Context, ex *types.PipelineExecution, repo, rev, token string) (string, error) {
+	if t := ex.Trigger; t != nil &&
+		t.TriggerType == types.TriggerTypeStartPipelineExecution {
+		// The detail is the ARN of the user or role session.
+		arn := aws.ToString(t.TriggerDetail)
+		if arn != "" {
+			return "started by " + arn[strings.LastIndex(arn, "/")+1:], nil
 		}
-		return repo, nil
+	}
+	author, err := commitAuthor(ctx, repo, rev, token)
+	if err != nil || author == "" {
+		return "", err
+	}
+	return "commit by " + author, nil
+}
+
+var errMissingSourceArtifact = errors.New("missing SourceArtifact")
+
+// findSourceArtifact returns the artifact named SourceArtifact. If there are
+// several, DUPLICATE_ARTIFACT_POLICY decides: "first" (default) or "last"
+// picks one of them, "error" fails.
+func findSourceArtifact(ctx context.Context, artis []types.ArtifactRevision) (*types.ArtifactRevision, error) {
+	var found []*types.ArtifactRevision
+	for i, a := range artis {
+		if aws.ToString(a.Name) == "SourceArtifact" {
+			found = append(found, &artis[i])
+		}
+	}
+	if len(found) == 0 {
+		return nil, errMissingSourceArtifact
+	}
+	if len(found) == 1 {
+		return found[0], nil
+	}
+	switch p := os.Getenv("DUPLICATE_ARTIFACT_POLICY"); p {
+	case "", "first":
+		logger(ctx).Warn("several artifacts named SourceArtifact, using the first",
+			"count", len(found))
+		return found[0], nil
+	case "last":
+		logger(ctx).Warn("several artifacts named SourceArtifact, using the last",
+			"count", len(found))
+		return found[len(found)-1], nil
+	case "error":
+		return nil, fmt.Errorf("%d artifacts named SourceArtifact", len(found))
 	default:
-		return "", fmt.Errorf("unknown hostname %v", url.Hostname())
+		return nil, fmt.Errorf("invalid DUPLICATE_ARTIFACT_POLICY %q", p)
+	}
+}
+
+// parseRevisionURL parses the source artifact's revision URL. Some source
+// configurations produce relative URLs; these are resolved against
+// REVISION_BASE_URL, if set.
+func parseRevisionURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(scpToSSHURL(rawURL))
+	if err != nil {
+		return nil, err
+	}
+	if u.Host != "" {
+		return u, nil
+	}
+	base := os.Getenv("REVISION_BASE_URL")
+	if base == "" {
+		return nil, fmt.Errorf("revision URL %q has no hostname: this source type is not "+
+			"supported unless REVISION_BASE_URL is set", rawURL)
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REVISION_BASE_URL: %w", err)
+	}
+	return b.ResolveReference(u), nil
+}
+
+// repoFromCommitURL handles the GitHub (version 1) source action, whose
+// revision URL points directly at the commit, e.g.
+// https://github.com/owner/repo/commit/<sha>, or sometimes at a branch, e.g.
+// https://github.com/owner/repo/tree/<branch>. Bitbucket's commit URLs have
+// the same shape. Revisions without URL are resolved from the pipeline's
+// declaration, see pipelineDeclaration.
+func repoFromCommitURL(url *url.URL) (string, error) {
+	p := strings.Split(strings.Trim(url.Path, "/"), "/")
+	if len(p) < 2 || p[0] == "" || p[1] == "" {
+		return "", fmt.Errorf("too few path components")
+	}
+	return fmt.Sprintf("%s/%s", p[0], strings.TrimSuffix(p[1], ".git")), nil
+}
+
+// repoFromGitLabURL handles GitLab commit URLs, e.g.
+// https://gitlab.com/group/subgroup/project/-/commit/<sha>. GitLab projects
+// may be nested in several groups.
+func repoFromGitLabURL(url *url.URL) (string, error) {
+	p := strings.Trim(url.Path, "/")
+	if i := strings.Index(p, "/-/"); i >= 0 {
+		p = p[:i]
+	}
+	if strings.Count(p, "/") < 1 {
+		return "", fmt.Errorf("too few path components")
+	}
+	return strings.TrimSuffix(p, ".git"), nil
+}
+
+// consoleDomains are the domains of the AWS console in the commercial, GovCloud
+// and China partitions.
+var consoleDomains = []string{
+	"console.aws.amazon.com",
+	"console.amazonaws-us-gov.com",
+	"console.amazonaws.cn",
+}
+
+// isConsoleHost reports whether host belongs to the AWS console, with or
+// without a region, e.g. us-gov-west-1.console.amazonaws-us-gov.com.
+func isConsoleHost(host string) bool {
+	for _, d := range consoleDomains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// consoleDomain returns the domain of the AWS console of the region's
+// partition.
+func consoleDomain(region string) string {
+	switch {
+	case strings.HasPrefix(region, "us-gov-"):
+		return "console.amazonaws-us-gov.com"
+	case strings.HasPrefix(region, "cn-"):
+		return "console.amazonaws.cn"
+	}
+	return "console.aws.amazon.com"
+}
+
+// repoFromConnectionURL handles the GitHub (version 2) source action, whose
+// revision URL is a redirect through the connection carrying the repository
+// in the FullRepositoryId parameter. Depending on the console version, the
+// redirect lives below /codesuite/settings/connections/,
+// /codesuite/settings/codestar-connections/ or
+// /codesuite/settings/<account>/<region>/connections/.
+func repoFromConnectionURL(url *url.URL) (string, error) {
+	p := strings.TrimSuffix(url.Path, "/")
+	if !strings.HasPrefix(p, "/codesuite/settings/") || !strings.HasSuffix(p, "/redirect") ||
+		!strings.Contains(p, "connections/") {
+		return "", fmt.Errorf("unexpected URL path: %v", url.Path)
+	}
+	repo := url.Query().Get("FullRepositoryId")
+	if repo == "" {
+		return "", fmt.Errorf("missing FullRepositoryId URL param")
 	}
+	return repo, nil
 }
@@ -0,0 +1,588 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+	"github.com/aws/smithy-go"
+)
+
+// withExecutionID returns a copy of the execution with another ID, as
+// terminal states are remembered per execution across invocations.
+func withExecutionID(ex *types.PipelineExecution, id string) *types.PipelineExecution {
+	c := *ex
+	c.PipelineExecutionId = aws.String(id)
+	return &c
+}
+
+func executionURL(id string) string {
+	return "https://eu-west-1.console.aws.amazon.com/codesuite/codepipeline/pipelines/" +
+		"hello-world/executions/" + id
+}
+
+func TestExecutionState(t *testing.T) {
+	tests := []struct {
+		status, state, description string
+	}{
+		{"InProgress", "pending", ""},
+		{"Succeeded", "success", ""},
+		{"Failed", "failure", ""},
+		{"Stopping", "", ""},
+		{"Stopped", "error", "Stopped"},
+		{"Cancelled", "error", "Cancelled"},
+		{"Superseded", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			state, description, err := executionState(tt.status)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state != tt.state || description != tt.description {
+				t.Errorf("executionState(%q) = %q, %q, want %q, %q", tt.status, state,
+					description, tt.state, tt.description)
+			}
+		})
+	}
+}
+
+func TestExecutionStateMapping(t *testing.T) {
+	t.Setenv("STATE_MAPPING", `{"Failed":"error","Stopped":"skip"}`)
+	tests := []struct {
+		status, state string
+	}{
+		{"Failed", "error"},
+		{"Stopped", ""},
+		{"Succeeded", "success"},
+	}
+	for _, tt := range tests {
+		state, _, err := executionState(tt.status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state != tt.state {
+			t.Errorf("executionState(%q) = %q, want %q", tt.status, state, tt.state)
+		}
+	}
+}
+
+// TestHandlePayloads posts the statuses of the recorded executions to the
+// fake GitHub.
+func TestHandlePayloads(t *testing.T) {
+	type status struct {
+		repo, sha string
+		body      map[string]any
+	}
+	tests := []struct {
+		fixture string
+		want    []status
+	}{
+		{"github-v1", []status{{
+			"octo-org/hello-world", "6dcb09b5b57875f334f61aebed695e2e4193db5e",
+			map[string]any{
+				"state":       "success",
+				"target_url":  executionURL("4f1c2a8e-7b5d-4d1e-9a3c-2e6f8b0d1a11"),
+				"description": "",
+				"context":     defaultContext,
+			},
+		}}},
+		{"connection", []status{{
+			"octo-org/hello-world", "7638417db6d59f3c431d3e1f261cc637155684cd",
+			map[string]any{
+				"state":       "pending",
+				"target_url":  executionURL("9b2e4c6d-1f3a-4b5c-8d7e-0a1b2c3d4e22"),
+				"description": "",
+				"context":     defaultContext,
+			},
+		}}},
+		// The S3 artifact is ignored, both Git artifacts get the status.
+		{"multi-artifact", []status{{
+			"octo-org/hello-world", "a9b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0",
+			map[string]any{
+				"state":       "failure",
+				"target_url":  executionURL("c3d4e5f6-a7b8-4c9d-8e0f-1a2b3c4d5e33"),
+				"description": "",
+				"context":     defaultContext,
+			},
+		}, {
+			"octo-org/hello-world-config", "0f1e2d3c4b5a69788796a5b4c3d2e1f0a9b8c7d6",
+			map[string]any{
+				"state":       "failure",
+				"target_url":  executionURL("c3d4e5f6-a7b8-4c9d-8e0f-1a2b3c4d5e33"),
+				"description": "",
+				"context":     defaultContext,
+			},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			ex := loadExecution(t, tt.fixture)
+			h, gh := newTestHandler(t, newFakeCodePipeline(ex))
+			err := h.Handle(context.Background(), event{Pipeline: "hello-world",
+				ExecutionID: aws.ToString(ex.PipelineExecutionId)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := len(gh.received()); n != len(tt.want) {
+				t.Errorf("GitHub received %d requests, want %d", n, len(tt.want))
+			}
+			for _, w := range tt.want {
+				got := gh.statuses(w.repo, w.sha)
+				if len(got) != 1 {
+					t.Errorf("%s@%s got %d statuses, want 1", w.repo, w.sha, len(got))
+					continue
+				}
+				if !reflect.DeepEqual(got[0], w.body) {
+					t.Errorf("%s@%s status = %v, want %v", w.repo, w.sha, got[0], w.body)
+				}
+			}
+		})
+	}
+}
+
+// TestHandleUnknownCommit makes sure a commit GitHub doesn't know is skipped
+// instead of failing the invocation.
+func TestHandleUnknownCommit(t *testing.T) {
+	ex := withExecutionID(loadExecution(t, "github-v1"), "5a6b7c8d-0000-4000-8000-unknowncommit")
+	h, gh := newTestHandler(t, newFakeCodePipeline(ex))
+	gh.mux.HandleFunc("POST /repos/octo-org/hello-world/statuses/{sha}",
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`{"message":"No commit found for SHA: ` + r.PathValue("sha") + `"}`))
+		})
+	err := h.Handle(context.Background(), event{Pipeline: "hello-world",
+		ExecutionID: aws.ToString(ex.PipelineExecutionId)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(gh.statuses("octo-org/hello-world", "6dcb09b5b57875f334f61aebed695e2e4193db5e")); n != 1 {
+		t.Errorf("got %d statuses, want 1", n)
+	}
+}
+
+func TestStoppingBehavior(t *testing.T) {
+	tests := []struct {
+		behavior           string
+		state, description string
+	}{
+		{"", "", ""},
+		{"skip", "", ""},
+		{"pending", "pending", "Cancelling"},
+	}
+	for i, tt := range tests {
+		t.Run("STOPPING_BEHAVIOR="+tt.behavior, func(t *testing.T) {
+			t.Setenv("STOPPING_BEHAVIOR", tt.behavior)
+			state, description, err := executionState("Stopping")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state != tt.state || description != tt.description {
+				t.Errorf("executionState(Stopping) = %q, %q, want %q, %q", state, description,
+					tt.state, tt.description)
+			}
+
+			ex := withExecutionID(loadExecution(t, "github-v1"),
+				fmt.Sprintf("50000000-0000-4000-8000-00000000000%d", i))
+			ex.Status = types.PipelineExecutionStatusStopping
+			h, gh := newTestHandler(t, newFakeCodePipeline(ex))
+			err = h.Handle(context.Background(), event{Pipeline: "hello-world",
+				ExecutionID: aws.ToString(ex.PipelineExecutionId)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := gh.statuses("octo-org/hello-world", "6dcb09b5b57875f334f61aebed695e2e4193db5e")
+			switch {
+			case tt.state == "" && len(got) > 0:
+				t.Errorf("stopping execution got statuses %v", got)
+			case tt.state != "" && len(got) != 1:
+				t.Errorf("stopping execution got %d statuses, want 1", len(got))
+			case tt.state != "" && (got[0]["state"] != tt.state ||
+				got[0]["description"] != tt.description):
+				t.Errorf("stopping execution got status %v", got[0])
+			}
+		})
+	}
+}
+
+func TestInterruptedBehaviorInvalid(t *testing.T) {
+	t.Setenv("STOPPING_BEHAVIOR", "success")
+	if _, _, err := executionState("Stopping"); err == nil {
+		t.Error("executionState accepted STOPPING_BEHAVIOR success")
+	}
+}
+
+// TestHandlerDependencies makes sure Handle uses the clients it's given
+// rather than the shared ones: the GitHub API only accepts its own client's
+// TLS connections, and the execution only exists in the fake CodePipeline.
+func TestHandlerDependencies(t *testing.T) {
+	const id = "d0000000-0000-4000-8000-000000000001"
+	cp := newFakeCodePipeline(withExecutionID(loadExecution(t, "github-v1"), id))
+	var posted []string
+	gh := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted = append(posted, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":1,"url":"https://` + r.Host + r.URL.Path + `"}`))
+	}))
+	defer gh.Close()
+	t.Setenv("GITHUB_API_BASE_URL", gh.URL)
+	t.Setenv("GITHUB_TOKEN", testToken)
+	var providers []string
+	h := &Handler{
+		CodePipeline: cp,
+		HTTP:         gh.Client(),
+		NewProvider: func(ctx context.Context, u *url.URL, ev event) (provider, error) {
+			providers = append(providers, u.String())
+			return newProvider(ctx, u, ev)
+		},
+	}
+
+	if err := h.Handle(context.Background(), event{Pipeline: "hello-world", ExecutionID: id}); err != nil {
+		t.Fatal(err)
+	}
+	if len(cp.calls) == 0 || cp.calls[0] != "GetPipelineExecution" {
+		t.Errorf("CodePipeline calls = %v, want GetPipelineExecution first", cp.calls)
+	}
+	want := []string{"POST /repos/octo-org/hello-world/statuses/6dcb09b5b57875f334f61aebed695e2e4193db5e"}
+	if !reflect.DeepEqual(posted, want) {
+		t.Errorf("GitHub received %v, want %v", posted, want)
+	}
+	if len(providers) != 1 {
+		t.Errorf("NewProvider was called for %v, want the source's revision URL once", providers)
+	}
+}
+
+// TestContextCollisionTargetURLTemplate makes sure statuses linking to where
+// the target URL template points count as this function's own, so that the
+// skip policy doesn't stop updating them.
+func TestContextCollisionTargetURLTemplate(t *testing.T) {
+	t.Setenv("TARGET_URL_TEMPLATE", "https://ci.example.com/{{.Pipeline}}/{{.ExecutionID}}")
+	const id = "c0000000-0000-4000-8000-000000000001"
+	const sha = "6dcb09b5b57875f334f61aebed695e2e4193db5e"
+	h, gh := newTestHandler(t, newFakeCodePipeline(withExecutionID(loadExecution(t, "github-v1"), id)))
+	gh.mux.HandleFunc("GET /repos/octo-org/hello-world/commits/"+sha+"/statuses",
+		func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode([]ghCommitStatus{{State: "pending", Context: defaultContext,
+				TargetURL: "https://ci.example.com/hello-world/" + id}})
+		})
+
+	err := h.Handle(context.Background(), event{Pipeline: "hello-world", ExecutionID: id,
+		ContextCollision: "skip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := gh.statuses("octo-org/hello-world", sha)
+	if len(got) != 1 {
+		t.Fatalf("got %d statuses, want 1", len(got))
+	}
+	if want := "https://ci.example.com/hello-world/" + id; got[0]["target_url"] != want {
+		t.Errorf("target_url = %v, want %s", got[0]["target_url"], want)
+	}
+}
+
+// TestPipelinesSharingExecutionID makes sure the state remembered for an
+// execution of one pipeline doesn't apply to the execution of another
+// pipeline that has the same ID.
+func TestPipelinesSharingExecutionID(t *testing.T) {
+	t.Setenv("IDEMPOTENCY_TABLE", "statuses")
+	useFakeDynamoDB(t)
+	const id = "f0000000-0000-4000-8000-000000000001"
+	const sha = "6dcb09b5b57875f334f61aebed695e2e4193db5e"
+	succeeded := withExecutionID(loadExecution(t, "github-v1"), id)
+	running := withExecutionID(loadExecution(t, "github-v1"), id)
+	running.Status = types.PipelineExecutionStatusInProgress
+
+	h, gh := newTestHandler(t, newFakeCodePipeline(succeeded))
+	if err := h.Handle(context.Background(), event{Pipeline: "hello-world", ExecutionID: id}); err != nil {
+		t.Fatal(err)
+	}
+	// Neither the terminal state nor the idempotency record of hello-world
+	// keeps the other pipeline's statuses from being posted.
+	h.CodePipeline = newFakeCodePipeline(running)
+	if err := h.Handle(context.Background(), event{Pipeline: "hello-world-ci", ExecutionID: id}); err != nil {
+		t.Fatal(err)
+	}
+	h.CodePipeline = newFakeCodePipeline(succeeded)
+	if err := h.Handle(context.Background(), event{Pipeline: "hello-world-ci", ExecutionID: id}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []any
+	for _, s := range gh.statuses("octo-org/hello-world", sha) {
+		got = append(got, s["state"])
+	}
+	if want := []any{"success", "pending", "success"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GitHub received states %v, want %v", got, want)
+	}
+}
+
+// TestHandleAccessDenied makes sure a missing permission to look up the
+// execution is explained.
+func TestHandleAccessDenied(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"AccessDeniedException", "the Lambda's role is not allowed to call " +
+			"codepipeline:GetPipelineExecution on pipeline hello-world, check its IAM policy: "},
+		{"AccessDenied", "the Lambda's role is not allowed to call "},
+		{"ThrottlingException", "api error ThrottlingException"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			cp := newFakeCodePipeline()
+			cp.executionErr = &smithy.GenericAPIError{Code: tt.code, Message: "denied"}
+			h, gh := newTestHandler(t, cp)
+
+			err := h.Handle(context.Background(), event{Pipeline: "hello-world",
+				ExecutionID: "ad000000-0000-4000-8000-000000000001"})
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("Handle() = %v, want %q...", err, tt.want)
+			}
+			if n := len(gh.received()); n > 0 {
+				t.Errorf("GitHub received %d requests", n)
+			}
+		})
+	}
+}
+
+// TestHandleRollback makes sure rollbacks link to their execution's
+// timeline and say what they rolled back to.
+func TestHandleRollback(t *testing.T) {
+	ex := loadExecution(t, "rollback")
+	id := aws.ToString(ex.PipelineExecutionId)
+	h, gh := newTestHandler(t, newFakeCodePipeline(ex))
+	if err := h.Handle(context.Background(), event{Pipeline: "hello-world", ExecutionID: id}); err != nil {
+		t.Fatal(err)
+	}
+	got := gh.statuses("octo-org/hello-world", "6dcb09b5b57875f334f61aebed695e2e4193db5e")
+	want := map[string]any{
+		"state":       "success",
+		"target_url":  executionURL(id) + "/timeline",
+		"description": "Automated rollback to execution 4f1c2a8e-7b5d-4d1e-9a3c-2e6f8b0d1a11",
+		"context":     defaultRollbackContext,
+	}
+	if len(got) != 1 || !reflect.DeepEqual(got[0], want) {
+		t.Errorf("statuses = %v, want %v", got, want)
+	}
+}
+
+// TestSkipDraftPRs makes sure commits are only skipped if all their open
+// pull requests are drafts.
+func TestSkipDraftPRs(t *testing.T) {
+	const sha = "6dcb09b5b57875f334f61aebed695e2e4193db5e"
+	tests := []struct {
+		name  string
+		pulls string
+		want  int
+	}{
+		{"draft", `[{"number":1,"state":"open","draft":true}]`, 0},
+		{"draft and ready", `[{"number":1,"state":"open","draft":true},` +
+			`{"number":2,"state":"open","draft":false}]`, 1},
+		{"closed draft", `[{"number":1,"state":"closed","draft":true}]`, 1},
+		{"no pull request", `[]`, 1},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := fmt.Sprintf("d2000000-0000-4000-8000-00000000000%d", i)
+			h, gh := newTestHandler(t, newFakeCodePipeline(withExecutionID(loadExecution(t, "github-v1"), id)))
+			gh.mux.HandleFunc("GET /repos/octo-org/hello-world/commits/"+sha+"/pulls",
+				func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(tt.pulls)) })
+
+			err := h.Handle(context.Background(), event{Pipeline: "hello-world", ExecutionID: id,
+				SkipDraftPRs: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := len(gh.statuses("octo-org/hello-world", sha)); n != tt.want {
+				t.Errorf("got %d statuses, want %d", n, tt.want)
+			}
+		})
+	}
+}
+
+// TestIncludeAuthor makes sure the description names who the execution is
+// due to.
+func TestIncludeAuthor(t *testing.T) {
+	const sha = "6dcb09b5b57875f334f61aebed695e2e4193db5e"
+	tests := []struct {
+		name    string
+		commit  string
+		trigger *types.ExecutionTrigger
+		want    string
+	}{
+		{"GitHub user", `{"sha":"` + sha + `","author":{"login":"octocat","type":"User"},` +
+			`"commit":{"author":{"name":"Mona Lisa"}}}`, nil, "commit by @octocat"},
+		{"Git author", `{"sha":"` + sha + `","author":null,` +
+			`"commit":{"author":{"name":"Mona Lisa"}}}`, nil, "commit by Mona Lisa"},
+		{"started manually", "", &types.ExecutionTrigger{
+			TriggerType:   types.TriggerTypeStartPipelineExecution,
+			TriggerDetail: aws.String("arn:aws:sts::123456789012:assumed-role/Admin/octocat"),
+		}, "started by octocat"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := withExecutionID(loadExecution(t, "github-v1"),
+				fmt.Sprintf("a2000000-0000-4000-8000-00000000000%d", i))
+			if tt.trigger != nil {
+				ex.Trigger = tt.trigger
+			}
+			h, gh := newTestHandler(t, newFakeCodePipeline(ex))
+			gh.mux.HandleFunc("GET /repos/octo-org/hello-world/commits/"+sha,
+				func(w http.ResponseWriter, r *http.Request) {
+					if tt.commit == "" {
+						t.Error("commit looked up although the execution was started manually")
+					}
+					w.Write([]byte(tt.commit))
+				})
+
+			err := h.Handle(context.Background(), event{Pipeline: "hello-world",
+				ExecutionID: aws.ToString(ex.PipelineExecutionId), IncludeAuthor: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := gh.statuses("octo-org/hello-world", sha)
+			if len(got) != 1 || got[0]["description"] != tt.want {
+				t.Errorf("statuses = %v, want description %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestHandleCommits posts the status to each commit listed in the event
+// instead of the one of the source artifact.
+func TestHandleCommits(t *testing.T) {
+	commits := []string{
+		"1111111111111111111111111111111111111111",
+		"2222222222222222222222222222222222222222",
+	}
+	ex := withExecutionID(loadExecution(t, "github-v1"), "a3000000-0000-4000-8000-000000000001")
+	h, gh := newTestHandler(t, newFakeCodePipeline(ex))
+	err := h.Handle(context.Background(), event{Pipeline: "hello-world",
+		ExecutionID: aws.ToString(ex.PipelineExecutionId), Commits: commits})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range commits {
+		got := gh.statuses("octo-org/hello-world", c)
+		if len(got) != 1 || got[0]["state"] != "success" {
+			t.Errorf("%s statuses = %v, want one success", c, got)
+		}
+	}
+	if got := gh.statuses("octo-org/hello-world", "6dcb09b5b57875f334f61aebed695e2e4193db5e"); len(got) != 0 {
+		t.Errorf("source artifact commit got %d statuses, want 0", len(got))
+	}
+}
+
+// TestHandleCommitsMultiArtifact makes sure each commit of a multi-source
+// execution gets a status, and that listed commits, which couldn't be told
+// apart by repository, are refused there.
+func TestHandleCommitsMultiArtifact(t *testing.T) {
+	shas := map[string]string{
+		"octo-org/hello-world":        "a9b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0",
+		"octo-org/hello-world-config": "0f1e2d3c4b5a69788796a5b4c3d2e1f0a9b8c7d6",
+	}
+
+	ex := withExecutionID(loadExecution(t, "multi-artifact"), "a3000000-0000-4000-8000-000000000002")
+	h, gh := newTestHandler(t, newFakeCodePipeline(ex))
+	err := h.Handle(context.Background(), event{Pipeline: "hello-world",
+		ExecutionID: aws.ToString(ex.PipelineExecutionId)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for repo, sha := range shas {
+		if got := gh.statuses(repo, sha); len(got) != 1 {
+			t.Errorf("%s@%s got %d statuses, want 1", repo, sha, len(got))
+		}
+	}
+
+	ex = withExecutionID(ex, "a3000000-0000-4000-8000-000000000003")
+	h, gh = newTestHandler(t, newFakeCodePipeline(ex))
+	err = h.Handle(context.Background(), event{Pipeline: "hello-world",
+		ExecutionID: aws.ToString(ex.PipelineExecutionId),
+		Commits:     []string{"1111111111111111111111111111111111111111"}})
+	if err == nil || !strings.Contains(err.Error(), "several source artifacts") {
+		t.Errorf("err = %v, want commits refused for several source artifacts", err)
+	}
+	if n := len(gh.received()); n != 0 {
+		t.Errorf("GitHub received %d requests, want 0", n)
+	}
+}
+
+// TestContextCollision makes sure a status another system posted under the
+// same context is warned about and, if asked to, left alone, while the
+// function's own statuses are updated.
+func TestContextCollision(t *testing.T) {
+	const sha = "6dcb09b5b57875f334f61aebed695e2e4193db5e"
+	foreign := "https://ci.example.com/builds/1"
+	tests := []struct {
+		name, policy, existing string
+		posted, warned         bool
+	}{
+		{"warn", "warn", foreign, true, true},
+		{"skip", "skip", foreign, false, true},
+		{"skip own status", "skip", executionURL("earlier"), true, false},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			prev := slog.Default()
+			slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+			t.Cleanup(func() { slog.SetDefault(prev) })
+
+			id := fmt.Sprintf("c1000000-0000-4000-8000-00000000000%d", i)
+			h, gh := newTestHandler(t, newFakeCodePipeline(withExecutionID(loadExecution(t, "github-v1"), id)))
+			gh.mux.HandleFunc("GET /repos/octo-org/hello-world/commits/"+sha+"/statuses",
+				func(w http.ResponseWriter, r *http.Request) {
+					json.NewEncoder(w).Encode([]ghCommitStatus{{State: "success",
+						Context: defaultContext, TargetURL: tt.existing}})
+				})
+
+			err := h.Handle(context.Background(), event{Pipeline: "hello-world", ExecutionID: id,
+				ContextCollision: tt.policy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if posted := len(gh.statuses("octo-org/hello-world", sha)) == 1; posted != tt.posted {
+				t.Errorf("posted = %t, want %t", posted, tt.posted)
+			}
+			warned := strings.Contains(logs.String(), "context is also used by another system")
+			if warned != tt.warned {
+				t.Errorf("warned = %t, want %t, logs:\n%s", warned, tt.warned, logs.String())
+			}
+		})
+	}
+}
+
+// TestLateInProgress makes sure an InProgress event delivered after the
+// execution's terminal state was reported doesn't turn the status back to
+// pending.
+func TestLateInProgress(t *testing.T) {
+	const id = "d1000000-0000-4000-8000-000000000001"
+	const sha = "6dcb09b5b57875f334f61aebed695e2e4193db5e"
+	h, gh := newTestHandler(t, newFakeCodePipeline(withExecutionID(loadExecution(t, "github-v1"), id)))
+	for _, state := range []string{"Succeeded", "InProgress"} {
+		err := h.Handle(context.Background(), event{Pipeline: "hello-world", ExecutionID: id,
+			State: state})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []any
+	for _, s := range gh.statuses("octo-org/hello-world", sha) {
+		got = append(got, s["state"])
+	}
+	if want := []any{"success"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GitHub received states %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Statuses are kept for a year by default.
+const defaultHistoryRetention = 365 * 24 * time.Hour
+
+// historyKey identifies the commit in the history table.
+func historyKey(repo, rev string) string {
+	return repo + "@" + rev
+}
+
+// recordHistory writes the posted status to the DynamoDB table named by
+// HISTORY_TABLE, if set, as an audit trail of the states asserted on each
+// commit. The table's partition key is the commit ("commit", see historyKey)
+// and its sort key the time of posting ("posted-at"). Records expire after
+// HISTORY_RETENTION. Failing to write is logged only.
+func recordHistory(ctx context.Context, ev event, repo, rev string, payload ghReqPayload,
+	statusURL string, latency time.Duration) {
+	table := os.Getenv("HISTORY_TABLE")
+	if table == "" {
+		return
+	}
+	retention, err := durationEnv("HISTORY_RETENTION", defaultHistoryRetention)
+	if err != nil {
+		logger(ctx).Warn("failed to write status history", "error", err)
+		return
+	}
+	now := time.Now().UTC()
+	s := func(v string) types.AttributeValue { return &types.AttributeValueMemberS{Value: v} }
+	n := func(v int64) types.AttributeValue {
+		return &types.AttributeValueMemberN{Value: strconv.FormatInt(v, 10)}
+	}
+	item := map[string]types.AttributeValue{
+		"commit":       s(historyKey(repo, rev)),
+		"posted-at":    s(now.Format(time.RFC3339Nano)),
+		"pipeline":     s(ev.Pipeline),
+		"execution-id": s(ev.ExecutionID),
+		"repository":   s(repo),
+		"sha":          s(rev),
+		"context":      s(payload.Context),
+		"state":        s(payload.State),
+		"description":  s(payload.Description),
+		"target-url":   s(payload.TargetURL),
+		"latency-ms":   n(latency.Milliseconds()),
+		"ttl":          n(now.Add(retention).Unix()),
+	}
+	if statusURL != "" {
+		item["status-url"] = s(statusURL)
+		// Status URLs end with the status's ID.
+		if id, err := strconv.ParseInt(path.Base(statusURL), 10, 64); err == nil {
+			item["status-id"] = n(id)
+		}
+	}
+	_, err = dynamodbClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item:      item,
+	})
+	if err != nil {
+		logger(ctx).Warn("failed to write status history", "table", table, "error", err)
+	}
+}
+
+// printHistory writes the statuses posted to the commit, given as
+// owner/repo@sha, oldest first, as JSON lines.
+func printHistory(ctx context.Context, w io.Writer, commit string) error {
+	table := os.Getenv("HISTORY_TABLE")
+	if table == "" {
+		return errors.New("HISTORY_TABLE must be set")
+	}
+	if !strings.Contains(commit, "@") {
+		return fmt.Errorf("invalid commit %q, expected owner/repo@sha", commit)
+	}
+	pages := dynamodb.NewQueryPaginator(dynamodbClient, &dynamodb.QueryInput{
+		TableName:                aws.String(table),
+		KeyConditionExpression:   aws.String("#commit = :commit"),
+		ExpressionAttributeNames: map[string]string{"#commit": "commit"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":commit": &types.AttributeValueMemberS{Value: commit},
+		},
+	})
+	enc := json.NewEncoder(w)
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to query status history: %w", err)
+		}
+		for _, item := range page.Items {
+			record := map[string]interface{}{}
+			for k, v := range item {
+				switch v := v.(type) {
+				case *types.AttributeValueMemberS:
+					record[k] = v.Value
+				case *types.AttributeValueMemberN:
+					record[k] = json.Number(v.Value)
+				}
+			}
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Records expire after a day; Lambda stops retrying asynchronous invocations
+// long before that.
+const idempotencyTTL = 24 * time.Hour
+
+// idempotencyKey identifies the status of a context on a commit within an
+// execution. It includes the pipeline name since execution IDs are only
+// unique per pipeline.
+func idempotencyKey(ev event, rev string, payload ghReqPayload) string {
+	return strings.Join([]string{ev.Pipeline, ev.ExecutionID, rev, payload.Context}, "/")
+}
+
+// stateRank orders states, so that a late pending status never replaces a
+// final one.
+func stateRank(state string) int {
+	if state == "pending" {
+		return 1
+	}
+	return 2
+}
+
+// Claims of states being posted expire after this long, so that an
+// invocation that dies while posting, e.g. when it times out, doesn't keep
+// its retries from posting. Lambda functions run for 15 minutes at most.
+const claimTTL = 15 * time.Minute
+
+// claimState claims the state about to be posted in the table named by
+// IDEMPOTENCY_TABLE. The conditional write fails, and claimState returns
+// false, if the same state was already posted or is being posted, or a later
+// one has been, e.g. when an event is retried or delivered out of order.
+// The claim only lasts claimTTL until the returned function is called: with
+// true once the state was posted, which records it for idempotencyTTL, or
+// with false if posting failed, which restores the previous record so that
+// a retry isn't skipped. Without a table, every state is claimed.
+func claimState(ctx context.Context, key, state string) (bool, func(posted bool), error) {
+	table := os.Getenv("IDEMPOTENCY_TABLE")
+	if table == "" {
+		return true, func(bool) {}, nil
+	}
+	now := time.Now()
+	rank := strconv.Itoa(stateRank(state))
+	claimedUntil := strconv.FormatInt(now.Add(claimTTL).Unix(), 10)
+	res, err := dynamodbClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item: map[string]types.AttributeValue{
+			"id":            &types.AttributeValueMemberS{Value: key},
+			"state":         &types.AttributeValueMemberS{Value: state},
+			"rank":          &types.AttributeValueMemberN{Value: rank},
+			"claimed-until": &types.AttributeValueMemberN{Value: claimedUntil},
+			"ttl":           &types.AttributeValueMemberN{Value: claimedUntil},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(id) OR #claimed < :now OR " +
+			"#rank < :rank OR (#rank = :rank AND #state <> :state)"),
+		ExpressionAttributeNames: map[string]string{
+			"#rank": "rank", "#state": "state", "#claimed": "claimed-until"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":rank":  &types.AttributeValueMemberN{Value: rank},
+			":state": &types.AttributeValueMemberS{Value: state},
+			":now":   &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+		},
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	var cerr *types.ConditionalCheckFailedException
+	if errors.As(err, &cerr) {
+		return false, nil, nil
+	}
+	if err != nil {
+		return false, nil, err
+	}
+
+	done := func(posted bool) {
+		// Only touch the claim if no other invocation has replaced it since.
+		cond := aws.String("#state = :state")
+		names := map[string]string{"#state": "state"}
+		values := map[string]types.AttributeValue{
+			":state": &types.AttributeValueMemberS{Value: state},
+		}
+		var err error
+		switch {
+		case posted:
+			postedAt := time.Now()
+			names["#claimed"], names["#posted"], names["#ttl"] = "claimed-until", "posted-at", "ttl"
+			values[":posted"] = &types.AttributeValueMemberS{Value: postedAt.UTC().Format(time.RFC3339)}
+			values[":ttl"] = &types.AttributeValueMemberN{
+				Value: strconv.FormatInt(postedAt.Add(idempotencyTTL).Unix(), 10)}
+			_, err = dynamodbClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+				TableName:                 aws.String(table),
+				Key:                       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: key}},
+				UpdateExpression:          aws.String("SET #posted = :posted, #ttl = :ttl REMOVE #claimed"),
+				ConditionExpression:       cond,
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+			})
+		case len(res.Attributes) == 0:
+			_, err = dynamodbClient.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+				TableName:                 aws.String(table),
+				Key:                       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: key}},
+				ConditionExpression:       cond,
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+			})
+		default:
+			_, err = dynamodbClient.PutItem(ctx, &dynamodb.PutItemInput{
+				TableName:                 aws.String(table),
+				Item:                      res.Attributes,
+				ConditionExpression:       cond,
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+			})
+		}
+		if err != nil && !errors.As(err, &cerr) {
+			logger(ctx).Warn("failed to update idempotency record", "posted", posted, "error", err)
+		}
+	}
+	return true, done, nil
+}
+
+// Commit records of required checks are kept longer, as reruns of old
+// commits happen days later.
+const requiredCheckTTL = 90 * 24 * time.Hour
+
+// requiredCheck reports whether REQUIRED_CHECK is true: the overall status
+// is used as a required check in branch protection and must not go back
+// from a final state to pending, e.g. when an execution is retried or the
+// commit is built again.
+func requiredCheck() bool {
+	return os.Getenv("REQUIRED_CHECK") == "true"
+}
+
+// claimCommitState decides whether the state may be posted to the context
+// of the commit under REQUIRED_CHECK. Final states always may and are
+// recorded in IDEMPOTENCY_TABLE, across executions; pending may only as long
+// as no final state was recorded. Without a table, pending never is.
+func claimCommitState(ctx context.Context, repo, rev, statusContext, state string) (bool, error) {
+	table := os.Getenv("IDEMPOTENCY_TABLE")
+	if table == "" {
+		return state != "pending", nil
+	}
+	now := time.Now()
+	rank := strconv.Itoa(stateRank(state))
+	_, err := dynamodbClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item: map[string]types.AttributeValue{
+			"id":        &types.AttributeValueMemberS{Value: "commit/" + historyKey(repo, rev) + "/" + statusContext},
+			"state":     &types.AttributeValueMemberS{Value: state},
+			"rank":      &types.AttributeValueMemberN{Value: rank},
+			"posted-at": &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)},
+			"ttl": &types.AttributeValueMemberN{
+				Value: strconv.FormatInt(now.Add(requiredCheckTTL).Unix(), 10)},
+		},
+		ConditionExpression:       aws.String("attribute_not_exists(id) OR #rank <= :rank"),
+		ExpressionAttributeNames:  map[string]string{"#rank": "rank"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":rank": &types.AttributeValueMemberN{Value: rank}},
+	})
+	var cerr *types.ConditionalCheckFailedException
+	if errors.As(err, &cerr) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// TestIdempotencyRetries handles the same event again after a failed and
+// after a successful post: only the latter keeps it from being posted again.
+func TestIdempotencyRetries(t *testing.T) {
+	t.Setenv("IDEMPOTENCY_TABLE", "statuses")
+	t.Setenv("GITHUB_MAX_ATTEMPTS", "1")
+	const sha = "6dcb09b5b57875f334f61aebed695e2e4193db5e"
+	ev := event{Pipeline: "hello-world", ExecutionID: "1d000000-0000-4000-8000-000000000001"}
+	ddb := useFakeDynamoDB(t)
+	h, gh := newTestHandler(t, newFakeCodePipeline(
+		withExecutionID(loadExecution(t, "github-v1"), ev.ExecutionID)))
+	fail := true
+	gh.mux.HandleFunc("POST /repos/octo-org/hello-world/statuses/{sha}",
+		func(w http.ResponseWriter, r *http.Request) {
+			if fail {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":1,"url":"` + gh.URL + r.URL.Path + `"}`))
+		})
+	key := idempotencyKey(ev, sha, ghReqPayload{Context: defaultContext})
+
+	if err := h.Handle(context.Background(), ev); err == nil {
+		t.Fatal("Handle succeeded although GitHub failed")
+	}
+	if item := ddb.item(key); item != nil {
+		t.Errorf("failed post left record %v", item)
+	}
+
+	fail = false
+	if err := h.Handle(context.Background(), ev); err != nil {
+		t.Fatal(err)
+	}
+	item := ddb.item(key)
+	if _, ok := item["posted-at"]; !ok {
+		t.Errorf("successful post recorded as %v", item)
+	}
+	if _, ok := item["claimed-until"]; ok {
+		t.Errorf("successful post left its claim %v", item)
+	}
+
+	// The redelivered event is skipped.
+	if err := h.Handle(context.Background(), ev); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(gh.statuses("octo-org/hello-world", sha)); n != 2 {
+		t.Errorf("GitHub received %d statuses, want the failed and the successful one", n)
+	}
+}
+
+// TestIdempotencyInterruptedPost makes sure the claim of an invocation that
+// died while posting only holds up retries until it expires.
+func TestIdempotencyInterruptedPost(t *testing.T) {
+	t.Setenv("IDEMPOTENCY_TABLE", "statuses")
+	const sha = "6dcb09b5b57875f334f61aebed695e2e4193db5e"
+	tests := []struct {
+		name         string
+		id           string
+		claimedUntil time.Duration
+		want         int
+	}{
+		{"claim expired", "1d000000-0000-4000-8000-000000000002", -time.Minute, 1},
+		{"being posted", "1d000000-0000-4000-8000-000000000003", time.Minute, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := event{Pipeline: "hello-world", ExecutionID: tt.id}
+			ddb := useFakeDynamoDB(t)
+			key := idempotencyKey(ev, sha, ghReqPayload{Context: defaultContext})
+			until := strconv.FormatInt(time.Now().Add(tt.claimedUntil).Unix(), 10)
+			ddb.items[key] = map[string]ddbtypes.AttributeValue{
+				"id":            &ddbtypes.AttributeValueMemberS{Value: key},
+				"state":         &ddbtypes.AttributeValueMemberS{Value: "success"},
+				"rank":          &ddbtypes.AttributeValueMemberN{Value: "2"},
+				"claimed-until": &ddbtypes.AttributeValueMemberN{Value: until},
+			}
+			h, gh := newTestHandler(t, newFakeCodePipeline(
+				withExecutionID(loadExecution(t, "github-v1"), tt.id)))
+
+			if err := h.Handle(context.Background(), ev); err != nil {
+				t.Fatal(err)
+			}
+			if n := len(gh.statuses("octo-org/hello-world", sha)); n != tt.want {
+				t.Errorf("GitHub received %d statuses, want %d", n, tt.want)
+			}
+		})
+	}
+}
+
+// TestIdempotencyOutOfOrder makes sure a late pending status doesn't replace
+// the final one another container posted.
+func TestIdempotencyOutOfOrder(t *testing.T) {
+	t.Setenv("IDEMPOTENCY_TABLE", "statuses")
+	const sha = "6dcb09b5b57875f334f61aebed695e2e4193db5e"
+	ev := event{Pipeline: "hello-world", ExecutionID: "1d000000-0000-4000-8000-000000000004"}
+	useFakeDynamoDB(t)
+	h, gh := newTestHandler(t, newFakeCodePipeline(
+		withExecutionID(loadExecution(t, "github-v1"), ev.ExecutionID)))
+
+	if err := h.Handle(context.Background(), ev); err != nil {
+		t.Fatal(err)
+	}
+	// The other container doesn't know the execution was reported already.
+	reported.Lock()
+	delete(reported.m, executionKey(ev.Pipeline, ev.ExecutionID))
+	reported.Unlock()
+	late := ev
+	late.State = "InProgress"
+	if err := h.Handle(context.Background(), late); err != nil {
+		t.Fatal(err)
+	}
+
+	got := gh.statuses("octo-org/hello-world", sha)
+	if len(got) != 1 || got[0]["state"] != "success" {
+		t.Errorf("GitHub received %v, want only the success", got)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// jiraKey finds issue keys like PROJ-123 in commit messages.
+var jiraKey = regexp.MustCompile(`\b[A-Z][A-Z0-9]+-[1-9][0-9]*\b`)
+
+// jiraClient transitions the Jira issues named in deployed commits.
+type jiraClient struct {
+	baseURL string
+	// auth is the Authorization header: basic auth with e-mail and API
+	// token.
+	auth string
+	// transition is the name of the transition, or of its target status,
+	// e.g. "Deployed".
+	transition string
+	// environments are the deploy environments whose deployment transitions
+	// issues, or nil for all.
+	environments map[string]bool
+}
+
+// configuredJira returns the Jira client configured by JIRA_BASE_URL,
+// JIRA_EMAIL, JIRA_API_TOKEN or JIRA_API_TOKEN_SECRET_ARN, JIRA_TRANSITION and
+// JIRA_ENVIRONMENTS, or nil if JIRA_BASE_URL isn't set.
+func configuredJira(ctx context.Context) (*jiraClient, error) {
+	base := strings.TrimSuffix(os.Getenv("JIRA_BASE_URL"), "/")
+	if base == "" {
+		return nil, nil
+	}
+	if u, err := url.Parse(base); err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid JIRA_BASE_URL %q, expected an absolute URL", base)
+	}
+	token := os.Getenv("JIRA_API_TOKEN")
+	if arn := os.Getenv("JIRA_API_TOKEN_SECRET_ARN"); arn != "" {
+		var err error
+		token, err = secretString(ctx, arn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Jira API token from secret %s: %w", arn, err)
+		}
+	}
+	email := os.Getenv("JIRA_EMAIL")
+	transition := os.Getenv("JIRA_TRANSITION")
+	if email == "" || token == "" || transition == "" {
+		return nil, fmt.Errorf("JIRA_BASE_URL requires JIRA_EMAIL, JIRA_API_TOKEN or " +
+			"JIRA_API_TOKEN_SECRET_ARN, and JIRA_TRANSITION")
+	}
+	addSecret(token)
+	j := &jiraClient{
+		baseURL:    base,
+		auth:       "Basic " + base64.StdEncoding.EncodeToString([]byte(email+":"+token)),
+		transition: transition,
+	}
+	if v := os.Getenv("JIRA_ENVIRONMENTS"); v != "" {
+		j.environments = map[string]bool{}
+		for _, env := range strings.Split(v, ",") {
+			j.environments[strings.TrimSpace(env)] = true
+		}
+	}
+	return j, nil
+}
+
+// deployed reports whether the execution deployed to one of the client's
+// environments: a stage mapped to one by envs succeeded or, without envs,
+// the execution succeeded.
+func (j *jiraClient) deployed(envs map[string]string, ghStatus string, stages []*stageResult) bool {
+	if envs == nil {
+		return ghStatus == "success"
+	}
+	for _, s := range stages {
+		env, ok := envs[s.Name]
+		if ok && deploymentState(s.Status) == "success" && (j.environments == nil || j.environments[env]) {
+			return true
+		}
+	}
+	return false
+}
+
+// issueKeys returns the distinct Jira issue keys in the commit message.
+func issueKeys(message string) []string {
+	var keys []string
+	seen := map[string]bool{}
+	for _, k := range jiraKey.FindAllString(message, -1) {
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+type jiraTransition struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	To   struct {
+		Name string `json:"name"`
+	} `json:"to"`
+}
+
+// transitionIssue applies the client's transition to the issue. Issues that
+// don't offer it, e.g. because they're already in its target status, are left
+// alone.
+func (j *jiraClient) transitionIssue(ctx context.Context, key string) error {
+	transitionsURL := fmt.Sprintf("%s/rest/api/3/issue/%s/transitions", j.baseURL, url.PathEscape(key))
+	res, resBody, err := doRequest(ctx, "GET", transitionsURL, j.auth, nil)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != 200 {
+		return newResponseError("Jira", res, resBody)
+	}
+	var available struct {
+		Transitions []jiraTransition `json:"transitions"`
+	}
+	if err := json.Unmarshal(resBody, &available); err != nil {
+		return fmt.Errorf("invalid transitions of %s: %w", key, err)
+	}
+	var id string
+	for _, t := range available.Transitions {
+		if strings.EqualFold(t.Name, j.transition) || strings.EqualFold(t.To.Name, j.transition) {
+			id = t.ID
+			break
+		}
+	}
+	if id == "" {
+		logger(ctx).Info("issue doesn't offer transition", "issue", key, "transition", j.transition)
+		return nil
+	}
+
+	b, err := json.Marshal(map[string]interface{}{"transition": map[string]string{"id": id}})
+	if err != nil {
+		return err
+	}
+	res, resBody, err = doRequest(ctx, "POST", transitionsURL, j.auth, b)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != 204 {
+		return newResponseError("Jira", res, resBody)
+	}
+	logger(ctx).Info("transitioned issue", "issue", key, "transition", j.transition)
+	return nil
+}
+
+// transitionCommitIssues transitions the issues named in the message of the
+// commit, which is looked up on GitHub.
+func (j *jiraClient) transitionCommitIssues(ctx context.Context, repo, rev, token string) error {
+	c, err := getCommit(ctx, repo, rev, token)
+	if err != nil {
+		return fmt.Errorf("failed to look up commit: %w", err)
+	}
+	for _, key := range issueKeys(c.Commit.Message) {
+		if err := j.transitionIssue(ctx, key); err != nil {
+			return fmt.Errorf("failed to transition %s: %w", key, err)
+		}
+	}
+	return nil
+}
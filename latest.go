@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline"
+)
+
+// latestSources returns the sources of which the event's execution is the
+// pipeline's most recent execution, leaving out those a newer execution
+// builds too. That keeps a late event of an older execution, e.g. one that
+// was superseded, from replacing the newer execution's status.
+func latestSources(ctx context.Context, cp codepipeline.ListPipelineExecutionsAPIClient, ev event,
+	sources []source) ([]source, error) {
+	newer := map[string]string{}
+	pages := codepipeline.NewListPipelineExecutionsPaginator(cp,
+		&codepipeline.ListPipelineExecutionsInput{PipelineName: aws.String(ev.Pipeline)})
+	found := false
+	// The execution is recent, no need to go through the whole history.
+	for n := 0; !found && n < 5 && pages.HasMorePages(); n++ {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list executions: %w", err)
+		}
+		// Executions are listed newest first.
+		for _, e := range page.PipelineExecutionSummaries {
+			id := aws.ToString(e.PipelineExecutionId)
+			if id == ev.ExecutionID {
+				found = true
+				break
+			}
+			for _, r := range e.SourceRevisions {
+				if _, ok := newer[aws.ToString(r.RevisionId)]; !ok {
+					newer[aws.ToString(r.RevisionId)] = id
+				}
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("execution %s not found among recent executions", ev.ExecutionID)
+	}
+	var latest []source
+	for _, src := range sources {
+		if id := newer[src.rev]; id != "" {
+			logger(ctx).Info("newer execution builds the same commit", "repository", src.repo,
+				"commit", src.rev, "newer-execution-id", id)
+			continue
+		}
+		latest = append(latest, src)
+	}
+	return latest, nil
+}
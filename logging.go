@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// Logs are JSON lines, so that CloudWatch Logs Insights discovers their
+// fields without parsing messages. Secrets are masked, see redact.
+func init() {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr,
+		&slog.HandlerOptions{ReplaceAttr: redactAttr})))
+}
+
+type loggerKey struct{}
+
+// withLogger returns a context carrying the logger.
+func withLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// logger returns the logger of the invocation, which adds its request ID,
+// pipeline and execution ID to each line.
+func logger(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// invocationLogger returns the logger for an invocation handling ev.
+func invocationLogger(ctx context.Context, ev event) *slog.Logger {
+	l := slog.Default().With("pipeline", ev.Pipeline, "execution-id", ev.ExecutionID)
+	if ev.schema != "" {
+		l = l.With("event-schema", ev.schema)
+	}
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		l = l.With("request-id", lc.AwsRequestID)
+	}
+	return l
+}
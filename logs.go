@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+)
+
+// failedActionLogsURL links to the logs of the first failed action of the
+// execution, see actionLogsURL.
+func failedActionLogsURL(actions []types.ActionExecutionDetail) string {
+	for _, s := range stageResults(actions) {
+		if s.Failed != nil {
+			return actionLogsURL(s.Failed)
+		}
+	}
+	return ""
+}
+
+// actionLogsURL links to the logs of the action: the CodeBuild build page for
+// CodeBuild actions, otherwise its CloudWatch logs. It returns an empty
+// string if there's nothing to link to.
+func actionLogsURL(a *types.ActionExecutionDetail) string {
+	if isCodeBuild(a) && a.Output != nil && a.Output.ExecutionResult != nil {
+		if u := aws.ToString(a.Output.ExecutionResult.ExternalExecutionUrl); u != "" {
+			return u
+		}
+	}
+	return cloudwatchLogsURL(a)
+}
+
+func isCodeBuild(a *types.ActionExecutionDetail) bool {
+	return a.Input != nil && a.Input.ActionTypeId != nil &&
+		aws.ToString(a.Input.ActionTypeId.Provider) == "CodeBuild"
+}
+
+// cloudwatchLogsURL links to the CloudWatch log stream of the action, if the
+// action reports it or is a CodeBuild action logging to the default log
+// group of its project. It returns an empty string otherwise.
+func cloudwatchLogsURL(a *types.ActionExecutionDetail) string {
+	if a.Output == nil || a.Output.ExecutionResult == nil {
+		return ""
+	}
+	r := a.Output.ExecutionResult
+	region := awsConfig.Region
+	if a.Input != nil && a.Input.Region != nil {
+		region = aws.ToString(a.Input.Region)
+	}
+	var group, stream string
+	// arn:aws:logs:<region>:<account>:log-group:<group>:log-stream:<stream>
+	if p := strings.SplitN(aws.ToString(r.LogStreamARN), ":", 9); len(p) == 9 &&
+		p[5] == "log-group" && p[7] == "log-stream" {
+		region, group, stream = p[3], p[6], p[8]
+	} else if isCodeBuild(a) {
+		// Build IDs look like <project>:<build UUID>; the UUID names the
+		// stream.
+		id := aws.ToString(r.ExternalExecutionId)
+		i := strings.LastIndex(id, ":")
+		if i < 0 {
+			return ""
+		}
+		group, stream = "/aws/codebuild/"+id[:i], id[i+1:]
+	} else {
+		return ""
+	}
+	// The console expects the path escaped twice, with $ instead of %.
+	esc := func(s string) string {
+		return strings.Replace(url.QueryEscape(url.QueryEscape(s)), "%", "$", -1)
+	}
+	return fmt.Sprintf("https://%s.%s/cloudwatch/home?region=%s"+
+		"#logsV2:log-groups/log-group/%s/log-events/%s",
+		region, consoleDomain(region), region, esc(group), esc(stream))
+}
@@ -1,7 +1,74 @@
 package main
 
-import "github.com/aws/aws-lambda-go/lambda"
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
 
 func main() {
-	lambda.Start(HandleLambdaEvent)
+	local := flag.Bool("local", false,
+		"set the status of a single execution and exit instead of running as a Lambda function")
+	pipeline := flag.String("pipeline", "", "name of the pipeline, defaults to PIPELINE_NAME (with -local)")
+	executionID := flag.String("execution-id", "", "ID of the pipeline execution (with -local)")
+	replay := flag.String("replay", "",
+		"ID of a failure record in FAILURE_TABLE whose event to handle again (with -local)")
+	history := flag.String("history", "",
+		"print the statuses recorded in HISTORY_TABLE for the commit, given as owner/repo@sha "+
+			"(with -local)")
+	backfillWithin := flag.Duration("backfill", 0,
+		"repair the statuses of the executions of -pipeline started within this duration, "+
+			"e.g. 24h (with -local)")
+	flag.Parse()
+
+	// A misconfigured function fails its cold start rather than every
+	// invocation.
+	ctx := context.Background()
+	if err := validateConfig(ctx); err != nil {
+		log.Fatalf("invalid configuration: %v\n", err)
+	}
+	if err := configureCABundle(ctx); err != nil {
+		log.Fatalf("%v\n", err)
+	}
+	if !*local {
+		if prefetchEnabled() {
+			prefetch(ctx)
+		}
+		lambda.Start(HandleInvocation)
+		return
+	}
+	// Credentials and configuration come from the environment, as they do in
+	// Lambda, e.g. AWS_PROFILE, AWS_REGION and GITHUB_TOKEN.
+	// Like events, -pipeline defaults to PIPELINE_NAME.
+	*pipeline = defaultPipeline(*pipeline)
+	if *history != "" {
+		if err := printHistory(ctx, os.Stdout, *history); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *backfillWithin > 0 {
+		if err := backfill(ctx, codepipelineClient, *pipeline, *backfillWithin); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	ev := event{Pipeline: *pipeline, ExecutionID: *executionID}
+	if *replay != "" {
+		var err error
+		if ev, err = failedEvent(ctx, *replay); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if err := HandleLambdaEvent(ctx, ev); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
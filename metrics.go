@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go"
+)
+
+// metrics collects what an invocation did, to be written in CloudWatch
+// embedded metric format if METRICS_NAMESPACE is set.
+type metrics struct {
+	sync.Mutex
+	// posted counts posted statuses by state.
+	posted map[string]int
+	// latencies are those of requests to GitHub or another provider, in
+	// milliseconds.
+	latencies []float64
+	// errors counts errors by category, see errorCategory.
+	errors map[string]int
+	// duration is how long the execution ran, if it finished and was looked
+	// up.
+	duration time.Duration
+}
+
+func newMetrics() *metrics {
+	return &metrics{posted: map[string]int{}, errors: map[string]int{}}
+}
+
+type metricsKey struct{}
+
+func withMetrics(ctx context.Context, m *metrics) context.Context {
+	return context.WithValue(ctx, metricsKey{}, m)
+}
+
+// metricsFrom returns the invocation's metrics. Outside of an invocation it
+// returns metrics that are never written.
+func metricsFrom(ctx context.Context) *metrics {
+	if m, ok := ctx.Value(metricsKey{}).(*metrics); ok {
+		return m
+	}
+	return newMetrics()
+}
+
+func (m *metrics) statusPosted(state string) {
+	m.Lock()
+	defer m.Unlock()
+	m.posted[state]++
+}
+
+func (m *metrics) requestDone(d time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.latencies = append(m.latencies, float64(d.Milliseconds()))
+}
+
+func (m *metrics) executionDone(d time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.duration = d
+}
+
+func (m *metrics) failed(err error) {
+	m.Lock()
+	defer m.Unlock()
+	m.errors[errorCategory(err)]++
+}
+
+func (m *metrics) hasErrors() bool {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.errors) > 0
+}
+
+// errorCategory classifies an error for the Errors metric.
+func errorCategory(err error) string {
+	var rerr *retryError
+	var resErr *responseError
+	var aerr smithy.APIError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return "timeout"
+	case isAccessDenied(err), errors.Is(err, errUnauthorized):
+		return "permissions"
+	case errors.Is(err, errRateLimited):
+		return "rate-limit"
+	case errors.Is(err, errArtifactsPending):
+		return "not-ready"
+	case errors.As(err, &rerr) && rerr.StatusCode == 0:
+		return "network"
+	case errors.As(err, &rerr), errors.As(err, &resErr):
+		return "provider"
+	case retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == aws.TrueTernary:
+		return "throttling"
+	case errors.As(err, &aerr):
+		return "aws"
+	}
+	return "other"
+}
+
+// emfMetric and emfDirective make up the _aws member of an embedded metric
+// format document.
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+// write logs the metrics as embedded metric format documents, one per set
+// of dimensions: StatusesPosted per State, ProviderLatency, ExecutionDuration
+// and Errors per Category, each also per Pipeline.
+func (m *metrics) write(pipeline string) {
+	ns := os.Getenv("METRICS_NAMESPACE")
+	if ns == "" {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+
+	ts := time.Now().UnixNano() / int64(time.Millisecond)
+	emit := func(dimension, value, metric, unit string, v interface{}) {
+		dims := [][]string{{"Pipeline"}}
+		doc := map[string]interface{}{"Pipeline": pipeline, metric: v}
+		if dimension != "" {
+			dims = [][]string{{"Pipeline", dimension}, {dimension}}
+			doc[dimension] = value
+		}
+		doc["_aws"] = map[string]interface{}{
+			"Timestamp": ts,
+			"CloudWatchMetrics": []emfDirective{{
+				Namespace:  ns,
+				Dimensions: dims,
+				Metrics:    []emfMetric{{Name: metric, Unit: unit}},
+			}},
+		}
+		b, err := json.Marshal(doc)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "%s\n", b)
+	}
+
+	for _, state := range sortedKeys(m.posted) {
+		emit("State", state, "StatusesPosted", "Count", m.posted[state])
+	}
+	if len(m.latencies) > 0 {
+		emit("", "", "ProviderLatency", "Milliseconds", m.latencies)
+	}
+	if m.duration > 0 {
+		emit("", "", "ExecutionDuration", "Seconds", m.duration.Seconds())
+	}
+	for _, c := range sortedKeys(m.errors) {
+		emit("Category", c, "Errors", "Count", m.errors[c])
+	}
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// notification describes the outcome of an execution for a source.
+type notification struct {
+	Pipeline    string
+	ExecutionID string
+	// State is the state of the status, e.g. failure.
+	State      string
+	Repository string
+	Commits    []string
+	// Author is who started the execution or authored the commit, if known.
+	Author string
+	URL    string
+}
+
+// notifier sends notifications, e.g. to a chat.
+type notifier interface {
+	notify(ctx context.Context, n notification) error
+}
+
+// notifyStates returns the states to notify about, from NOTIFY_STATES.
+func notifyStates() map[string]bool {
+	v := os.Getenv("NOTIFY_STATES")
+	if v == "" {
+		v = "failure,error"
+	}
+	states := map[string]bool{}
+	for _, s := range strings.Split(v, ",") {
+		states[strings.TrimSpace(s)] = true
+	}
+	return states
+}
+
+// configuredNotifiers returns the notifiers configured in the environment.
+func configuredNotifiers(ctx context.Context) ([]notifier, error) {
+	var ns []notifier
+	webhook := os.Getenv("SLACK_WEBHOOK_URL")
+	if arn := os.Getenv("SLACK_WEBHOOK_URL_SECRET_ARN"); arn != "" {
+		var err error
+		webhook, err = secretString(ctx, arn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Slack webhook URL from secret %s: %w", arn, err)
+		}
+	}
+	if webhook != "" {
+		ns = append(ns, &slackNotifier{webhook: webhook, channel: os.Getenv("SLACK_CHANNEL")})
+	}
+	return ns, nil
+}
+
+// slackNotifier posts messages to a Slack incoming webhook.
+type slackNotifier struct {
+	webhook string
+	// channel overrides the webhook's channel, where Slack still allows it.
+	channel string
+}
+
+var slackEmoji = map[string]string{
+	"success": ":white_check_mark:",
+	"failure": ":x:",
+	"error":   ":warning:",
+	"pending": ":hourglass_flowing_sand:",
+	"stuck":   ":warning:",
+}
+
+func (s *slackNotifier) notify(ctx context.Context, n notification) error {
+	commits := make([]string, len(n.Commits))
+	for i, c := range n.Commits {
+		if len(c) > 7 {
+			c = c[:7]
+		}
+		commits[i] = "`" + c + "`"
+	}
+	text := fmt.Sprintf("%s Pipeline *%s* <%s|%s>: %s@%s", slackEmoji[n.State], n.Pipeline,
+		n.URL, n.State, n.Repository, strings.Join(commits, ", "))
+	if n.Author != "" {
+		text += " (" + n.Author + ")"
+	}
+
+	msg := struct {
+		Channel string `json:"channel,omitempty"`
+		Text    string `json:"text"`
+	}{s.channel, text}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	res, resBody, err := doRequest(ctx, "POST", s.webhook, "", b)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != 200 {
+		return newResponseError("Slack", res, resBody)
+	}
+	return nil
+}
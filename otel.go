@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/aws/aws-sdk-go-v2/otelaws"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/infopark/lambda-codepipeline-github-status"
+
+// The providers exporting to OTLP, if OTEL_EXPORTER_OTLP_ENDPOINT is set.
+var (
+	tracerProvider *sdktrace.TracerProvider
+	meterProvider  *sdkmetric.MeterProvider
+)
+
+// postedCounter counts the statuses posted, by state. Like all instruments of
+// the global meter, it does nothing unless telemetry is exported.
+var postedCounter, _ = otel.Meter(instrumentationName).Int64Counter("statuses.posted",
+	metric.WithDescription("Statuses posted to GitHub or another provider"))
+
+// setupTelemetry exports traces and metrics to the OTLP endpoint configured by
+// the standard OTEL_EXPORTER_OTLP_* environment variables, if
+// OTEL_EXPORTER_OTLP_ENDPOINT is set. The AWS calls of clients created from
+// cfg and the requests of httpClient get spans then, in addition to their
+// X-Ray subsegments.
+func setupTelemetry(ctx context.Context, cfg *aws.Config) error {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+		return nil
+	}
+	// The default resource names the service from OTEL_SERVICE_NAME and
+	// takes OTEL_RESOURCE_ATTRIBUTES into account.
+	res := resource.Default()
+	traces, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return err
+	}
+	metrics, err := otlpmetrichttp.New(ctx)
+	if err != nil {
+		return err
+	}
+	// Spans are batched and flushed at the end of each invocation, see
+	// flushTelemetry, as Lambda freezes the container in between.
+	tracerProvider = sdktrace.NewTracerProvider(sdktrace.WithBatcher(traces),
+		sdktrace.WithResource(res))
+	meterProvider = sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metrics)), sdkmetric.WithResource(res))
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetMeterProvider(meterProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	otelaws.AppendMiddlewares(&cfg.APIOptions)
+	httpClient = xray.Client(&http.Client{Transport: otelhttp.NewTransport(httpTransport)})
+	return nil
+}
+
+// flushTelemetry exports the spans and metrics recorded so far.
+func flushTelemetry(ctx context.Context) {
+	if tracerProvider == nil {
+		return
+	}
+	if err := tracerProvider.ForceFlush(ctx); err != nil {
+		logger(ctx).Warn("failed to export traces", "error", err)
+	}
+	if err := meterProvider.ForceFlush(ctx); err != nil {
+		logger(ctx).Warn("failed to export metrics", "error", err)
+	}
+}
+
+// startSpan starts a span of the invocation.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (
+	context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends the span, recording err, if any.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// countPosted counts a posted status.
+func countPosted(ctx context.Context, pipeline, state string) {
+	postedCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("pipeline", pipeline),
+		attribute.String("state", state)))
+}
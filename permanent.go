@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/aws/smithy-go"
+)
+
+// permanentError marks an error that retrying the event can't fix, see
+// isPermanent.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// permanent marks err as permanent.
+func permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// isPermanent reports whether retrying the event can't fix err: the event is
+// invalid, the pipeline, execution, repository or commit doesn't exist, the
+// credentials are rejected, or a provider refused the request for another
+// reason than rate limiting. Joined errors are permanent if all of them are.
+func isPermanent(err error) bool {
+	for u := err; u != nil; u = errors.Unwrap(u) {
+		if j, ok := u.(interface{ Unwrap() []error }); ok {
+			for _, e := range j.Unwrap() {
+				if !isPermanent(e) {
+					return false
+				}
+			}
+			return true
+		}
+		if _, ok := u.(permanentError); ok {
+			return true
+		}
+	}
+	var perr permanentError
+	var resErr *responseError
+	var aerr smithy.APIError
+	switch {
+	case errors.As(err, &perr), errors.Is(err, errNotFound), errors.Is(err, errUnauthorized),
+		errors.Is(err, errUnknownCommit):
+		return true
+	case errors.As(err, &resErr):
+		return resErr.StatusCode >= 400 && resErr.StatusCode < 500 && !resErr.rateLimited &&
+			resErr.StatusCode != http.StatusRequestTimeout && resErr.StatusCode != http.StatusConflict
+	case errors.As(err, &aerr):
+		switch aerr.ErrorCode() {
+		case "PipelineNotFoundException", "PipelineExecutionNotFoundException",
+			"ValidationException":
+			return true
+		}
+	}
+	return false
+}
+
+// retryable returns the error to report to Lambda or SQS for a failed event:
+// nil for permanent errors, which would be retried in vain, unless
+// RETRY_PERMANENT_ERRORS is "true". Handle has logged, counted and recorded
+// the error in FAILURE_TABLE either way.
+func retryable(err error) error {
+	if isPermanent(err) && os.Getenv("RETRY_PERMANENT_ERRORS") != "true" {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+// pipelineFilter returns why the pipeline's events are ignored, or an empty
+// string if they're handled: they're handled if the pipeline's name matches
+// one of the patterns in INCLUDE_PIPELINES, or that's unset, and none in
+// EXCLUDE_PIPELINES. This lets a single EventBridge rule matching all
+// pipelines serve those configured here.
+func pipelineFilter(pipeline string) (string, error) {
+	include, exclude, err := pipelinePatterns()
+	if err != nil {
+		return "", err
+	}
+	if include != nil && !matchesAny(include, pipeline) {
+		return "Pipeline isn't matched by INCLUDE_PIPELINES", nil
+	}
+	if matchesAny(exclude, pipeline) {
+		return "Pipeline is matched by EXCLUDE_PIPELINES", nil
+	}
+	return "", nil
+}
+
+// pipelinePatterns returns the comma-separated patterns of
+// INCLUDE_PIPELINES and EXCLUDE_PIPELINES, e.g. "team-a-*,shared-ci".
+func pipelinePatterns() (include, exclude []string, err error) {
+	include = splitList(os.Getenv("INCLUDE_PIPELINES"))
+	exclude = splitList(os.Getenv("EXCLUDE_PIPELINES"))
+	for name, patterns := range map[string][]string{
+		"INCLUDE_PIPELINES": include, "EXCLUDE_PIPELINES": exclude} {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, nil, fmt.Errorf("invalid %s pattern %q: %w", name, p, err)
+			}
+		}
+	}
+	return include, exclude, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPipelineFilter(t *testing.T) {
+	tests := []struct {
+		include, exclude, pipeline string
+		ignored                    bool
+	}{
+		{"", "", "team-a-api", false},
+		{"team-a-*,shared-ci", "", "team-a-api", false},
+		{"team-a-*,shared-ci", "", "shared-ci", false},
+		{"team-a-*,shared-ci", "", "team-b-api", true},
+		{"", "legacy-*", "legacy-app", true},
+		{"team-a-*", "team-a-sandbox", "team-a-sandbox", true},
+	}
+	for _, tt := range tests {
+		t.Setenv("INCLUDE_PIPELINES", tt.include)
+		t.Setenv("EXCLUDE_PIPELINES", tt.exclude)
+		reason, err := pipelineFilter(tt.pipeline)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ignored := reason != ""; ignored != tt.ignored {
+			t.Errorf("include %q, exclude %q: pipelineFilter(%q) = %q, want ignored %t",
+				tt.include, tt.exclude, tt.pipeline, reason, tt.ignored)
+		}
+	}
+}
+
+func TestPipelineFilterInvalidPattern(t *testing.T) {
+	t.Setenv("EXCLUDE_PIPELINES", "legacy-[")
+	if _, err := pipelineFilter("legacy-app"); err == nil {
+		t.Error("pipelineFilter accepted an invalid pattern")
+	}
+}
+
+// TestHandleExcludedPipeline makes sure events of excluded pipelines are
+// skipped before they're validated, instead of failing permanently.
+func TestHandleExcludedPipeline(t *testing.T) {
+	cp := newFakeCodePipeline()
+	h, gh := newTestHandler(t, cp)
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("EXCLUDE_PIPELINES", "legacy-*")
+	// The event lacks the execution ID and there's no token.
+	if err := h.Handle(context.Background(), event{Pipeline: "legacy-app"}); err != nil {
+		t.Errorf("Handle() = %v, want the event skipped", err)
+	}
+	if len(cp.calls) > 0 || len(gh.received()) > 0 {
+		t.Errorf("looked up %v and sent %d requests for an excluded pipeline", cp.calls,
+			len(gh.received()))
+	}
+
+	err := h.Handle(context.Background(), event{Pipeline: "hello-world"})
+	if !isPermanent(err) {
+		t.Errorf("Handle() = %v for an invalid event of another pipeline, want a permanent error",
+			err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+)
+
+// prefetchTimeout bounds the prefetching, which counts against the
+// function's initialization phase.
+const prefetchTimeout = 5 * time.Second
+
+// prefetchEnabled reports whether to prefetch at cold start: if PREFETCH is
+// true, or unless it's false, if the environment is initialized for
+// provisioned concurrency, where initialization happens ahead of the first
+// request.
+func prefetchEnabled() bool {
+	switch os.Getenv("PREFETCH") {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return os.Getenv("AWS_LAMBDA_INITIALIZATION_TYPE") == "provisioned-concurrency"
+}
+
+// prefetch does the work of a first request that doesn't depend on it: it
+// creates the GitHub App's installation token, if configured, and opens a
+// TLS connection to the GitHub API for the first request to reuse.
+// Configuration and secrets are loaded by validateConfig already. Failures
+// are logged only; the first request tries again.
+func prefetch(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, prefetchTimeout)
+	defer cancel()
+	start := time.Now()
+	if githubAppConfigured() {
+		if _, err := cachedGithubAppToken(ctx); err != nil {
+			logger(ctx).Warn("failed to prefetch installation token", "error", err)
+		}
+	}
+	base := githubAPIBaseURL(ctx)
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, base, nil)
+	if err == nil {
+		// Not traced: there's no invocation yet. The transport, and with it
+		// the connection, is shared with httpClient.
+		var res *http.Response
+		if res, err = (&http.Client{Transport: httpTransport}).Do(req); err == nil {
+			res.Body.Close()
+		}
+	}
+	if err != nil {
+		logger(ctx).Warn("failed to warm connection", "url", base, "error", err)
+	}
+	logger(ctx).Info("prefetched", "duration-ms", time.Since(start).Milliseconds())
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codestarconnections"
+	"github.com/aws/aws-sdk-go-v2/service/codestarconnections/types"
+)
+
+// provider posts statuses to a source code hosting service.
+type provider interface {
+	// name identifies the provider, e.g. in logs.
+	name() string
+	// report sets the status of a commit and returns the URL of the created
+	// status, if known.
+	report(ctx context.Context, repo, rev string, payload ghReqPayload) (string, error)
+}
+
+const (
+	providerGitHub    = "github"
+	providerBitbucket = "bitbucket"
+	providerGitLab    = "gitlab"
+	providerGitea     = "gitea"
+	providerAzure     = "azure-devops"
+)
+
+// tokenEnvPrefixes are the prefixes of the environment variables holding the
+// tokens of providers other than GitHub, e.g. BITBUCKET_TOKEN.
+var tokenEnvPrefixes = []string{"BITBUCKET", "GITLAB", "GITEA", "AZURE_DEVOPS"}
+
+// providerToken returns a provider's token from the Secrets Manager secret
+// named by <prefix>_TOKEN_SECRET_ARN or from <prefix>_TOKEN.
+func providerToken(ctx context.Context, prefix string) (string, error) {
+	if arn := os.Getenv(prefix + "_TOKEN_SECRET_ARN"); arn != "" {
+		token, err := secretString(ctx, arn)
+		if err != nil {
+			return "", fmt.Errorf("failed to read token from secret %s: %w", arn, err)
+		}
+		return token, nil
+	}
+	if token := os.Getenv(prefix + "_TOKEN"); token != "" {
+		addSecret(token)
+		return token, nil
+	}
+	return "", fmt.Errorf("%s_TOKEN or %s_TOKEN_SECRET_ARN must be set", prefix, prefix)
+}
+
+// providerRegistration describes how to recognize the repositories a
+// provider hosts and how to create it.
+type providerRegistration struct {
+	name string
+	// hosts are the hostnames of its revision URLs, hostEnv names an
+	// environment variable holding another one, e.g. of a self-managed
+	// instance.
+	hosts   []string
+	hostEnv string
+	// connectionTypes are the provider types of the CodeStar connections
+	// to it.
+	connectionTypes []types.ProviderType
+	newProvider     func(ctx context.Context, ev event) (provider, error)
+}
+
+// registeredProviders are the providers registered by registerProvider.
+// GitHub hosts the repositories of revision URLs no provider claims.
+var registeredProviders = map[string]providerRegistration{}
+
+func registerProvider(r providerRegistration) {
+	registeredProviders[r.name] = r
+}
+
+func init() {
+	registerProvider(providerRegistration{
+		name:    providerGitHub,
+		hosts:   []string{"github.com", "www.github.com"},
+		hostEnv: "GITHUB_HOSTNAME",
+		connectionTypes: []types.ProviderType{
+			types.ProviderTypeGithub, types.ProviderTypeGithubEnterpriseServer},
+		newProvider: newGithubProvider,
+	})
+}
+
+func newGithubProvider(ctx context.Context, ev event) (provider, error) {
+	if ev.GithubToken == "" {
+		return nil, errors.New("no GitHub token available")
+	}
+	checks, err := checksEnabled(ev)
+	if err != nil {
+		return nil, err
+	}
+	return &githubProvider{token: ev.GithubToken, executionID: ev.ExecutionID, checks: checks}, nil
+}
+
+// newProvider returns the provider hosting the repository the revision URL
+// points to.
+func newProvider(ctx context.Context, u *url.URL, ev event) (provider, error) {
+	r := detectProvider(ctx, u)
+	return r.newProvider(ctx, ev)
+}
+
+// detectProvider determines the hosting service from the revision URL's
+// hostname. For CodeStar connections, it looks up the connection's provider
+// type.
+func detectProvider(ctx context.Context, u *url.URL) providerRegistration {
+	github := registeredProviders[providerGitHub]
+	for _, r := range registeredProviders {
+		if h := os.Getenv(r.hostEnv); r.hostEnv != "" && h != "" && u.Hostname() == h {
+			return r
+		}
+		for _, h := range r.hosts {
+			if u.Hostname() == h {
+				return r
+			}
+		}
+	}
+	arn := u.Query().Get("connectionArn")
+	if arn == "" {
+		return github
+	}
+	t, err := connectionProviderType(ctx, arn)
+	if err != nil {
+		// Don't break GitHub setups whose role may not look up connections.
+		logger(ctx).Warn("failed to look up connection, assuming GitHub",
+			"connection-arn", arn, "error", err)
+		return github
+	}
+	for _, r := range registeredProviders {
+		for _, ct := range r.connectionTypes {
+			if t == ct {
+				return r
+			}
+		}
+	}
+	return github
+}
+
+// Provider types of connections, keyed by ARN. Connections can't change
+// their provider type.
+var connectionTypes = struct {
+	sync.Mutex
+	m map[string]types.ProviderType
+}{m: map[string]types.ProviderType{}}
+
+func connectionProviderType(ctx context.Context, arn string) (types.ProviderType, error) {
+	connectionTypes.Lock()
+	defer connectionTypes.Unlock()
+	if t, ok := connectionTypes.m[arn]; ok {
+		return t, nil
+	}
+	res, err := connectionsClient.GetConnection(ctx, &codestarconnections.GetConnectionInput{
+		ConnectionArn: aws.String(arn),
+	})
+	if err != nil {
+		return "", err
+	}
+	if res.Connection == nil {
+		return "", fmt.Errorf("connection %s not found", arn)
+	}
+	t := res.Connection.ProviderType
+	connectionTypes.m[arn] = t
+	return t, nil
+}
+
+type githubProvider struct {
+	token       string
+	executionID string
+	checks      bool
+}
+
+func (p *githubProvider) name() string {
+	return providerGitHub
+}
+
+func (p *githubProvider) report(ctx context.Context, repo, rev string, payload ghReqPayload) (string, error) {
+	if p.checks {
+		return postCheckRun(ctx, repo, rev, p.token, p.executionID, payload)
+	}
+	ghURL := fmt.Sprintf("%s/repos/%s/statuses/%s", githubAPIBaseURL(ctx), repo, rev)
+	id, statusURL, err := postStatus(ctx, ghURL, p.token, payload)
+	if errors.Is(err, errNotFound) {
+		// GitHub hides repositories the token can't access.
+		return "", fmt.Errorf("repository %s doesn't exist or the token can't access it: %w",
+			repo, err)
+	}
+	if err != nil || id == 0 || os.Getenv("VERIFY_STATUSES") != "true" {
+		return statusURL, err
+	}
+	// The status is set either way, so failing to verify it is only logged.
+	if err := verifyStatus(ctx, repo, rev, p.token, id); err != nil {
+		logger(ctx).Warn("failed to verify status", "status-id", id, "error", err)
+	}
+	return statusURL, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+)
+
+// isScheduledEvent reports whether the payload is an event of an EventBridge
+// schedule.
+func isScheduledEvent(payload json.RawMessage) bool {
+	var ev events.CloudWatchEvent
+	return json.Unmarshal(payload, &ev) == nil && ev.Source == "aws.events" &&
+		ev.DetailType == "Scheduled Event"
+}
+
+type reconcileAPI interface {
+	codepipeline.ListPipelinesAPIClient
+	codepipeline.ListPipelineExecutionsAPIClient
+}
+
+// reconcile repairs the statuses of the latest execution of each pipeline
+// named by RECONCILE_PIPELINES, or of all pipelines if it's "*", in case the
+// events of those executions were lost. Statuses already in the right state
+// aren't posted again. Executions running for longer than STUCK_THRESHOLD
+// get a warning in their description.
+func reconcile(ctx context.Context, cp reconcileAPI) error {
+	pipelines, err := reconcilePipelines(ctx, cp)
+	if err != nil {
+		return err
+	}
+	threshold, err := stuckThreshold()
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, pipeline := range pipelines {
+		res, err := cp.ListPipelineExecutions(ctx, &codepipeline.ListPipelineExecutionsInput{
+			PipelineName: aws.String(pipeline),
+			MaxResults:   aws.Int32(1),
+		})
+		if err != nil {
+			logger(ctx).Warn("failed to list executions", "pipeline", pipeline, "error", err)
+			failed = append(failed, pipeline)
+			continue
+		}
+		if len(res.PipelineExecutionSummaries) == 0 {
+			continue
+		}
+		ex := res.PipelineExecutionSummaries[0]
+		ev := event{Pipeline: pipeline, ExecutionID: aws.ToString(ex.PipelineExecutionId),
+			Repair: true}
+		if threshold > 0 && ex.Status == types.PipelineExecutionStatusInProgress &&
+			ex.StartTime != nil && time.Since(*ex.StartTime) > threshold {
+			ev.StuckFor = time.Since(*ex.StartTime)
+			logger(ctx).Warn("execution is stuck", "pipeline", pipeline,
+				"execution-id", ev.ExecutionID, "running-for", ev.StuckFor.Round(time.Second))
+		}
+		err = HandleLambdaEvent(ctx, ev)
+		if err != nil {
+			failed = append(failed, pipeline)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to reconcile the statuses of pipelines %s",
+			strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func reconcilePipelines(ctx context.Context, cp codepipeline.ListPipelinesAPIClient) (
+	[]string, error) {
+	v := strings.TrimSpace(os.Getenv("RECONCILE_PIPELINES"))
+	if v == "" {
+		return nil, errors.New("scheduled reconciliation requires RECONCILE_PIPELINES")
+	}
+	if v != "*" {
+		var pipelines []string
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				pipelines = append(pipelines, p)
+			}
+		}
+		return pipelines, nil
+	}
+	var pipelines []string
+	pages := codepipeline.NewListPipelinesPaginator(cp, &codepipeline.ListPipelinesInput{})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pipelines: %w", err)
+		}
+		for _, p := range page.Pipelines {
+			pipelines = append(pipelines, aws.ToString(p.Name))
+		}
+	}
+	return pipelines, nil
+}
+
+// stuckThreshold returns how long an execution may run before reconcile
+// considers it stuck, from STUCK_THRESHOLD. Zero disables the check.
+func stuckThreshold() (time.Duration, error) {
+	return durationEnv("STUCK_THRESHOLD", 0)
+}
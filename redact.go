@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const redacted = "[REDACTED]"
+
+// knownSecrets holds the tokens and secrets the function has resolved, so
+// that they're masked wherever they show up.
+var knownSecrets sync.Map
+
+// addSecret registers a secret for redaction. Very short values are ignored,
+// as masking them would mangle unrelated text.
+func addSecret(s string) {
+	if len(s) >= 8 {
+		knownSecrets.Store(s, struct{}{})
+	}
+}
+
+var secretPatterns = []*regexp.Regexp{
+	// GitHub tokens, e.g. ghp_… and github_pat_….
+	regexp.MustCompile(`\b(?:gh[opsur]_[A-Za-z0-9]{20,}|github_pat_[A-Za-z0-9_]{20,})`),
+	// Authorization header values, with or without their scheme.
+	regexp.MustCompile(`(?i)(authorization"?\s*[:=]\s*"?(?:(?:token|bearer|basic)\s+)?)[^\s",]+`),
+}
+
+// redact masks the known secrets and anything looking like a token or an
+// Authorization header in s.
+func redact(s string) string {
+	knownSecrets.Range(func(k, _ any) bool {
+		s = strings.ReplaceAll(s, k.(string), redacted)
+		return true
+	})
+	s = secretPatterns[0].ReplaceAllString(s, redacted)
+	return secretPatterns[1].ReplaceAllString(s, "${1}"+redacted)
+}
+
+// redactedError masks secrets in the message of the error it wraps, which
+// remains available to errors.Is and errors.As.
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+func redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if msg := redact(err.Error()); msg != err.Error() {
+		return &redactedError{msg: msg, err: err}
+	}
+	return err
+}
+
+// redactAttr is the ReplaceAttr option of the log handler, masking secrets
+// in all strings and errors that are logged.
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	switch v := a.Value; v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redact(v.String()))
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case error:
+			return slog.String(a.Key, redact(x.Error()))
+		case json.RawMessage:
+			return slog.String(a.Key, redact(string(x)))
+		}
+	}
+	return a
+}
+
+// String describes the event with its token masked.
+func (ev event) String() string {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return "event{" + ev.Pipeline + " " + ev.ExecutionID + "}"
+	}
+	return string(b)
+}
+
+// MarshalJSON encodes the event with its token masked, so that it can't leak
+// by logging or storing the event.
+func (ev event) MarshalJSON() ([]byte, error) {
+	type plain event
+	if ev.GithubToken != "" {
+		ev.GithubToken = redacted
+	}
+	return json.Marshal(plain(ev))
+}
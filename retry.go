@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultGithubAttempts = 4
+	retryBaseDelay        = 500 * time.Millisecond
+	retryMaxDelay         = 20 * time.Second
+	// Waiting longer than this for a rate limit to reset isn't worth the
+	// Lambda time; the invocation fails instead.
+	retryMaxWait = time.Minute
+)
+
+// retryError is returned once a request failed for good.
+type retryError struct {
+	Method   string
+	URL      string
+	Attempts int
+	// StatusCode is the last response's status code, 0 if there was none.
+	StatusCode int
+	Err        error
+}
+
+func (e *retryError) Error() string {
+	return fmt.Sprintf("%s %s failed after %d attempts: %v", e.Method, e.URL, e.Attempts, e.Err)
+}
+
+func (e *retryError) Unwrap() error {
+	return e.Err
+}
+
+func githubMaxAttempts() int {
+	n, err := strconv.Atoi(os.Getenv("GITHUB_MAX_ATTEMPTS"))
+	if err != nil || n < 1 {
+		return defaultGithubAttempts
+	}
+	return n
+}
+
+// doRequest sends a request to the GitHub API, retrying connection errors,
+// server errors and rate limited requests with jittered exponential backoff
+// unless GitHub says how long to wait. auth is the Authorization header, if
+// any. It
+// returns the last response with its body already read. Each attempt is
+// limited to GITHUB_TIMEOUT, and no retry is attempted that wouldn't finish
+// before ctx expires. In a dry run, requests other than GETs aren't sent.
+func doRequest(ctx context.Context, method, ghURL, auth string, body []byte) (
+	*http.Response, []byte, error) {
+	if isDryRun(ctx) && method != "GET" {
+		logger(ctx).Info("dry run, not sending request", "method", method, "url", ghURL,
+			"body", string(body))
+		return nil, nil, errDryRun
+	}
+	attempts := githubMaxAttempts()
+	for attempt := 1; ; attempt++ {
+		ghRes, resBody, err := attemptRequest(ctx, method, ghURL, auth, body)
+		if ctx.Err() != nil {
+			return ghRes, resBody, err
+		}
+		wait, retry := retryDelay(ghRes, err, attempt)
+		if !retry {
+			return ghRes, resBody, err
+		}
+
+		rerr := &retryError{Method: method, URL: ghURL, Attempts: attempt, Err: err}
+		if err == nil {
+			rerr.StatusCode = ghRes.StatusCode
+			rerr.Err = newResponseError("", ghRes, resBody)
+		}
+		if attempt >= attempts || wait > retryMaxWait {
+			return ghRes, resBody, rerr
+		}
+		if d, ok := ctx.Deadline(); ok && time.Until(d) < wait {
+			// The invocation would time out while waiting.
+			return ghRes, resBody, rerr
+		}
+		logger(ctx).Warn("retrying request", "method", method, "url", ghURL,
+			"attempt", attempt, "wait", wait.String(), "error", rerr.Err)
+		if err := sleep(ctx, wait); err != nil {
+			return ghRes, resBody, rerr
+		}
+	}
+}
+
+// attemptRequest sends a request once, within GITHUB_TIMEOUT.
+func attemptRequest(ctx context.Context, method, ghURL, auth string, body []byte) (
+	*http.Response, []byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, githubTimeout)
+	defer cancel()
+	ghReq, err := http.NewRequestWithContext(ctx, method, ghURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	ghReq.Header.Set("Accept", "application/json")
+	for name, v := range requestHeaders(ctx) {
+		ghReq.Header[name] = v
+	}
+	if auth != "" {
+		ghReq.Header.Set("Authorization", auth)
+	}
+	if body != nil {
+		ghReq.Header.Set("Content-Type", "application/json; charset=utf-8")
+	}
+
+	start := time.Now()
+	ghRes, err := httpClientFrom(ctx).Do(ghReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	d := time.Since(start)
+	metricsFrom(ctx).requestDone(d)
+	logger(ctx).Info("request", "method", method, "url", ghURL,
+		"status-code", ghRes.StatusCode, "duration-ms", d.Milliseconds())
+	defer ghRes.Body.Close()
+	resBody, err := ioutil.ReadAll(ghRes.Body)
+	return ghRes, resBody, err
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryDelay decides whether to retry after the given outcome of an attempt,
+// and how long to wait before doing so.
+func retryDelay(res *http.Response, err error, attempt int) (time.Duration, bool) {
+	if err == nil && !retryableStatus(res) {
+		return 0, false
+	}
+	if res != nil {
+		if s, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+			return time.Duration(s) * time.Second, true
+		}
+		if res.Header.Get("X-RateLimit-Remaining") == "0" {
+			if reset, err := strconv.ParseInt(res.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+				return time.Until(time.Unix(reset, 0)) + time.Second, true
+			}
+		}
+	}
+	backoff := retryBaseDelay << uint(attempt-1)
+	if backoff > retryMaxDelay || backoff <= 0 {
+		backoff = retryMaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(backoff))) + 1, true
+}
+
+func retryableStatus(res *http.Response) bool {
+	switch {
+	case res.StatusCode >= 500, res.StatusCode == http.StatusTooManyRequests:
+		return true
+	case res.StatusCode == http.StatusForbidden:
+		// Primary and secondary rate limits, as opposed to missing
+		// permissions.
+		return res.Header.Get("X-RateLimit-Remaining") == "0" ||
+			res.Header.Get("Retry-After") != ""
+	}
+	return false
+}
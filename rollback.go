@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+)
+
+const defaultRollbackContext = "codepipeline/rollback"
+
+// isRollback reports whether the execution rolls a stage back to the
+// revisions of an earlier execution rather than building something new.
+func isRollback(ex *types.PipelineExecution) bool {
+	if ex.ExecutionType == types.ExecutionTypeRollback {
+		return true
+	}
+	t := ex.Trigger
+	return t != nil && (t.TriggerType == types.TriggerTypeManualRollback ||
+		t.TriggerType == types.TriggerTypeAutomatedRollback)
+}
+
+// rollbackDescription explains the status of a rollback, naming the
+// execution rolled back to if known.
+func rollbackDescription(ex *types.PipelineExecution) string {
+	kind := "Rollback"
+	if t := ex.Trigger; t != nil && t.TriggerType == types.TriggerTypeAutomatedRollback {
+		kind = "Automated rollback"
+	}
+	if m := ex.RollbackMetadata; m != nil && m.RollbackTargetPipelineExecutionId != nil {
+		return kind + " to execution " + aws.ToString(m.RollbackTargetPipelineExecutionId)
+	}
+	return kind + " to an earlier execution"
+}
+
+// rollbackContext is the status context of rollbacks, from
+// ROLLBACK_STATUS_CONTEXT.
+func rollbackContext() string {
+	if c := os.Getenv("ROLLBACK_STATUS_CONTEXT"); c != "" {
+		return c
+	}
+	return defaultRollbackContext
+}
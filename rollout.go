@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Features that can be rolled out to some pipelines before enabling them
+// for all, each configured by ROLLOUT_<feature>.
+const (
+	featureChecks     = "CHECKS"
+	featurePRComments = "PR_COMMENTS"
+	featurePerStage   = "PER_STAGE"
+)
+
+var rolloutFeatures = []string{featureChecks, featurePRComments, featurePerStage}
+
+// rollout selects the pipelines a feature is enabled for: those matching
+// one of the name patterns and a stable share of the others.
+type rollout struct {
+	patterns []string
+	percent  uint32
+}
+
+// parseRollout parses a comma-separated list of pipeline name patterns and
+// at most one percentage, e.g. "team-a-*,staging-*,10%".
+func parseRollout(name, v string) (rollout, error) {
+	var r rollout
+	percent := false
+	for _, item := range splitList(v) {
+		if p, ok := strings.CutSuffix(item, "%"); ok {
+			n, err := strconv.ParseUint(p, 10, 32)
+			if err != nil || n > 100 || percent {
+				return r, fmt.Errorf("invalid %s percentage %q", name, item)
+			}
+			r.percent, percent = uint32(n), true
+			continue
+		}
+		if _, err := path.Match(item, ""); err != nil {
+			return r, fmt.Errorf("invalid %s pattern %q: %w", name, item, err)
+		}
+		r.patterns = append(r.patterns, item)
+	}
+	return r, nil
+}
+
+// includes reports whether the pipeline is selected. The share is based on
+// a hash of the pipeline's name, so raising the percentage only adds
+// pipelines.
+func (r rollout) includes(pipeline string) bool {
+	if matchesAny(r.patterns, pipeline) {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(pipeline))
+	return h.Sum32()%100 < r.percent
+}
+
+// rolledOut reports whether the feature is enabled for the pipeline by
+// ROLLOUT_<feature>. Like all settings, these may come from Parameter Store.
+func rolledOut(feature, pipeline string) (bool, error) {
+	name := "ROLLOUT_" + feature
+	r, err := parseRollout(name, os.Getenv(name))
+	if err != nil {
+		return false, err
+	}
+	return r.includes(pipeline), nil
+}
+
+// validateRollouts checks all ROLLOUT_<feature> settings.
+func validateRollouts() error {
+	for _, f := range rolloutFeatures {
+		if _, err := rolledOut(f, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyRollouts enables the features rolled out to the event's pipeline
+// that the event doesn't enable itself. It returns the features enabled.
+func (ev *event) applyRollouts() ([]string, error) {
+	var enabled []string
+	for _, f := range []struct {
+		name string
+		opt  *bool
+	}{
+		{featurePRComments, &ev.PRComment},
+		{featurePerStage, &ev.PerStage},
+	} {
+		if *f.opt {
+			continue
+		}
+		on, err := rolledOut(f.name, ev.Pipeline)
+		if err != nil {
+			return nil, err
+		}
+		if on {
+			*f.opt = true
+			enabled = append(enabled, f.name)
+		}
+	}
+	return enabled, nil
+}
+
+// checksEnabled reports whether check runs rather than commit statuses are
+// created for the event's pipeline: for all pipelines if GITHUB_REPORTER is
+// checks, otherwise for those ROLLOUT_CHECKS selects.
+func checksEnabled(ev event) (bool, error) {
+	checks, err := checksMode()
+	if err != nil || checks {
+		return checks, err
+	}
+	if os.Getenv("GITHUB_REPORTER") == "statuses" {
+		// Explicitly opted out.
+		return false, nil
+	}
+	return rolledOut(featureChecks, ev.Pipeline)
+}
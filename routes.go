@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// route selects the GitHub credentials and API to use for the pipelines
+// whose names match Pipeline and the repositories owned by Owner. Empty
+// criteria match everything.
+type route struct {
+	Pipeline       string `json:"pipeline"`
+	Owner          string `json:"owner"`
+	Token          string `json:"token"`
+	TokenSecretARN string `json:"token-secret-arn"`
+	APIBaseURL     string `json:"api-base-url"`
+}
+
+func (r route) matches(pipeline, owner string) bool {
+	if r.Pipeline != "" {
+		if ok, _ := path.Match(r.Pipeline, pipeline); !ok {
+			return false
+		}
+	}
+	return r.Owner == "" || strings.EqualFold(r.Owner, owner)
+}
+
+// configuredRoutes returns the routes from ROUTES or, if that's unset, from
+// the Secrets Manager secret named by ROUTES_SECRET_ARN. Both hold a JSON
+// array of routes.
+func configuredRoutes(ctx context.Context) ([]route, error) {
+	v := os.Getenv("ROUTES")
+	if v == "" {
+		arn := os.Getenv("ROUTES_SECRET_ARN")
+		if arn == "" {
+			return nil, nil
+		}
+		var err error
+		v, err = secretString(ctx, arn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read routes from secret %s: %w", arn, err)
+		}
+	}
+	var routes []route
+	if err := json.Unmarshal([]byte(v), &routes); err != nil {
+		return nil, fmt.Errorf("invalid routes: %w", err)
+	}
+	for _, r := range routes {
+		if _, err := path.Match(r.Pipeline, ""); err != nil {
+			return nil, fmt.Errorf("invalid route pipeline pattern %q: %w", r.Pipeline, err)
+		}
+	}
+	return routes, nil
+}
+
+// findRoute returns the first route matching the pipeline and the owners of
+// all sources, or nil if there is none. Sources whose owners are routed
+// differently can't be reported on by a single invocation.
+func findRoute(ctx context.Context, pipeline string, sources []source) (*route, error) {
+	routes, err := configuredRoutes(ctx)
+	if err != nil || routes == nil {
+		return nil, err
+	}
+	var found *route
+	for i, src := range sources {
+		owner := strings.SplitN(src.repo, "/", 2)[0]
+		var r *route
+		for j := range routes {
+			if routes[j].matches(pipeline, owner) {
+				r = &routes[j]
+				break
+			}
+		}
+		if i > 0 && r != found {
+			return nil, fmt.Errorf("sources of pipeline %s match different routes", pipeline)
+		}
+		found = r
+	}
+	return found, nil
+}
+
+type apiBaseURLKey struct{}
+
+// withRoute returns a context using the route's GitHub API, if it has one.
+func withRoute(ctx context.Context, r *route) context.Context {
+	if r == nil || r.APIBaseURL == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, apiBaseURLKey{}, r.APIBaseURL)
+}
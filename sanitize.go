@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// GitHub rejects status descriptions and contexts longer than these.
+const (
+	maxDescriptionLen = 140
+	maxContextLen     = 255
+)
+
+// descriptionMaxLen returns the length descriptions are cut to, configured
+// by DESCRIPTION_MAX_LENGTH (default and at most 140 characters).
+func descriptionMaxLen() (int, error) {
+	v := os.Getenv("DESCRIPTION_MAX_LENGTH")
+	if v == "" {
+		return maxDescriptionLen, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 2 || n > maxDescriptionLen {
+		return 0, fmt.Errorf("invalid DESCRIPTION_MAX_LENGTH %q, expected 2 to %d",
+			v, maxDescriptionLen)
+	}
+	return n, nil
+}
+
+// truncateDescription sanitizes the description and cuts it to the
+// configured length.
+func truncateDescription(d string) string {
+	n, err := descriptionMaxLen()
+	if err != nil {
+		// Rejected by validateConfig already.
+		n = maxDescriptionLen
+	}
+	return truncateText(sanitizeText(d), n)
+}
+
+// sanitizeContext sanitizes the status context like a description. Contexts
+// are compared verbatim, so a cut one still fits but no longer matches.
+func sanitizeContext(c string) string {
+	return truncateText(sanitizeText(c), maxContextLen)
+}
+
+// sanitizeText makes text rendered from templates or AWS error messages fit
+// for a single line: invalid UTF-8 is dropped, line breaks, tabs and other
+// control characters become spaces, and runs of spaces are collapsed.
+func sanitizeText(s string) string {
+	s = strings.ToValidUTF8(s, "")
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsControl(r), unicode.IsSpace(r):
+			return ' '
+		case r == '\u200b', r == '\ufeff', unicode.Is(unicode.Bidi_Control, r):
+			// Invisible characters that reorder or hide the text.
+			return -1
+		}
+		return r
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// truncateText cuts s to at most n characters, not bytes, ending it with an
+// ellipsis if cut.
+func truncateText(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return strings.TrimRightFunc(string(r[:n-1]), unicode.IsSpace) + "…"
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Versions of the custom event's schema. Version 1 is the flat object of
+// execution-id, pipeline, token and optional parameters. Version 2 keeps
+// execution-id, pipeline and token at the top level and moves the optional
+// parameters into "options":
+//
+//	{
+//	  "version": 2,
+//	  "execution-id": "...",
+//	  "pipeline": "...",
+//	  "options": {"context": "ci/deploy", "per-stage": true}
+//	}
+const (
+	schemaV1 = "1"
+	schemaV2 = "2"
+	// schemaNative marks unchanged CodePipeline events.
+	schemaNative = "native"
+)
+
+// schemaVersion is the version of a custom event's schema, given as a number
+// or a string. Any value is accepted when decoding, as unchanged EventBridge
+// events carry a version of their own; decodeCustom checks it.
+type schemaVersion string
+
+func (v *schemaVersion) UnmarshalJSON(b []byte) error {
+	*v = schemaVersion(strings.Trim(string(b), `"`))
+	return nil
+}
+
+// v2Event is the top level of a version 2 event.
+type v2Event struct {
+	Version              schemaVersion   `json:"version"`
+	ExecutionID          string          `json:"execution-id"`
+	Pipeline             string          `json:"pipeline"`
+	GithubToken          string          `json:"github-token"`
+	GithubTokenSecretARN string          `json:"github-token-secret-arn"`
+	Options              json.RawMessage `json:"options"`
+}
+
+// decodeCustom decodes a custom event of either schema version strictly:
+// unknown parameters and parameters of the wrong type are rejected, naming
+// the parameter.
+func (ev *event) decodeCustom(b []byte) error {
+	var head struct {
+		Version schemaVersion `json:"version"`
+	}
+	if err := json.Unmarshal(b, &head); err != nil {
+		return eventParamError(err, "")
+	}
+	type plain event
+	switch head.Version {
+	case "", schemaV1:
+		if err := decodeStrict(b, (*plain)(ev)); err != nil {
+			return eventParamError(err, "")
+		}
+		ev.schema = schemaV1
+	case schemaV2:
+		var top v2Event
+		if err := decodeStrict(b, &top); err != nil {
+			return eventParamError(err, "")
+		}
+		if len(top.Options) > 0 && string(top.Options) != "null" {
+			if err := decodeStrict(top.Options, (*plain)(ev)); err != nil {
+				return eventParamError(err, "options.")
+			}
+		}
+		if ev.ExecutionID != "" || ev.Pipeline != "" || ev.GithubToken != "" ||
+			ev.GithubTokenSecretARN != "" || ev.Version != "" {
+			return errors.New("invalid event: execution-id, pipeline, github-token, " +
+				"github-token-secret-arn and version belong at the top level, not in options")
+		}
+		ev.ExecutionID = top.ExecutionID
+		ev.Pipeline = top.Pipeline
+		ev.GithubToken = top.GithubToken
+		ev.GithubTokenSecretARN = top.GithubTokenSecretARN
+		ev.schema = schemaV2
+	default:
+		return fmt.Errorf("invalid event param version %q, expected 1 or 2", head.Version)
+	}
+	ev.Version = ""
+	return nil
+}
+
+// decodeStrict decodes the JSON object b into v, rejecting unknown fields.
+func decodeStrict(b []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("invalid event: trailing data after the JSON object")
+	}
+	return nil
+}
+
+// eventParamError names the offending parameter, with the prefix, of a
+// decoding error where possible.
+func eventParamError(err error, prefix string) error {
+	var terr *json.UnmarshalTypeError
+	var serr *json.SyntaxError
+	switch {
+	case errors.As(err, &terr):
+		if terr.Field == "" {
+			return fmt.Errorf("invalid event: expected a JSON object, got %s", terr.Value)
+		}
+		return fmt.Errorf("invalid event param %s%s: expected %s, got %s", prefix, terr.Field,
+			terr.Type, terr.Value)
+	case errors.As(err, &serr):
+		return fmt.Errorf("invalid event: malformed JSON at offset %d: %w", serr.Offset, err)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		return fmt.Errorf("unknown event param %s%s", prefix, strings.Trim(field, `"`))
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// cachedSecret is the value of a secret's version, and the value it
+// replaced when it was fetched again after being rejected, see
+// refreshSecret.
+type cachedSecret struct {
+	value     string
+	versionID string
+	previous  string
+}
+
+// Secrets fetched by this container, keyed by ARN, so that warm invocations
+// don't call Secrets Manager again.
+var secrets = struct {
+	sync.Mutex
+	m map[string]*cachedSecret
+}{m: map[string]*cachedSecret{}}
+
+// secretVersionStage is the staging label of the secrets' version to use,
+// SECRET_VERSION_STAGE (default AWSCURRENT).
+func secretVersionStage() string {
+	if s := os.Getenv("SECRET_VERSION_STAGE"); s != "" {
+		return s
+	}
+	return "AWSCURRENT"
+}
+
+// secretString returns the value of the secret, which is expected to hold a
+// plain string such as a GitHub token.
+func secretString(ctx context.Context, arn string) (string, error) {
+	secrets.Lock()
+	defer secrets.Unlock()
+	if s, ok := secrets.m[secretKey(arn)]; ok {
+		return s.value, nil
+	}
+	s, err := fetchSecret(ctx, arn)
+	if err != nil {
+		return "", err
+	}
+	return s.value, nil
+}
+
+// secretKey keys the cache by the version stage too, which may change with
+// the configuration.
+func secretKey(arn string) string {
+	return arn + ":" + secretVersionStage()
+}
+
+// fetchSecret fetches the secret and caches it. The caller must hold the
+// lock.
+func fetchSecret(ctx context.Context, arn string) (*cachedSecret, error) {
+	res, err := secretsClient.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId:     aws.String(arn),
+		VersionStage: aws.String(secretVersionStage()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	s := &cachedSecret{
+		value:     strings.TrimSpace(aws.ToString(res.SecretString)),
+		versionID: aws.ToString(res.VersionId),
+	}
+	secrets.m[secretKey(arn)] = s
+	addSecret(s.value)
+	return s, nil
+}
+
+// refreshSecret fetches the secrets holding the rejected value again, as
+// they may have been rotated since they were cached, and returns the new
+// value. It returns an empty string if no cached secret holds the value or
+// the secret hasn't changed. Rejected values a concurrent invocation
+// replaced already are replaced without fetching again.
+func refreshSecret(ctx context.Context, rejected string) (string, error) {
+	secrets.Lock()
+	defer secrets.Unlock()
+	for key, s := range secrets.m {
+		if s.previous == rejected && s.value != rejected {
+			return s.value, nil
+		}
+		if s.value != rejected {
+			continue
+		}
+		arn := strings.TrimSuffix(key, ":"+secretVersionStage())
+		fresh, err := fetchSecret(ctx, arn)
+		if err != nil {
+			return "", err
+		}
+		if fresh.versionID == s.versionID || fresh.value == rejected {
+			return "", nil
+		}
+		fresh.previous = rejected
+		logger(ctx).Info("fetched rotated secret", "secret-arn", arn,
+			"version-id", fresh.versionID)
+		return fresh.value, nil
+	}
+	return "", nil
+}
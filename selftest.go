@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline"
+)
+
+// isSelfTest reports whether the payload is a {"self-test": true} event.
+func isSelfTest(payload json.RawMessage) bool {
+	var ev struct {
+		SelfTest bool `json:"self-test"`
+	}
+	return json.Unmarshal(payload, &ev) == nil && ev.SelfTest
+}
+
+// selfTestReport is the result of a self-test invocation. OK is false if any
+// check failed.
+type selfTestReport struct {
+	OK     bool            `json:"ok"`
+	Checks []selfTestCheck `json:"checks"`
+}
+
+type selfTestCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// selfTest exercises the function's credentials without posting anything: it
+// lists a pipeline to check the AWS permissions and asks GitHub for the
+// token's rate limit to check the GitHub credentials, be it a token, a secret
+// or a GitHub App. Failed checks are logged and reported rather than failing
+// the invocation, so that the report reaches the caller.
+func selfTest(ctx context.Context, cp codepipeline.ListPipelinesAPIClient) selfTestReport {
+	report := selfTestReport{OK: true}
+	run := func(name string, check func() (string, error)) {
+		detail, err := check()
+		c := selfTestCheck{Name: name, OK: err == nil, Detail: detail}
+		if err != nil {
+			err = redactError(err)
+			c.Error = err.Error()
+			report.OK = false
+			logger(ctx).Error("self-test failed", "check", name, "error", err)
+		}
+		report.Checks = append(report.Checks, c)
+	}
+
+	run("codepipeline", func() (string, error) {
+		res, err := cp.ListPipelines(ctx, &codepipeline.ListPipelinesInput{MaxResults: aws.Int32(1)})
+		if err != nil {
+			return "", fmt.Errorf("failed to list pipelines: %w", err)
+		}
+		if len(res.Pipelines) == 0 {
+			return "no pipelines", nil
+		}
+		return "", nil
+	})
+	run("github", func() (string, error) {
+		var ev event
+		if err := ev.resolveToken(ctx, nil); err != nil {
+			return "", err
+		}
+		if ev.GithubToken == "" {
+			return "", errors.New("no GitHub credentials configured")
+		}
+		var limits struct {
+			Rate struct {
+				Limit     int `json:"limit"`
+				Remaining int `json:"remaining"`
+			} `json:"rate"`
+		}
+		if err := getJSON(ctx, githubAPIBaseURL(ctx)+"/rate_limit", ev.GithubToken, &limits); err != nil {
+			return "", fmt.Errorf("failed to get rate limit: %w", err)
+		}
+		return fmt.Sprintf("%d of %d requests remaining", limits.Rate.Remaining, limits.Rate.Limit), nil
+	})
+	return report
+}
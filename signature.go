@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// webhookSecrets returns the secrets deliveries may be signed with: the
+// Secrets Manager secret named by WEBHOOK_SECRET_ARN, or WEBHOOK_SECRET. The
+// value may hold several secrets, one per line, so that the webhook's secret
+// can be rotated without rejecting deliveries.
+func webhookSecrets(ctx context.Context) ([]string, error) {
+	v := os.Getenv("WEBHOOK_SECRET")
+	if arn := os.Getenv("WEBHOOK_SECRET_ARN"); arn != "" {
+		var err error
+		if v, err = secretString(ctx, arn); err != nil {
+			return nil, fmt.Errorf("failed to read webhook secret from secret %s: %w", arn, err)
+		}
+	}
+	var secrets []string
+	for _, s := range strings.Split(v, "\n") {
+		if s = strings.TrimSpace(s); s != "" {
+			secrets = append(secrets, s)
+		}
+	}
+	if len(secrets) == 0 {
+		return nil, errors.New("WEBHOOK_SECRET_ARN or WEBHOOK_SECRET must be set to receive webhooks")
+	}
+	return secrets, nil
+}
+
+// verifySignature checks the delivery's X-Hub-Signature-256 header, an HMAC
+// SHA-256 of the body, against the webhook secrets.
+func verifySignature(ctx context.Context, headers map[string]string, body []byte) error {
+	secrets, err := webhookSecrets(ctx)
+	if err != nil {
+		return err
+	}
+	sig, ok := strings.CutPrefix(headers["x-hub-signature-256"], "sha256=")
+	if !ok {
+		return errors.New("missing X-Hub-Signature-256 header")
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return errors.New("malformed X-Hub-Signature-256 header")
+	}
+	for _, secret := range secrets {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		if hmac.Equal(got, mac.Sum(nil)) {
+			return nil
+		}
+	}
+	return errors.New("signature mismatch")
+}
+
+// reject logs why a delivery was rejected, with what's known about its
+// sender.
+func reject(ctx context.Context, req events.LambdaFunctionURLRequest, reason string, err error) {
+	logger(ctx).Warn("rejected webhook", "reason", reason, "error", err,
+		"source-ip", req.RequestContext.HTTP.SourceIP,
+		"user-agent", req.RequestContext.HTTP.UserAgent,
+		"hook-id", req.Headers["x-github-hook-id"])
+}
+
+// Deliveries handled by this container, for when there's no
+// IDEMPOTENCY_TABLE.
+var deliveries = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: map[string]time.Time{}}
+
+// claimDelivery records that the delivery is being handled, so that replays
+// of it are rejected. Deliveries are recorded in IDEMPOTENCY_TABLE, if set,
+// otherwise only within this container. The returned function must be called
+// once the delivery was handled: with false if that failed, which forgets it
+// again so that GitHub's redelivery is handled.
+func claimDelivery(ctx context.Context, id string) (bool, func(handled bool), error) {
+	if os.Getenv("IDEMPOTENCY_TABLE") != "" {
+		return claimState(ctx, "webhook/"+id, "received")
+	}
+
+	deliveries.Lock()
+	defer deliveries.Unlock()
+	for d, t := range deliveries.m {
+		if time.Since(t) > idempotencyTTL {
+			delete(deliveries.m, d)
+		}
+	}
+	if _, ok := deliveries.m[id]; ok {
+		return false, nil, nil
+	}
+	deliveries.m[id] = time.Now()
+	return true, func(handled bool) {
+		if handled {
+			return
+		}
+		deliveries.Lock()
+		defer deliveries.Unlock()
+		delete(deliveries.m, id)
+	}, nil
+}
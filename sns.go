@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+// statusTransition is the record published to SNS_TOPIC_ARN.
+type statusTransition struct {
+	Pipeline    string    `json:"pipeline"`
+	ExecutionID string    `json:"execution-id"`
+	Repository  string    `json:"repository"`
+	Commit      string    `json:"commit"`
+	Context     string    `json:"context"`
+	State       string    `json:"state"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// publishTransition publishes a posted status to the SNS topic named by
+// SNS_TOPIC_ARN, if set. Like the EventBridge event, it is informational, so
+// failures are logged instead of returned.
+func publishTransition(ctx context.Context, ev event, repo, rev string, payload ghReqPayload) {
+	topic := os.Getenv("SNS_TOPIC_ARN")
+	if topic == "" {
+		return
+	}
+	b, err := json.Marshal(statusTransition{
+		Pipeline:    ev.Pipeline,
+		ExecutionID: ev.ExecutionID,
+		Repository:  repo,
+		Commit:      rev,
+		Context:     payload.Context,
+		State:       payload.State,
+		Timestamp:   time.Now().UTC(),
+	})
+	if err != nil {
+		logger(ctx).Warn("failed to encode status transition", "error", err)
+		return
+	}
+	_, err = snsClient.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(topic),
+		Message:  aws.String(string(b)),
+	})
+	if err != nil {
+		logger(ctx).Warn("failed to publish status transition", "topic", topic, "error", err)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+)
+
+// source is a revision of a repository the execution is building.
+type source struct {
+	artifact string
+	rev      string
+	url      *url.URL
+	repo     string
+}
+
+// errNoGitSources is returned if none of the execution's artifacts come from
+// a Git repository, e.g. because the pipeline only has S3 and ECR sources.
+// There's nothing to report on then.
+var errNoGitSources = errors.New("no artifact comes from a Git repository")
+
+// Kinds of artifact revisions.
+const (
+	artifactGit = "Git"
+	artifactS3  = "S3"
+	artifactECR = "ECR"
+)
+
+// artifactKind tells S3 and ECR artifacts, whose revisions are object
+// versions and image digests, from the Git commits of other sources.
+func artifactKind(a *types.ArtifactRevision) string {
+	if strings.HasPrefix(aws.ToString(a.RevisionId), "sha256:") {
+		return artifactECR
+	}
+	u, err := url.Parse(aws.ToString(a.RevisionUrl))
+	switch {
+	case err != nil:
+		return artifactGit
+	case strings.HasPrefix(u.Path, "/ecr/"):
+		return artifactECR
+	case strings.HasPrefix(u.Path, "/s3/"):
+		return artifactS3
+	}
+	return artifactGit
+}
+
+// findSources returns the sources to report on. By default that's the
+// artifact named SourceArtifact, see findSourceArtifact, or all Git artifacts
+// if it's missing or doesn't come from Git. SOURCE_ARTIFACTS instead selects
+// all artifacts whose name matches one of its comma-separated patterns, e.g.
+// "*" or "Source*,Config"; artifacts whose revision doesn't resolve to a
+// repository are ignored then. S3 and ECR artifacts are always ignored.
+func findSources(ctx context.Context, artis []types.ArtifactRevision, decl *pipelineDeclaration) (
+	[]source, error) {
+	filter := os.Getenv("SOURCE_ARTIFACTS")
+	if filter == "" {
+		a, err := findSourceArtifact(ctx, artis)
+		if err != nil && !errors.Is(err, errMissingSourceArtifact) {
+			return nil, err
+		}
+		if err == nil && artifactKind(a) == artifactGit {
+			s, err := newSource(ctx, a, decl)
+			if err != nil {
+				return nil, err
+			}
+			return []source{s}, nil
+		}
+		logger(ctx).Info("SourceArtifact is missing or doesn't come from Git, " +
+			"using all Git artifacts")
+		filter = "*"
+	}
+
+	patterns := strings.Split(filter, ",")
+	for i, p := range patterns {
+		patterns[i] = strings.TrimSpace(p)
+		if _, err := path.Match(patterns[i], ""); err != nil {
+			return nil, fmt.Errorf("invalid SOURCE_ARTIFACTS pattern %q: %w", p, err)
+		}
+	}
+	var sources []source
+	seen := map[string]bool{}
+	git := 0
+	for i := range artis {
+		a := &artis[i]
+		name := aws.ToString(a.Name)
+		if !matchesAny(patterns, name) {
+			continue
+		}
+		if kind := artifactKind(a); kind != artifactGit {
+			logger(ctx).Info("ignoring artifact", "artifact", name, "kind", kind)
+			continue
+		}
+		git++
+		s, err := newSource(ctx, a, decl)
+		if err != nil {
+			logger(ctx).Info("ignoring artifact", "artifact", name, "error", err)
+			continue
+		}
+		if key := s.repo + "@" + s.rev; !seen[key] {
+			seen[key] = true
+			sources = append(sources, s)
+		}
+	}
+	if git == 0 {
+		return nil, errNoGitSources
+	}
+	if len(sources) == 0 {
+		return nil, errors.New("no Git artifact refers to a supported repository")
+	}
+	return sources, nil
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// newSource resolves the repository of the artifact from its revision URL
+// or, if that fails, from the pipeline's declaration.
+func newSource(ctx context.Context, a *types.ArtifactRevision, decl *pipelineDeclaration) (
+	source, error) {
+	s := source{artifact: aws.ToString(a.Name), rev: aws.ToString(a.RevisionId)}
+	u, err := revisionRepoURL(aws.ToString(a.RevisionUrl))
+	if err != nil {
+		fallback, ferr := decl.sourceURL(ctx, s.artifact, s.rev)
+		if ferr != nil {
+			logger(ctx).Info("failed to resolve repository from the pipeline's declaration",
+				"artifact", s.artifact, "error", ferr)
+			return s, err
+		}
+		logger(ctx).Warn("resolved repository from the pipeline's declaration",
+			"artifact", s.artifact, "error", err)
+		u = fallback
+	}
+	s.url = u
+	s.repo, err = extractRepoName(u)
+	if err != nil {
+		return s, fmt.Errorf("failed to extract repo name from artifact url %v: %w", u, err)
+	}
+	logger(ctx).Info("source", "artifact", s.artifact, "repository", s.repo, "commit", s.rev,
+		"revision-url", u.String())
+	return s, nil
+}
+
+// revisionRepoURL parses the revision URL and makes sure it refers to a
+// known repository.
+func revisionRepoURL(rawURL string) (*url.URL, error) {
+	u, err := parseRevisionURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := extractRepoName(u); err != nil {
+		return nil, fmt.Errorf("failed to extract repo name from artifact url %v: %w", u, err)
+	}
+	return u, nil
+}
+
+// pipelineDeclaration looks up the pipeline's declaration once it's needed,
+// at most once per invocation.
+type pipelineDeclaration struct {
+	cp       codepipelineAPI
+	pipeline string
+	// region is the pipeline's, whose console the URLs of connections and
+	// CodeCommit repositories point to, see event.pipelineRegion.
+	region string
+	decl   *types.PipelineDeclaration
+	err    error
+}
+
+// sourceURL returns a URL carrying the repository configured in the source
+// action producing the artifact, for use in case its revision URL can't be
+// used. It has the same shape as the revision URLs of the action's provider.
+func (d *pipelineDeclaration) sourceURL(ctx context.Context, artifact, rev string) (
+	*url.URL, error) {
+	if d.decl == nil && d.err == nil {
+		res, err := d.cp.GetPipeline(ctx, &codepipeline.GetPipelineInput{
+			Name: aws.String(d.pipeline),
+		})
+		if err != nil {
+			d.err = fmt.Errorf("failed to get pipeline: %w", err)
+		} else {
+			d.decl = res.Pipeline
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	for _, st := range d.decl.Stages {
+		for _, a := range st.Actions {
+			if a.ActionTypeId == nil || a.ActionTypeId.Category != types.ActionCategorySource ||
+				!producesArtifact(a, artifact) {
+				continue
+			}
+			c := a.Configuration
+			switch aws.ToString(a.ActionTypeId.Provider) {
+			case "CodeStarSourceConnection":
+				q := url.Values{}
+				q.Set("connectionArn", c["ConnectionArn"])
+				q.Set("FullRepositoryId", c["FullRepositoryId"])
+				q.Set("Commit", rev)
+				return &url.URL{
+					Scheme:   "https",
+					Host:     d.region + "." + consoleDomain(d.region),
+					Path:     "/codesuite/settings/connections/redirect",
+					RawQuery: q.Encode(),
+				}, nil
+			case "CodeCommit":
+				if c["RepositoryName"] == "" {
+					return nil, fmt.Errorf("source action %s has no RepositoryName",
+						aws.ToString(a.Name))
+				}
+				return codecommitURL(d.region, c["RepositoryName"], rev), nil
+			case "GitHub":
+				// The legacy GitHub action, configured with owner and
+				// repository name.
+				if c["Owner"] == "" || c["Repo"] == "" {
+					return nil, fmt.Errorf("source action %s has no Owner or Repo",
+						aws.ToString(a.Name))
+				}
+				return &url.URL{
+					Scheme: "https",
+					Host:   "github.com",
+					Path:   fmt.Sprintf("/%s/%s/commit/%s", c["Owner"], c["Repo"], rev),
+				}, nil
+			}
+			return nil, fmt.Errorf("source action %s has unsupported provider %s",
+				aws.ToString(a.Name), aws.ToString(a.ActionTypeId.Provider))
+		}
+	}
+	return nil, fmt.Errorf("no source action produces artifact %s", artifact)
+}
+
+func producesArtifact(a types.ActionDeclaration, artifact string) bool {
+	for _, o := range a.OutputArtifacts {
+		if aws.ToString(o.Name) == artifact {
+			return true
+		}
+	}
+	return false
+}
+
+// errArtifactsPending is returned if an execution in progress still has no
+// artifact revisions after awaitArtifacts waited for them. Its event is
+// retried to report on the execution once they're known.
+var errArtifactsPending = errors.New("execution has no artifact revisions yet")
+
+// Bounds of awaitArtifacts.
+const (
+	artifactPollDelay = 500 * time.Millisecond
+	artifactWait      = 10 * time.Second
+	// artifactMargin is left of the invocation's time to post statuses.
+	artifactMargin = 3 * time.Second
+)
+
+// awaitArtifacts polls the execution, with exponential backoff for up to
+// artifactWait, while it's in progress but its artifact revisions aren't
+// known yet, as happens for events sent right after it started. It stops
+// early so that the invocation can finish before its deadline.
+func awaitArtifacts(ctx context.Context, cp codepipelineAPI, ev event,
+	ex *types.PipelineExecution) (*types.PipelineExecution, error) {
+	deadline := time.Now().Add(artifactWait)
+	if d, ok := ctx.Deadline(); ok && d.Add(-artifactMargin).Before(deadline) {
+		deadline = d.Add(-artifactMargin)
+	}
+	for delay := artifactPollDelay; len(ex.ArtifactRevisions) == 0 &&
+		ex.Status == types.PipelineExecutionStatusInProgress; delay *= 2 {
+		if time.Now().Add(delay).After(deadline) {
+			return nil, errArtifactsPending
+		}
+		logger(ctx).Info("waiting for artifact revisions", "delay", delay)
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+		res, err := cp.GetPipelineExecution(ctx, &codepipeline.GetPipelineExecutionInput{
+			PipelineExecutionId: aws.String(ev.ExecutionID),
+			PipelineName:        aws.String(ev.Pipeline),
+		})
+		if err != nil {
+			return nil, err
+		}
+		ex = res.PipelineExecution
+	}
+	return ex, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+)
+
+func TestFindSources(t *testing.T) {
+	tests := []struct {
+		fixture string
+		// want holds artifact, repository and commit of each source.
+		want [][3]string
+	}{
+		{"github-v1", [][3]string{
+			{"SourceArtifact", "octo-org/hello-world", "6dcb09b5b57875f334f61aebed695e2e4193db5e"},
+		}},
+		{"connection", [][3]string{
+			{"SourceArtifact", "octo-org/hello-world", "7638417db6d59f3c431d3e1f261cc637155684cd"},
+		}},
+		{"multi-artifact", [][3]string{
+			{"App", "octo-org/hello-world", "a9b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0"},
+			{"Config", "octo-org/hello-world-config", "0f1e2d3c4b5a69788796a5b4c3d2e1f0a9b8c7d6"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			ex := loadExecution(t, tt.fixture)
+			cp := newFakeCodePipeline(ex)
+			sources, err := findSources(context.Background(), ex.ArtifactRevisions,
+				&pipelineDeclaration{cp: cp, pipeline: "hello-world", region: "eu-west-1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got [][3]string
+			for _, s := range sources {
+				got = append(got, [3]string{s.artifact, s.repo, s.rev})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findSources() = %v, want %v", got, tt.want)
+			}
+			if len(cp.calls) > 0 {
+				t.Errorf("looked up %v, but the revision URLs name the repositories", cp.calls)
+			}
+		})
+	}
+}
+
+// TestSourceURLRegion makes sure the URLs built from the declaration point to
+// the console of the pipeline's region rather than the function's.
+func TestSourceURLRegion(t *testing.T) {
+	cp := newFakeCodePipeline()
+	cp.pipeline = &types.PipelineDeclaration{Stages: []types.StageDeclaration{{
+		Name: aws.String("Source"),
+		Actions: []types.ActionDeclaration{{
+			Name: aws.String("App"),
+			ActionTypeId: &types.ActionTypeId{
+				Category: types.ActionCategorySource,
+				Provider: aws.String("CodeStarSourceConnection"),
+			},
+			Configuration: map[string]string{
+				"ConnectionArn":    testConnectionARN,
+				"FullRepositoryId": "octo-org/hello-world",
+			},
+			OutputArtifacts: []types.OutputArtifact{{Name: aws.String("App")}},
+		}, {
+			Name: aws.String("Infra"),
+			ActionTypeId: &types.ActionTypeId{
+				Category: types.ActionCategorySource,
+				Provider: aws.String("CodeCommit"),
+			},
+			Configuration:   map[string]string{"RepositoryName": "infra"},
+			OutputArtifacts: []types.OutputArtifact{{Name: aws.String("Infra")}},
+		}},
+	}}}
+	d := &pipelineDeclaration{cp: cp, pipeline: "hello-world", region: "us-west-2"}
+	for _, artifact := range []string{"App", "Infra"} {
+		u, err := d.sourceURL(context.Background(), artifact, "7638417db6d59f3c431d3e1f261cc637155684cd")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Host != "us-west-2.console.aws.amazon.com" {
+			t.Errorf("%s: URL %s isn't in the pipeline's region", artifact, u)
+		}
+	}
+}
+
+// TestFindSourcesDuplicateSourceArtifact names both Git artifacts of the
+// multi-artifact execution SourceArtifact.
+func TestFindSourcesDuplicateSourceArtifact(t *testing.T) {
+	tests := []struct {
+		policy string
+		// repo is the repository of the source found, err a substring of
+		// the error otherwise.
+		repo, err string
+	}{
+		{"", "octo-org/hello-world", ""},
+		{"first", "octo-org/hello-world", ""},
+		{"last", "octo-org/hello-world-config", ""},
+		{"error", "", "2 artifacts named SourceArtifact"},
+		{"newest", "", `invalid DUPLICATE_ARTIFACT_POLICY "newest"`},
+	}
+	for _, tt := range tests {
+		t.Run("DUPLICATE_ARTIFACT_POLICY="+tt.policy, func(t *testing.T) {
+			t.Setenv("DUPLICATE_ARTIFACT_POLICY", tt.policy)
+			ex := loadExecution(t, "multi-artifact")
+			artis := append([]types.ArtifactRevision(nil), ex.ArtifactRevisions...)
+			artis[0].Name = aws.String("SourceArtifact")
+			artis[1].Name = aws.String("SourceArtifact")
+
+			sources, err := findSources(context.Background(), artis,
+				&pipelineDeclaration{cp: newFakeCodePipeline(ex), pipeline: "hello-world", region: "eu-west-1"})
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("findSources() = %v, %v, want error %q", sources, err, tt.err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case len(sources) != 1 || sources[0].repo != tt.repo:
+				t.Errorf("findSources() = %v, want %s only", sources, tt.repo)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+)
+
+type stageResult struct {
+	Name    string
+	Started time.Time
+	// Status is the worst status of the stage's actions.
+	Status types.ActionExecutionStatus
+	// Failed is the action that failed the stage, if any.
+	Failed *types.ActionExecutionDetail
+}
+
+// stageResults summarizes the action executions of an execution per stage,
+// in the order the stages started.
+func stageResults(actions []types.ActionExecutionDetail) []*stageResult {
+	stages := map[string]*stageResult{}
+	seen := map[string]bool{}
+	// Actions come newest first; only the latest run of an action counts.
+	for i := range actions {
+		a := &actions[i]
+		name := aws.ToString(a.StageName)
+		k := name + "/" + aws.ToString(a.ActionName)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		s := stages[name]
+		if s == nil {
+			s = &stageResult{Name: name, Started: aws.ToTime(a.StartTime)}
+			stages[name] = s
+		}
+		if t := aws.ToTime(a.StartTime); t.Before(s.Started) {
+			s.Started = t
+		}
+		s.Status = worseActionStatus(s.Status, a.Status)
+		if a.Status == types.ActionExecutionStatusFailed && s.Failed == nil {
+			s.Failed = a
+		}
+	}
+	sorted := make([]*stageResult, 0, len(stages))
+	for _, s := range stages {
+		sorted = append(sorted, s)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Started.Before(sorted[j].Started) })
+	return sorted
+}
+
+// stageEventStatuses maps the states of stage and action events to the
+// status of the stage's actions. Stages that were stopped or cancelled count
+// as abandoned.
+var stageEventStatuses = map[string]types.ActionExecutionStatus{
+	"ABANDONED":  types.ActionExecutionStatusAbandoned,
+	"STARTED":    types.ActionExecutionStatusInProgress,
+	"RESUMED":    types.ActionExecutionStatusInProgress,
+	"SUCCEEDED":  types.ActionExecutionStatusSucceeded,
+	"FAILED":     types.ActionExecutionStatusFailed,
+	"STOPPING":   types.ActionExecutionStatusAbandoned,
+	"STOPPED":    types.ActionExecutionStatusAbandoned,
+	"CANCELED":   types.ActionExecutionStatusAbandoned,
+	"SUPERSEDED": types.ActionExecutionStatusAbandoned,
+}
+
+// eventStage returns the result of the stage a stage event is about. Its
+// status is taken from the event, as the action executions may lag behind.
+func eventStage(ev event, actions []types.ActionExecutionDetail) *stageResult {
+	s := &stageResult{Name: ev.Stage}
+	for _, r := range stageResults(actions) {
+		if r.Name == ev.Stage {
+			s = r
+		}
+	}
+	s.Status = stageEventStatuses[ev.StageState]
+	return s
+}
+
+var actionStatusRank = map[types.ActionExecutionStatus]int{
+	types.ActionExecutionStatusSucceeded:  1,
+	types.ActionExecutionStatusAbandoned:  2,
+	types.ActionExecutionStatusInProgress: 3,
+	types.ActionExecutionStatusFailed:     4,
+}
+
+// worseActionStatus combines the statuses of two actions of a stage.
+func worseActionStatus(a, b types.ActionExecutionStatus) types.ActionExecutionStatus {
+	if actionStatusRank[b] > actionStatusRank[a] {
+		return b
+	}
+	return a
+}
+
+// stagePayloads returns a status per stage of the execution, with contexts
+// like codepipeline/build. Failed stages link to the failed action's
+// external execution, e.g. the CodeBuild build, if there is one, otherwise
+// stages link to what link returns for the stage and its failed action.
+// Abandoned stages are left out.
+func stagePayloads(stages []*stageResult, link func(stage, action string) string) []ghReqPayload {
+	var payloads []ghReqPayload
+	for _, s := range stages {
+		var failed string
+		if s.Failed != nil {
+			failed = aws.ToString(s.Failed.ActionName)
+		}
+		p := ghReqPayload{
+			Context:   "codepipeline/" + strings.ToLower(strings.Replace(s.Name, " ", "-", -1)),
+			TargetURL: link(s.Name, failed),
+			Stage:     s.Name,
+		}
+		switch s.Status {
+		case types.ActionExecutionStatusInProgress:
+			p.State = "pending"
+		case types.ActionExecutionStatusSucceeded:
+			p.State = "success"
+		case types.ActionExecutionStatusFailed:
+			p.State = "failure"
+			if s.Failed == nil {
+				p.Description = "Failed"
+				break
+			}
+			p.Description = truncateDescription(fmt.Sprintf("%s failed", failed))
+			if o := s.Failed.Output; o != nil && o.ExecutionResult != nil &&
+				aws.ToString(o.ExecutionResult.ExternalExecutionUrl) != "" {
+				p.TargetURL = aws.ToString(o.ExecutionResult.ExternalExecutionUrl)
+			}
+		default:
+			continue
+		}
+		payloads = append(payloads, p)
+	}
+	return payloads
+}
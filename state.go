@@ -0,0 +1,40 @@
+package main
+
+import "sync"
+
+// Terminal states reported by this container, keyed by executionKey. Lambda
+// keeps the map across warm invocations; a cold start begins afresh.
+var reported = struct {
+	sync.Mutex
+	m map[string]string
+}{m: map[string]string{}}
+
+// Bound the memory a long-lived container spends on reported states.
+const maxReported = 1000
+
+// executionKey identifies an execution. Execution IDs are only unique per
+// pipeline.
+func executionKey(pipeline, executionID string) string {
+	return pipeline + "/" + executionID
+}
+
+func isTerminal(ghStatus string) bool {
+	return ghStatus != "pending"
+}
+
+// reportedTerminalState returns the terminal state reported for the execution
+// by this container, if any.
+func reportedTerminalState(key string) string {
+	reported.Lock()
+	defer reported.Unlock()
+	return reported.m[key]
+}
+
+func recordTerminalState(key, ghStatus string) {
+	reported.Lock()
+	defer reported.Unlock()
+	if len(reported.m) >= maxReported {
+		reported.m = map[string]string{}
+	}
+	reported.m[key] = ghStatus
+}
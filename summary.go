@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// summaryOutput receives the summaries, see summary.write.
+var summaryOutput io.Writer = os.Stderr
+
+// summary describes what an invocation did. If JSON_SUMMARY is "true" it is
+// logged as a single JSON line at the end of each invocation, for integration
+// tests and other tooling. Its fields must never carry the GitHub token.
+type summary struct {
+	Pipeline       string          `json:"pipeline"`
+	ExecutionID    string          `json:"execution-id"`
+	PipelineStatus string          `json:"pipeline-status,omitempty"`
+	Provider       string          `json:"provider,omitempty"`
+	Repository     string          `json:"repository,omitempty"`
+	State          string          `json:"state,omitempty"`
+	Context        string          `json:"context,omitempty"`
+	Description    string          `json:"description,omitempty"`
+	TargetURL      string          `json:"target-url,omitempty"`
+	Commits        []commitSummary `json:"commits"`
+	// Outcome is "completed", "skipped" or "failed".
+	Outcome string `json:"outcome"`
+	Reason  string `json:"reason,omitempty"`
+	Error   string `json:"error,omitempty"`
+
+	logger *slog.Logger
+	// failedPosts are the statuses that couldn't be set, for the failure
+	// record.
+	failedPosts []failedPost
+}
+
+type commitSummary struct {
+	Repository string `json:"repository"`
+	Commit     string `json:"commit"`
+	Context    string `json:"context"`
+	// Action is "posted", "skipped" or "failed".
+	Action    string `json:"action"`
+	Reason    string `json:"reason,omitempty"`
+	StatusURL string `json:"status-url,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// skip marks the whole invocation as skipped.
+func (s *summary) skip(reason string) {
+	s.Outcome = "skipped"
+	s.Reason = reason
+	s.logger.Info("not setting status", "reason", reason)
+}
+
+func (s *summary) write(err error) {
+	if os.Getenv("JSON_SUMMARY") != "true" {
+		return
+	}
+	if s.Commits == nil {
+		s.Commits = []commitSummary{}
+	}
+	if err != nil {
+		s.Outcome = "failed"
+		s.Error = err.Error()
+	} else if s.Outcome == "" {
+		s.Outcome = "completed"
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		s.logger.Warn("failed to encode summary", "error", err)
+		return
+	}
+	// The summary is a line of its own rather than a field of a log line, so
+	// that tooling can parse it as is.
+	fmt.Fprintf(summaryOutput, "%s\n", b)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// TestSummarySchema pins the JSON summary of a successful post, which
+// integration tests and other tooling parse.
+func TestSummarySchema(t *testing.T) {
+	t.Setenv("JSON_SUMMARY", "true")
+	var out bytes.Buffer
+	prev := summaryOutput
+	summaryOutput = &out
+	t.Cleanup(func() { summaryOutput = prev })
+
+	const id = "50000000-0000-4000-8000-5c4e3a000001"
+	ex := withExecutionID(loadExecution(t, "github-v1"), id)
+	h, gh := newTestHandler(t, newFakeCodePipeline(ex))
+	err := h.Handle(context.Background(), event{Pipeline: "hello-world",
+		ExecutionID: aws.ToString(ex.PipelineExecutionId)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("summary %q isn't a JSON line: %v", out.String(), err)
+	}
+	const sha = "6dcb09b5b57875f334f61aebed695e2e4193db5e"
+	want := map[string]any{
+		"pipeline":        "hello-world",
+		"execution-id":    id,
+		"pipeline-status": "Succeeded",
+		"provider":        "github",
+		"repository":      "octo-org/hello-world",
+		"state":           "success",
+		"context":         defaultContext,
+		"target-url":      executionURL(id),
+		"commits": []any{map[string]any{
+			"repository": "octo-org/hello-world",
+			"commit":     sha,
+			"context":    defaultContext,
+			"action":     "posted",
+			"status-url": gh.URL + "/repos/octo-org/hello-world/statuses/" + sha,
+		}},
+		"outcome": "completed",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("summary = %v\nwant %v", got, want)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// suppressionRules skip statuses on commits of automation, see
+// suppressionReason.
+type suppressionRules struct {
+	branches []string
+	markers  []string
+	authors  map[string]bool
+	bots     bool
+}
+
+// configuredSuppression returns the rules configured by SKIP_BRANCHES,
+// SKIP_MESSAGE_MARKERS, SKIP_AUTHORS and SKIP_BOT_AUTHORS, or nil if there
+// are none.
+func configuredSuppression() (*suppressionRules, error) {
+	r := &suppressionRules{
+		branches: splitList(os.Getenv("SKIP_BRANCHES")),
+		markers:  splitList(os.Getenv("SKIP_MESSAGE_MARKERS")),
+		bots:     os.Getenv("SKIP_BOT_AUTHORS") == "true",
+	}
+	for _, p := range r.branches {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid SKIP_BRANCHES pattern %q: %w", p, err)
+		}
+	}
+	if authors := splitList(os.Getenv("SKIP_AUTHORS")); len(authors) > 0 {
+		r.authors = map[string]bool{}
+		for _, a := range authors {
+			r.authors[strings.ToLower(a)] = true
+		}
+	}
+	if len(r.branches) == 0 && len(r.markers) == 0 && r.authors == nil && !r.bots {
+		return nil, nil
+	}
+	return r, nil
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// suppressionReason returns why no status should be posted to the GitHub
+// commit, or an empty string if it should be: its message contains one of
+// the markers, e.g. "[skip status]", its author is one of the logins or a
+// bot, or each branch whose head it is matches one of the branch patterns,
+// e.g. "renovate/*".
+func (r *suppressionRules) suppressionReason(ctx context.Context, repo, rev, token string) (
+	string, error) {
+	if len(r.markers) > 0 || r.authors != nil || r.bots {
+		c, err := getCommit(ctx, repo, rev, token)
+		if err != nil {
+			return "", fmt.Errorf("failed to look up commit: %w", err)
+		}
+		for _, m := range r.markers {
+			if strings.Contains(c.Commit.Message, m) {
+				return fmt.Sprintf("Commit message contains %q", m), nil
+			}
+		}
+		if c.Author != nil {
+			if r.authors[strings.ToLower(c.Author.Login)] {
+				return fmt.Sprintf("Commit is authored by %s", c.Author.Login), nil
+			}
+			if r.bots && c.Author.Type == "Bot" {
+				return fmt.Sprintf("Commit is authored by bot %s", c.Author.Login), nil
+			}
+		}
+	}
+
+	if len(r.branches) > 0 {
+		var branches []struct {
+			Name string `json:"name"`
+		}
+		err := getJSON(ctx, fmt.Sprintf("%s/repos/%s/commits/%s/branches-where-head",
+			githubAPIBaseURL(ctx), repo, rev), token, &branches)
+		if err != nil {
+			return "", fmt.Errorf("failed to look up branches: %w", err)
+		}
+		skip := len(branches) > 0
+		for _, b := range branches {
+			skip = skip && matchesAny(r.branches, b.Name)
+		}
+		if skip {
+			return fmt.Sprintf("Commit is on branch %s", branches[0].Name), nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// syncPath is the path of the HTTP endpoint setting the statuses of an
+// execution on demand, possibly after the stage of an API Gateway API.
+// Requests to other paths are GitHub webhook deliveries.
+const syncPath = "/sync"
+
+func isSyncRequest(req events.LambdaFunctionURLRequest) bool {
+	return strings.HasSuffix(strings.TrimSuffix(req.RequestContext.HTTP.Path, "/"), syncPath)
+}
+
+// syncAuthorized reports whether the request may set statuses: it was signed
+// with AWS credentials, if the function URL or API uses IAM authorization,
+// or it carries the bearer token of SYNC_API_TOKEN or of the Secrets Manager
+// secret named by SYNC_API_TOKEN_SECRET_ARN.
+func syncAuthorized(ctx context.Context, req events.LambdaFunctionURLRequest) (bool, error) {
+	if a := req.RequestContext.Authorizer; a != nil && a.IAM != nil {
+		return true, nil
+	}
+	token := os.Getenv("SYNC_API_TOKEN")
+	if arn := os.Getenv("SYNC_API_TOKEN_SECRET_ARN"); arn != "" {
+		var err error
+		if token, err = secretString(ctx, arn); err != nil {
+			return false, fmt.Errorf("failed to read sync API token from secret %s: %w", arn, err)
+		}
+	}
+	if token == "" {
+		return false, nil
+	}
+	addSecret(token)
+	got, ok := strings.CutPrefix(req.Headers["authorization"], "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1, nil
+}
+
+// HandleSyncRequest sets the statuses of the execution named by the event in
+// the request's body, like an event from EventBridge, and responds with the
+// outcome as JSON.
+func (h *Handler) HandleSyncRequest(ctx context.Context, req events.LambdaFunctionURLRequest) (
+	events.LambdaFunctionURLResponse, error) {
+	respond := func(code int, err error) (events.LambdaFunctionURLResponse, error) {
+		res := struct {
+			OK    bool   `json:"ok"`
+			Error string `json:"error,omitempty"`
+		}{OK: err == nil}
+		if err != nil {
+			res.Error = redact(err.Error())
+		}
+		b, _ := json.Marshal(res)
+		return events.LambdaFunctionURLResponse{StatusCode: code, Body: string(b) + "\n",
+			Headers: map[string]string{"Content-Type": "application/json"}}, nil
+	}
+
+	if req.RequestContext.HTTP.Method != http.MethodPost {
+		return respond(http.StatusMethodNotAllowed, errors.New("only POST is allowed"))
+	}
+	ok, err := syncAuthorized(ctx, req)
+	if err != nil {
+		logger(ctx).Error("failed to authorize sync request", "error", err)
+		return respond(http.StatusInternalServerError, errors.New("failed to authorize request"))
+	}
+	if !ok {
+		logger(ctx).Warn("rejected sync request", "source-ip", req.RequestContext.HTTP.SourceIP,
+			"user-agent", req.RequestContext.HTTP.UserAgent)
+		return respond(http.StatusUnauthorized, errors.New("unauthorized"))
+	}
+
+	body := []byte(req.Body)
+	if req.IsBase64Encoded {
+		if body, err = base64.StdEncoding.DecodeString(req.Body); err != nil {
+			return respond(http.StatusBadRequest, errors.New("invalid body"))
+		}
+	}
+	var ev event
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return respond(http.StatusBadRequest, err)
+	}
+	if err := ev.validate(); err != nil {
+		return respond(http.StatusBadRequest, err)
+	}
+	if err := h.Handle(ctx, ev); err != nil {
+		if isPermanent(err) {
+			return respond(http.StatusUnprocessableEntity, err)
+		}
+		return respond(http.StatusBadGateway, err)
+	}
+	return respond(http.StatusOK, nil)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+)
+
+const defaultTagContext = "codepipeline/release"
+
+// executionTag returns the Git tag whose push triggered the execution, or an
+// empty string if it wasn't triggered by a tag. The tag is taken from the
+// pipeline variable named by TAG_VARIABLE, if set, otherwise from the detail
+// of the trigger, which names the tag for the tag filters of V2 pipelines.
+func executionTag(ex *types.PipelineExecution) string {
+	if name := os.Getenv("TAG_VARIABLE"); name != "" {
+		if tag := executionVariable(ex, name); tag != "" {
+			return tag
+		}
+	}
+	t := ex.Trigger
+	if t == nil || t.TriggerType != types.TriggerTypeWebhookV2 {
+		return ""
+	}
+	var detail map[string]interface{}
+	if err := json.Unmarshal([]byte(aws.ToString(t.TriggerDetail)), &detail); err != nil {
+		return ""
+	}
+	for k, v := range detail {
+		if s, ok := v.(string); ok && s != "" && strings.Contains(strings.ToLower(k), "tag") {
+			return s
+		}
+	}
+	return ""
+}
+
+// tagContext is the status context of executions triggered by a tag, from
+// TAG_STATUS_CONTEXT.
+func tagContext() string {
+	if c := os.Getenv("TAG_STATUS_CONTEXT"); c != "" {
+		return c
+	}
+	return defaultTagContext
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline"
+)
+
+// tagPrefix is the prefix of the pipeline tags configuring the function,
+// e.g. github-status:context.
+const tagPrefix = "github-status:"
+
+// The tags of the pipelines seen by this container, keyed by pipeline.
+var pipelineTagCache = struct {
+	sync.Mutex
+	m map[string]cachedTags
+}{m: map[string]cachedTags{}}
+
+type cachedTags struct {
+	tags   map[string]string
+	loaded time.Time
+}
+
+// pipelineTags returns the pipeline's tags starting with tagPrefix, keyed by
+// the rest of their keys, if PIPELINE_TAGS is "true". Tags are looked up
+// again once they're older than CONFIG_TTL.
+func pipelineTags(ctx context.Context, cp codepipelineAPI, pipeline string) (
+	map[string]string, error) {
+	if os.Getenv("PIPELINE_TAGS") != "true" {
+		return nil, nil
+	}
+	ttl, err := durationEnv("CONFIG_TTL", defaultConfigTTL)
+	if err != nil {
+		return nil, err
+	}
+	pipelineTagCache.Lock()
+	defer pipelineTagCache.Unlock()
+	if c, ok := pipelineTagCache.m[pipeline]; ok && time.Since(c.loaded) < ttl {
+		return c.tags, nil
+	}
+
+	// Tags are looked up by ARN, which only GetPipeline tells.
+	p, err := cp.GetPipeline(ctx, &codepipeline.GetPipelineInput{Name: aws.String(pipeline)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pipeline: %w", err)
+	}
+	if p.Metadata == nil {
+		return nil, fmt.Errorf("pipeline %s has no ARN", pipeline)
+	}
+	tags := map[string]string{}
+	pages := codepipeline.NewListTagsForResourcePaginator(cp, &codepipeline.ListTagsForResourceInput{
+		ResourceArn: p.Metadata.PipelineArn,
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tags of pipeline %s: %w", pipeline, err)
+		}
+		for _, t := range page.Tags {
+			if k := aws.ToString(t.Key); strings.HasPrefix(k, tagPrefix) {
+				tags[strings.TrimPrefix(k, tagPrefix)] = aws.ToString(t.Value)
+			}
+		}
+	}
+	pipelineTagCache.m[pipeline] = cachedTags{tags: tags, loaded: time.Now()}
+	return tags, nil
+}
+
+// applyTags overrides the event with the pipeline's tags: github-status:context
+// sets the status context unless the event does, and
+// github-status:environment maps deploy stages to environments like
+// DEPLOY_ENVIRONMENTS, with the entries separated by spaces, as tag values
+// can't hold commas. It reports whether github-status:disabled is "true".
+func (ev *event) applyTags(tags map[string]string) (disabled bool) {
+	if ev.Context == "" {
+		ev.Context = tags["context"]
+	}
+	if v := tags["environment"]; v != "" {
+		ev.deployEnvironments = strings.Join(strings.Fields(v), ",")
+	}
+	return tags["disabled"] == "true"
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+)
+
+// targetURLData is available to target URL templates.
+type targetURLData struct {
+	Region        string
+	Partition     string
+	ConsoleDomain string
+	Pipeline      string
+	ExecutionID   string
+	// Stage and Action are the failed stage and action for the overall
+	// status, and the stage and its failed action for statuses per stage.
+	// Either may be empty.
+	Stage  string
+	Action string
+	// Tag is the Git tag that triggered the execution, if any.
+	Tag string
+	// ExecutionURL is the execution's page, the default target URL.
+	ExecutionURL string
+}
+
+// targetURLTemplate returns the template from the event or, if it has none,
+// from TARGET_URL_TEMPLATE. It returns an empty string if neither is set.
+func targetURLTemplate(ev event) string {
+	if ev.TargetURL != "" {
+		return ev.TargetURL
+	}
+	return os.Getenv("TARGET_URL_TEMPLATE")
+}
+
+// renderTargetURL renders the template, or returns the execution's page if
+// there is none.
+func renderTargetURL(tmpl string, data targetURLData) (string, error) {
+	if tmpl == "" {
+		return data.ExecutionURL, nil
+	}
+	t, err := template.New("target-url").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("invalid target URL template: %w", err)
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render target URL template: %w", err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// templateUsesActions reports whether the action executions are needed to
+// render the template.
+func templateUsesActions(tmpl string) bool {
+	return strings.Contains(tmpl, ".Stage") || strings.Contains(tmpl, ".Action")
+}
+
+// failedAction returns the stage and name of the first failed action of the
+// execution, if any.
+func failedAction(actions []types.ActionExecutionDetail) (string, string) {
+	for _, s := range stageResults(actions) {
+		if s.Failed != nil {
+			return s.Name, aws.ToString(s.Failed.ActionName)
+		}
+	}
+	return "", ""
+}
+
+// partition returns the AWS partition of the region.
+func partition(region string) string {
+	switch {
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	}
+	return "aws"
+}
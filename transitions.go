@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+)
+
+// disabledTransitionDescription detects an execution that is stuck because it
+// finished a stage whose transition into the next stage is disabled. It
+// returns an empty string if the execution isn't held up that way.
+func disabledTransitionDescription(ctx context.Context, cp codepipelineAPI,
+	pipeline, executionID string) (string, error) {
+	res, err := cp.GetPipelineState(ctx, &codepipeline.GetPipelineStateInput{
+		Name: aws.String(pipeline),
+	})
+	if err != nil {
+		return "", err
+	}
+	stages := res.StageStates
+	for i := 1; i < len(stages); i++ {
+		prev, next := stages[i-1].LatestExecution, stages[i]
+		if prev == nil || aws.ToString(prev.PipelineExecutionId) != executionID ||
+			prev.Status != types.StageExecutionStatusSucceeded {
+			continue
+		}
+		t := next.InboundTransitionState
+		if t == nil || t.Enabled {
+			continue
+		}
+		d := fmt.Sprintf("Stage transition to %s disabled", aws.ToString(next.StageName))
+		if r := aws.ToString(t.DisabledReason); r != "" {
+			d += ": " + r
+		}
+		return d, nil
+	}
+	return "", nil
+}
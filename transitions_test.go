@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+)
+
+// transitionStage returns the state of a stage whose latest execution has
+// the status.
+func transitionStage(stage, executionID string, status types.StageExecutionStatus,
+	inbound *types.TransitionState) types.StageState {
+	return types.StageState{
+		StageName: aws.String(stage),
+		LatestExecution: &types.StageExecution{
+			PipelineExecutionId: aws.String(executionID),
+			Status:              status,
+		},
+		InboundTransitionState: inbound,
+	}
+}
+
+var (
+	transitionEnabled  = &types.TransitionState{Enabled: true}
+	transitionDisabled = &types.TransitionState{}
+	transitionFrozen   = &types.TransitionState{DisabledReason: aws.String("Release freeze")}
+)
+
+func TestDisabledTransitionDescription(t *testing.T) {
+	const id = "7d000000-0000-4000-8000-000000000001"
+	succeeded := types.StageExecutionStatusSucceeded
+	tests := []struct {
+		name   string
+		stages []types.StageState
+		want   string
+	}{
+		{"disabled", []types.StageState{
+			transitionStage("Build", id, succeeded, transitionEnabled),
+			transitionStage("Deploy", "older", succeeded, transitionFrozen),
+		}, "Stage transition to Deploy disabled: Release freeze"},
+		{"disabled without reason", []types.StageState{
+			transitionStage("Build", id, succeeded, transitionEnabled),
+			transitionStage("Deploy", "older", succeeded, transitionDisabled),
+		}, "Stage transition to Deploy disabled"},
+		{"enabled", []types.StageState{
+			transitionStage("Build", id, succeeded, transitionEnabled),
+			transitionStage("Deploy", id, types.StageExecutionStatusInProgress, transitionEnabled),
+		}, ""},
+		{"still building", []types.StageState{
+			transitionStage("Build", id, types.StageExecutionStatusInProgress, transitionEnabled),
+			transitionStage("Deploy", "older", succeeded, transitionFrozen),
+		}, ""},
+		{"other execution held up", []types.StageState{
+			transitionStage("Build", "newer", succeeded, transitionEnabled),
+			transitionStage("Deploy", "older", succeeded, transitionFrozen),
+		}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp := newFakeCodePipeline()
+			cp.state = &codepipeline.GetPipelineStateOutput{StageStates: tt.stages}
+			got, err := disabledTransitionDescription(context.Background(), cp, "hello-world", id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("disabledTransitionDescription() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestHandleDisabledTransition makes sure the description of the pending
+// status says why the execution doesn't progress.
+func TestHandleDisabledTransition(t *testing.T) {
+	ex := loadExecution(t, "connection")
+	id := aws.ToString(ex.PipelineExecutionId)
+	cp := newFakeCodePipeline(ex)
+	cp.state = &codepipeline.GetPipelineStateOutput{StageStates: []types.StageState{
+		transitionStage("Source", id, types.StageExecutionStatusSucceeded, transitionEnabled),
+		transitionStage("Deploy", "older", types.StageExecutionStatusSucceeded, transitionFrozen),
+	}}
+	h, gh := newTestHandler(t, cp)
+
+	err := h.Handle(context.Background(), event{Pipeline: "hello-world", ExecutionID: id,
+		DetectDisabledTransitions: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := gh.statuses("octo-org/hello-world", "7638417db6d59f3c431d3e1f261cc637155684cd")
+	want := "Stage transition to Deploy disabled: Release freeze"
+	if len(got) != 1 || got[0]["state"] != "pending" || got[0]["description"] != want {
+		t.Errorf("statuses = %v, want pending with description %q", got, want)
+	}
+}
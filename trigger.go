@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+)
+
+// triggerType returns how the execution started, e.g. Webhook, or an empty
+// string if unknown.
+func triggerType(ex *types.PipelineExecution) string {
+	if ex.Trigger == nil {
+		return ""
+	}
+	return string(ex.Trigger.TriggerType)
+}
+
+// isManual reports whether someone started the execution, e.g. to re-run
+// a commit, rather than a change or an event.
+func isManual(ex *types.PipelineExecution) bool {
+	return ex.Trigger != nil && ex.Trigger.TriggerType == types.TriggerTypeStartPipelineExecution
+}
+
+// triggerDescription describes how the execution started, e.g. "Started
+// manually" or "Triggered by rule nightly", or returns an empty string if
+// that isn't worth mentioning. Rollbacks are described by
+// rollbackDescription.
+func triggerDescription(ex *types.PipelineExecution) string {
+	t := ex.Trigger
+	if t == nil {
+		return ""
+	}
+	// The detail is an ARN for some triggers, e.g. of the EventBridge rule.
+	detail := aws.ToString(t.TriggerDetail)
+	name := detail[strings.LastIndex(detail, "/")+1:]
+	switch t.TriggerType {
+	case types.TriggerTypeStartPipelineExecution:
+		return "Started manually"
+	case types.TriggerTypeWebhook, types.TriggerTypeWebhookV2:
+		return "Triggered by push"
+	case types.TriggerTypePollForSourceChanges:
+		return "Triggered by source change"
+	case types.TriggerTypeCloudWatchEvent:
+		// Schedules and upstream pipelines start executions through rules.
+		if strings.HasPrefix(detail, "arn:") && name != "" {
+			return "Triggered by rule " + name
+		}
+		return "Triggered by event"
+	case types.TriggerTypePutActionRevision:
+		return "Triggered by new revision"
+	case types.TriggerTypeCreatePipeline:
+		return "Started on pipeline creation"
+	}
+	return ""
+}
+
+// manualContext is the status context of manually started executions, from
+// MANUAL_STATUS_CONTEXT. It returns an empty string if they use the usual
+// context.
+func manualContext() string {
+	return os.Getenv("MANUAL_STATUS_CONTEXT")
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// validateConfig checks the whole configuration at once, so that a
+// misconfigured function fails to start with a message naming each problem,
+// instead of failing one invocation at a time. It loads the configuration
+// from Parameter Store and resolves the configured secrets, which also warms
+// their caches.
+func validateConfig(ctx context.Context) error {
+	if err := loadConfig(ctx); err != nil {
+		return err
+	}
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, name := range []string{"GITHUB_API_BASE_URL", "BITBUCKET_API_BASE_URL",
+		"GITLAB_API_BASE_URL", "GITEA_API_BASE_URL", "REVISION_BASE_URL"} {
+		if v := os.Getenv(name); v != "" {
+			if u, err := url.Parse(v); err != nil || u.Scheme == "" || u.Host == "" {
+				check(fmt.Errorf("invalid %s %q, expected an absolute URL", name, v))
+			}
+		}
+	}
+	for _, name := range []string{"TARGET_URL_TEMPLATE", "DESCRIPTION_TEMPLATE",
+		"ENVIRONMENT_URL", "ASSUME_ROLE_ARN"} {
+		if _, err := template.New(name).Parse(os.Getenv(name)); err != nil {
+			check(fmt.Errorf("invalid %s: %w", name, err))
+		}
+	}
+	for _, name := range []string{"BATCH_CONCURRENCY", "POST_CONCURRENCY",
+		"GITHUB_MAX_ATTEMPTS"} {
+		if v := os.Getenv(name); v != "" {
+			if n, err := strconv.Atoi(v); err != nil || n < 1 {
+				check(fmt.Errorf("invalid %s %q", name, v))
+			}
+		}
+	}
+	_, err := durationEnv("CONFIG_TTL", defaultConfigTTL)
+	check(err)
+	_, err = dedupWindow()
+	check(err)
+	_, err = stuckThreshold()
+	check(err)
+	_, err = durationEnv("HISTORY_RETENTION", defaultHistoryRetention)
+	check(err)
+	_, err = descriptionMaxLen()
+	check(err)
+	check(oneOf("UNKNOWN_COMMIT_BEHAVIOR", "", "skip", "error"))
+	check(oneOf("DUPLICATE_ARTIFACT_POLICY", "", "first", "last", "error"))
+	check(oneOf("CHECK_RUN_MODE", "", "per-execution", "per-commit"))
+	for _, p := range strings.Split(os.Getenv("SOURCE_ARTIFACTS"), ",") {
+		if _, err := path.Match(strings.TrimSpace(p), ""); err != nil {
+			check(fmt.Errorf("invalid SOURCE_ARTIFACTS pattern %q: %w", p, err))
+		}
+	}
+	for s := range notifyStates() {
+		switch s {
+		case "", "pending", "success", "error", "failure", "stuck":
+		default:
+			check(fmt.Errorf("invalid NOTIFY_STATES state %q", s))
+		}
+	}
+
+	_, err = stateMapping()
+	check(err)
+	for status := range interruptions {
+		_, err := interruptedBehavior(status)
+		check(err)
+	}
+	_, err = checksMode()
+	check(err)
+	check(validateRollouts())
+	_, _, err = pipelinePatterns()
+	check(err)
+	check(validateGithubAPIVersion())
+	_, err = deployEnvironments(event{})
+	check(err)
+	_, err = codecommitMirrors()
+	check(err)
+	_, err = parseMirrors("CODECATALYST_MIRRORS")
+	check(err)
+	_, err = customExtractor()
+	check(err)
+	_, err = configuredSuppression()
+	check(err)
+	_, err = configuredRoutes(ctx)
+	check(err)
+	_, err = configuredNotifiers(ctx)
+	check(err)
+	_, err = configuredJira(ctx)
+	check(err)
+
+	if arn := os.Getenv("GITHUB_TOKEN_SECRET_ARN"); arn != "" {
+		if _, err := secretString(ctx, arn); err != nil {
+			check(fmt.Errorf("failed to read GitHub token from secret %s: %w", arn, err))
+		}
+	}
+	for _, p := range tokenEnvPrefixes {
+		if os.Getenv(p+"_TOKEN_SECRET_ARN") != "" {
+			_, err := providerToken(ctx, p)
+			check(err)
+		}
+	}
+	if githubAppConfigured() {
+		if _, err := strconv.ParseInt(os.Getenv("GITHUB_APP_INSTALLATION_ID"), 10, 64); err != nil {
+			check(fmt.Errorf("invalid GITHUB_APP_INSTALLATION_ID: %w", err))
+		}
+		_, err := githubAppPrivateKey(ctx)
+		check(err)
+		if on, _ := checksMode(); on || os.Getenv("ROLLOUT_CHECKS") != "" {
+			_, err := checkRunAppID()
+			check(err)
+		}
+	}
+	if os.Getenv("WEBHOOK_SECRET_ARN") != "" || os.Getenv("WEBHOOK_SECRET") != "" {
+		_, err := webhookSecrets(ctx)
+		check(err)
+	}
+	return errors.Join(errs...)
+}
+
+// oneOf makes sure the environment variable holds one of the values.
+func oneOf(name string, values ...string) error {
+	v := os.Getenv(name)
+	for _, allowed := range values {
+		if v == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid %s %q", name, v)
+}
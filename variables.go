@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+)
+
+// Pipeline variables overriding the repository and commit of the artifacts,
+// e.g. set by a parent pipeline calling StartPipelineExecution.
+const (
+	commitVariable = "GIT_COMMIT"
+	repoVariable   = "GIT_REPO"
+)
+
+// executionVariable returns the resolved value of the execution's pipeline
+// variable, or an empty string if it isn't set.
+func executionVariable(ex *types.PipelineExecution, name string) string {
+	for _, v := range ex.Variables {
+		if aws.ToString(v.Name) == name {
+			return aws.ToString(v.ResolvedValue)
+		}
+	}
+	return ""
+}
+
+// variableSources overrides the sources with the GIT_REPO and GIT_COMMIT
+// variables of the execution. GIT_REPO is a repository URL or, for GitHub,
+// owner/repo; it replaces the sources with the single commit GIT_COMMIT. A
+// GIT_COMMIT without GIT_REPO replaces the commit of the only source. err is
+// the error findSources returned, which doesn't matter if GIT_REPO is set.
+func variableSources(ctx context.Context, ex *types.PipelineExecution, sources []source, err error) (
+	[]source, error) {
+	rev := executionVariable(ex, commitVariable)
+	repo := executionVariable(ex, repoVariable)
+	if repo == "" {
+		if rev == "" || err != nil {
+			return sources, err
+		}
+		if len(sources) != 1 {
+			return nil, fmt.Errorf("%s requires %s with several source artifacts",
+				commitVariable, repoVariable)
+		}
+		logger(ctx).Info("commit set by pipeline variable", "commit", rev)
+		sources[0].rev = rev
+		return sources, nil
+	}
+	if rev == "" {
+		return nil, fmt.Errorf("%s requires %s", repoVariable, commitVariable)
+	}
+	raw := repo
+	if !strings.Contains(raw, "://") {
+		host := os.Getenv("GITHUB_HOSTNAME")
+		if host == "" {
+			host = "github.com"
+		}
+		raw = "https://" + host + "/" + strings.Trim(raw, "/")
+	}
+	u, uerr := url.Parse(raw)
+	if uerr != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid %s %q", repoVariable, repo)
+	}
+	s := source{artifact: repoVariable, rev: rev, url: u}
+	if s.repo, uerr = extractRepoName(u); uerr != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", repoVariable, repo, uerr)
+	}
+	if err != nil && !errors.Is(err, errNoGitSources) {
+		logger(ctx).Info("ignoring artifacts", "error", err)
+	}
+	logger(ctx).Info("source set by pipeline variables", "repository", s.repo, "commit", rev)
+	return []source{s}, nil
+}
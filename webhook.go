@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline"
+	"github.com/aws/aws-sdk-go-v2/service/codepipeline/types"
+)
+
+// isHTTPRequest reports whether the payload is an HTTP request from a
+// Lambda function URL or an API Gateway HTTP API, and decodes it if so.
+func isHTTPRequest(payload json.RawMessage) (events.LambdaFunctionURLRequest, bool) {
+	var req events.LambdaFunctionURLRequest
+	if err := json.Unmarshal(payload, &req); err != nil || req.Version != "2.0" ||
+		req.RequestContext.HTTP.Method == "" {
+		return req, false
+	}
+	return req, true
+}
+
+// ghWebhook holds the parts of issue_comment and pull_request_review
+// deliveries used to approve or reject manual approvals.
+type ghWebhook struct {
+	Action  string `json:"action"`
+	Comment *struct {
+		Body              string `json:"body"`
+		AuthorAssociation string `json:"author_association"`
+		User              struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	} `json:"comment"`
+	Issue *struct {
+		Number      int              `json:"number"`
+		PullRequest *json.RawMessage `json:"pull_request"`
+	} `json:"issue"`
+	Review *struct {
+		State             string `json:"state"`
+		AuthorAssociation string `json:"author_association"`
+		User              struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	} `json:"review"`
+	PullRequest *ghWebhookPullRequest `json:"pull_request"`
+	Repository  struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+type ghWebhookPullRequest struct {
+	Number int `json:"number"`
+	Head   struct {
+		SHA string `json:"sha"`
+	} `json:"head"`
+}
+
+func (pr *ghWebhookPullRequest) check() error {
+	if pr.Head.SHA == "" {
+		return errors.New("pull request without head sha")
+	}
+	return nil
+}
+
+// approverAssociations are the relations to the repository that allow users
+// to approve or reject via GitHub.
+var approverAssociations = map[string]bool{"OWNER": true, "MEMBER": true, "COLLABORATOR": true}
+
+// approvalDecision is a decision on the manual approvals waiting for a
+// commit, taken on GitHub.
+type approvalDecision struct {
+	repo   string
+	number int
+	// status is Approved or Rejected.
+	status types.ApprovalStatus
+	by     string
+}
+
+// decision returns the decision the delivery carries, or nil if it carries
+// none: an "/approve" or "/reject" comment on a pull request, or an approving
+// review, by the repository's owners, members or collaborators.
+func (w ghWebhook) decision(event string) *approvalDecision {
+	d := approvalDecision{repo: w.Repository.FullName}
+	switch {
+	case event == "issue_comment" && w.Action == "created" && w.Comment != nil &&
+		w.Issue != nil && w.Issue.PullRequest != nil:
+		if !approverAssociations[w.Comment.AuthorAssociation] {
+			return nil
+		}
+		switch strings.TrimSpace(w.Comment.Body) {
+		case "/approve":
+			d.status = types.ApprovalStatusApproved
+		case "/reject":
+			d.status = types.ApprovalStatusRejected
+		default:
+			return nil
+		}
+		d.number, d.by = w.Issue.Number, w.Comment.User.Login
+	case event == "pull_request_review" && w.Action == "submitted" && w.Review != nil &&
+		w.PullRequest != nil:
+		if w.Review.State != "approved" || !approverAssociations[w.Review.AuthorAssociation] {
+			return nil
+		}
+		d.status = types.ApprovalStatusApproved
+		d.number, d.by = w.PullRequest.Number, w.Review.User.Login
+	default:
+		return nil
+	}
+	return &d
+}
+
+// HandleWebhook handles a GitHub webhook delivery, approving or rejecting the
+// manual approvals that the pipelines named by WEBHOOK_PIPELINES are waiting
+// for on the pull request's head commit.
+func (h *Handler) HandleWebhook(ctx context.Context, req events.LambdaFunctionURLRequest) (
+	events.LambdaFunctionURLResponse, error) {
+	l := logger(ctx).With("delivery", req.Headers["x-github-delivery"],
+		"github-event", req.Headers["x-github-event"])
+	ctx = withHTTPClient(withLogger(ctx, l), h.HTTP)
+	respond := func(code int, msg string) (events.LambdaFunctionURLResponse, error) {
+		return events.LambdaFunctionURLResponse{StatusCode: code, Body: msg + "\n"}, nil
+	}
+
+	body := []byte(req.Body)
+	if req.IsBase64Encoded {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(req.Body); err != nil {
+			return respond(http.StatusBadRequest, "invalid body")
+		}
+	}
+	if err := verifySignature(ctx, req.Headers, body); err != nil {
+		reject(ctx, req, "signature", err)
+		return respond(http.StatusUnauthorized, "invalid signature")
+	}
+	if req.Headers["x-github-delivery"] == "" {
+		reject(ctx, req, "delivery", errors.New("missing X-GitHub-Delivery header"))
+		return respond(http.StatusBadRequest, "missing delivery ID")
+	}
+	claimed, done, err := claimDelivery(ctx, req.Headers["x-github-delivery"])
+	if err != nil {
+		l.Error("failed to record delivery", "error", err)
+		return respond(http.StatusInternalServerError, "failed to record delivery")
+	}
+	if !claimed {
+		reject(ctx, req, "replay", errors.New("delivery was already handled"))
+		return respond(http.StatusConflict, "delivery was already handled")
+	}
+	handled := true
+	defer func() { done(handled) }()
+	var w ghWebhook
+	if err := json.Unmarshal(body, &w); err != nil {
+		return respond(http.StatusBadRequest, "invalid payload")
+	}
+	d := w.decision(req.Headers["x-github-event"])
+	if d == nil {
+		return respond(http.StatusOK, "ignored")
+	}
+	l = l.With("repository", d.repo, "pull-request", d.number, "user", d.by)
+	ctx = withLogger(ctx, l)
+
+	n, err := h.decide(ctx, w, d)
+	if err != nil {
+		// GitHub's redelivery of the delivery may succeed.
+		handled = false
+		l.Error("failed to decide on approvals", "error", err)
+		return respond(http.StatusInternalServerError, "failed to decide on approvals")
+	}
+	l.Info("decided on approvals", "decision", string(d.status), "approvals", n)
+	return respond(http.StatusOK, fmt.Sprintf("%s %d approvals", d.status, n))
+}
+
+// decide applies the decision to the approvals waiting for the pull
+// request's head commit, and returns how many there were.
+func (h *Handler) decide(ctx context.Context, w ghWebhook, d *approvalDecision) (int, error) {
+	var ev event
+	if err := ev.resolveToken(ctx, nil); err != nil {
+		return 0, err
+	}
+	pr := w.PullRequest
+	if pr == nil {
+		pr = &ghWebhookPullRequest{}
+		err := getJSON(ctx, fmt.Sprintf("%s/repos/%s/pulls/%d", githubAPIBaseURL(ctx), d.repo, d.number),
+			ev.GithubToken, pr)
+		if err != nil {
+			return 0, fmt.Errorf("failed to look up pull request: %w", err)
+		}
+	}
+
+	decided := 0
+	for _, pipeline := range strings.Split(os.Getenv("WEBHOOK_PIPELINES"), ",") {
+		pipeline = strings.TrimSpace(pipeline)
+		if pipeline == "" {
+			continue
+		}
+		n, err := h.decidePipeline(ctx, pipeline, d, pr.Head.SHA)
+		if err != nil {
+			return decided, fmt.Errorf("pipeline %s: %w", pipeline, err)
+		}
+		decided += n
+	}
+	return decided, nil
+}
+
+// decidePipeline applies the decision to the approvals of the pipeline that
+// are waiting for the commit.
+func (h *Handler) decidePipeline(ctx context.Context, pipeline string, d *approvalDecision,
+	rev string) (int, error) {
+	state, err := h.CodePipeline.GetPipelineState(ctx, &codepipeline.GetPipelineStateInput{
+		Name: aws.String(pipeline),
+	})
+	if err != nil {
+		return 0, err
+	}
+	decided := 0
+	for _, s := range state.StageStates {
+		if s.LatestExecution == nil {
+			continue
+		}
+		for _, a := range s.ActionStates {
+			// Only approvals waiting for a decision have a token.
+			e := a.LatestExecution
+			if e == nil || e.Status != types.ActionExecutionStatusInProgress ||
+				aws.ToString(e.Token) == "" {
+				continue
+			}
+			ok, err := h.executionBuilds(ctx, pipeline,
+				aws.ToString(s.LatestExecution.PipelineExecutionId), d.repo, rev)
+			if err != nil {
+				return decided, err
+			}
+			if !ok {
+				continue
+			}
+			_, err = h.CodePipeline.PutApprovalResult(ctx, &codepipeline.PutApprovalResultInput{
+				PipelineName: aws.String(pipeline),
+				StageName:    s.StageName,
+				ActionName:   a.ActionName,
+				Token:        e.Token,
+				Result: &types.ApprovalResult{
+					Status: d.status,
+					Summary: aws.String(fmt.Sprintf("%s by %s on %s#%d",
+						d.status, d.by, d.repo, d.number)),
+				},
+			})
+			if err != nil {
+				return decided, fmt.Errorf("failed to decide on %s/%s: %w",
+					aws.ToString(s.StageName), aws.ToString(a.ActionName), err)
+			}
+			decided++
+		}
+	}
+	return decided, nil
+}
+
+// executionBuilds reports whether the execution builds the commit of the
+// repository.
+func (h *Handler) executionBuilds(ctx context.Context, pipeline, executionID, repo, rev string) (
+	bool, error) {
+	res, err := h.CodePipeline.GetPipelineExecution(ctx, &codepipeline.GetPipelineExecutionInput{
+		PipelineExecutionId: aws.String(executionID),
+		PipelineName:        aws.String(pipeline),
+	})
+	if err != nil {
+		return false, err
+	}
+	sources, err := findSources(ctx, res.PipelineExecution.ArtifactRevisions,
+		&pipelineDeclaration{cp: h.CodePipeline, pipeline: pipeline, region: awsConfig.Region})
+	if errors.Is(err, errNoGitSources) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, src := range sources {
+		if strings.EqualFold(src.repo, repo) && src.rev == rev {
+			return true, nil
+		}
+	}
+	return false, nil
+}